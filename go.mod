@@ -3,11 +3,17 @@ module github.com/ropenta/golang-api
 go 1.15
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/elithrar/admission-control v0.6.6
 	github.com/go-delve/delve v1.6.0 // indirect
 	github.com/go-kit/kit v0.10.0
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/graphql-go/graphql v0.8.1
 	github.com/johan-lejdung/go-microservice-middleware-guide v0.0.0-20210206111059-601c55c4e6cf // indirect
 	github.com/sirupsen/logrus v1.7.0
 	github.com/urfave/negroni v1.0.0
+	go.etcd.io/bbolt v1.3.6
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhookMaxAttempts = 5
+	webhookQueueSize   = 1024
+	webhookWorkers     = 4
+)
+
+var (
+	// webhookBackoff - the delay before the first retry; it doubles on each further retry
+	webhookBackoff = time.Second
+
+	// webhookClient - delivers alerts; separate from Client, which fetches the feed
+	webhookClient HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+	// alerts - evaluates subscription conditions; nil when alerts are disabled
+	alerts *AlertEngine
+)
+
+// Alert - the body POSTed to a subscription's URL
+type Alert struct {
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscriptionId"`
+	Condition      AlertCondition `json:"condition"`
+	StationID      int            `json:"stationId"`
+	StationName    string         `json:"stationName"`
+	Since          time.Time      `json:"since"`
+	ExecutionTime  string         `json:"executionTime"`
+}
+
+// alertKey - one subscription's condition at one station
+type alertKey struct {
+	subscriptionID string
+	stationID      int
+	condition      AlertConditionType
+}
+
+// conditionKey - one condition at one station
+type conditionKey struct {
+	stationID int
+	condition AlertConditionType
+}
+
+// webhookDelivery - an alert on its way to a subscription, and how many times it has been tried
+type webhookDelivery struct {
+	subscription Subscription
+	alert        Alert
+	attempt      int
+}
+
+// AlertEngine - tracks how long each station has been in each condition and
+// queues an alert the first time a subscription's condition has held long enough
+type AlertEngine struct {
+	mu     sync.Mutex
+	store  *SubscriptionStore
+	since  map[conditionKey]time.Time
+	fired  map[alertKey]time.Time
+	queue  chan webhookDelivery
+	active sync.WaitGroup
+}
+
+/*
+ * 	Creates an engine delivering alerts for the subscriptions in store
+ */
+func newAlertEngine(store *SubscriptionStore) *AlertEngine {
+	return &AlertEngine{
+		store: store,
+		since: map[conditionKey]time.Time{},
+		fired: map[alertKey]time.Time{},
+		queue: make(chan webhookDelivery, webhookQueueSize),
+	}
+}
+
+/*
+ * 	Returns when each condition that currently holds for station began. Empty,
+ * 	full and not-in-service start when they are first seen; stale starts at the
+ * 	station's last communication.
+ */
+func (e *AlertEngine) conditionsOf(station Station, now time.Time) map[AlertConditionType]time.Time {
+	holds := map[AlertConditionType]bool{
+		ConditionNotInService: station.StatusValue == notInServiceStatus,
+		ConditionEmpty:        station.StatusValue == inServiceStatus && station.AvailableBikes == 0,
+		ConditionFull:         station.StatusValue == inServiceStatus && station.AvailableDocks == 0,
+	}
+	conditions := map[AlertConditionType]time.Time{}
+	for condition, held := range holds {
+		key := conditionKey{stationID: station.ID, condition: condition}
+		if !held {
+			delete(e.since, key)
+			continue
+		}
+		if _, ok := e.since[key]; !ok {
+			e.since[key] = now
+		}
+		conditions[condition] = e.since[key]
+	}
+	if !station.LastCommunicationTime.IsZero() {
+		conditions[ConditionStale] = station.LastCommunicationTime
+	}
+	return conditions
+}
+
+/*
+ * 	Checks every subscription against a refreshed snapshot and queues an alert
+ * 	for each condition that has now held for at least its minutes. A condition
+ * 	alerts once until it clears; stale alerts again after a later communication
+ * 	goes stale.
+ */
+func (e *AlertEngine) Evaluate(data StationData) {
+	now, err := parseExecutionTime(data.ExecutionTime)
+	if err != nil {
+		now = time.Now()
+	}
+	list := e.store.List()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	subscribed := map[string]bool{}
+	for _, subscription := range list {
+		subscribed[subscription.ID] = true
+	}
+	for key := range e.fired {
+		if !subscribed[key.subscriptionID] {
+			delete(e.fired, key)
+		}
+	}
+	for _, station := range data.StationBeanList {
+		conditions := e.conditionsOf(station, now)
+		for _, subscription := range list {
+			if !subscription.watches(station.ID) {
+				continue
+			}
+			for _, condition := range subscription.Conditions {
+				key := alertKey{subscriptionID: subscription.ID, stationID: station.ID, condition: condition.Type}
+				since, held := conditions[condition.Type]
+				if !held {
+					delete(e.fired, key)
+					continue
+				}
+				if now.Sub(since) < time.Duration(condition.Minutes)*time.Minute {
+					continue
+				}
+				if fired, ok := e.fired[key]; ok && fired.Equal(since) {
+					continue
+				}
+				e.fired[key] = since
+				e.enqueue(webhookDelivery{subscription: subscription, alert: Alert{
+					SubscriptionID: subscription.ID,
+					Condition:      condition,
+					StationID:      station.ID,
+					StationName:    station.StationName,
+					Since:          since,
+					ExecutionTime:  data.ExecutionTime,
+				}, attempt: 1})
+			}
+		}
+	}
+}
+
+/*
+ * 	Returns whether the subscription covers stationID
+ */
+func (subscription Subscription) watches(stationID int) bool {
+	if len(subscription.StationIDs) == 0 {
+		return true
+	}
+	for _, id := range subscription.StationIDs {
+		if id == stationID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * 	Queues a delivery without blocking; when the queue is full the alert goes
+ * 	straight to the dead-letter log
+ */
+func (e *AlertEngine) enqueue(delivery webhookDelivery) {
+	if delivery.alert.ID == "" {
+		delivery.alert.ID, _ = randomHex(16)
+	}
+	e.active.Add(1)
+	select {
+	case e.queue <- delivery:
+	default:
+		e.deadLetter(delivery, fmt.Errorf("delivery queue is full"))
+	}
+}
+
+/*
+ * 	Records a delivery that will not be retried
+ */
+func (e *AlertEngine) deadLetter(delivery webhookDelivery, deliveryErr error) {
+	defer e.active.Done()
+	log.WithFields(log.Fields{"subscription": delivery.subscription.ID, "alert": delivery.alert.ID}).Warn("Giving up on webhook delivery", deliveryErr)
+	err := e.store.AddDeadLetter(DeadLetter{
+		Alert:    delivery.alert,
+		Attempts: delivery.attempt,
+		Error:    deliveryErr.Error(),
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Error("Error storing dead letter", err)
+	}
+}
+
+/*
+ * 	Returns the signature sent in X-Webhook-Signature: the hex HMAC-SHA256 of
+ * 	"timestamp.body" keyed with the subscription's secret
+ */
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+ * 	POSTs one alert, returning an error unless the receiver answers 2xx
+ */
+func postWebhook(delivery webhookDelivery) error {
+	body, err := json.Marshal(delivery.alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", delivery.subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", delivery.alert.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(delivery.subscription.Secret, timestamp, body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return nil
+}
+
+/*
+ * 	Tries a delivery once, scheduling a retry after an exponentially growing
+ * 	delay until webhookMaxAttempts is reached. Deliveries for subscriptions
+ * 	deleted in the meantime are dropped.
+ */
+func (e *AlertEngine) deliver(delivery webhookDelivery) {
+	if _, ok := e.store.Get(delivery.subscription.ID); !ok {
+		e.active.Done()
+		return
+	}
+	err := postWebhook(delivery)
+	if err == nil {
+		e.active.Done()
+		return
+	}
+	if delivery.attempt >= webhookMaxAttempts {
+		e.deadLetter(delivery, err)
+		return
+	}
+	delay := webhookBackoff << uint(delivery.attempt-1)
+	delivery.attempt++
+	time.AfterFunc(delay, func() {
+		select {
+		case e.queue <- delivery:
+		default:
+			e.deadLetter(delivery, fmt.Errorf("delivery queue is full"))
+		}
+	})
+}
+
+/*
+ * 	Delivers queued alerts with webhookWorkers workers until stop is closed
+ */
+func (e *AlertEngine) Run(stop <-chan struct{}) {
+	var workers sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case delivery := <-e.queue:
+					e.deliver(delivery)
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	workers.Wait()
+}
+
+/*
+ * 	Waits until every queued alert has been delivered or dead-lettered
+ */
+func (e *AlertEngine) Wait() {
+	e.active.Wait()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver - records the alerts POSTed to it, answering with the next status in statuses
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	alerts   []Alert
+}
+
+/*
+ * 	Starts a receiver that checks each delivery's signature against secret
+ */
+func newWebhookReceiver(t *testing.T, secret string, statuses ...int) (*webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if signature := signWebhook(secret, req.Header.Get("X-Webhook-Timestamp"), body); req.Header.Get("X-Webhook-Signature") != signature {
+			t.Errorf("Expected signature %s, but received %s", signature, req.Header.Get("X-Webhook-Signature"))
+		}
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		status := http.StatusOK
+		if receiver.attempts < len(receiver.statuses) {
+			status = receiver.statuses[receiver.attempts]
+		}
+		receiver.attempts++
+		if status == http.StatusOK {
+			alert := Alert{}
+			json.Unmarshal(body, &alert)
+			receiver.alerts = append(receiver.alerts, alert)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return receiver, server
+}
+
+/*
+ * 	Creates an alert engine for one subscription and starts its workers
+ */
+func startTestAlertEngine(t *testing.T, subscription Subscription) *AlertEngine {
+	store := openTestSubscriptionStore(t)
+	if err := store.Create(subscription); err != nil {
+		t.Fatal(err)
+	}
+	engine := newAlertEngine(store)
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go engine.Run(stop)
+	return engine
+}
+
+func TestAlertEngineConditions(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, "s3cret")
+	engine := startTestAlertEngine(t, Subscription{
+		ID:         "ops",
+		URL:        server.URL,
+		Secret:     "s3cret",
+		Conditions: []AlertCondition{{Type: ConditionNotInService}, {Type: ConditionEmpty, Minutes: 10}},
+		StationIDs: []int{423, 82},
+	})
+
+	engine.Evaluate(fixtureStationData(t))
+	engine.Evaluate(fixtureStationData(t, "04:32:49 PM", "04:40:00 PM"))
+	engine.Evaluate(fixtureStationData(t, "04:32:49 PM", "04:45:00 PM"))
+	engine.Wait()
+
+	if len(receiver.alerts) != 2 {
+		t.Fatalf("Expected 2 alerts, but received %+v", receiver.alerts)
+	}
+	sort.Slice(receiver.alerts, func(i, j int) bool { return receiver.alerts[i].ExecutionTime < receiver.alerts[j].ExecutionTime })
+	outOfService, empty := receiver.alerts[0], receiver.alerts[1]
+	if outOfService.StationID != 423 || outOfService.Condition.Type != ConditionNotInService || outOfService.ExecutionTime != "2016-01-22 04:32:49 PM" {
+		t.Errorf("Expected station 423 to alert as not in service immediately, but received %+v", outOfService)
+	}
+	if empty.StationID != 82 || empty.Condition.Type != ConditionEmpty || empty.ExecutionTime != "2016-01-22 04:45:00 PM" || empty.Since.Format(time.Kitchen) != "4:32PM" {
+		t.Errorf("Expected station 82 to alert as empty since 4:32PM at 04:45:00 PM, but received %+v", empty)
+	}
+}
+
+func TestAlertEngineStale(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, "s3cret")
+	engine := startTestAlertEngine(t, Subscription{ID: "ops", URL: server.URL, Secret: "s3cret", Conditions: []AlertCondition{{Type: ConditionStale, Minutes: 60}}})
+
+	engine.Evaluate(fixtureStationData(t))
+	engine.Evaluate(fixtureStationData(t, "04:32:49 PM", "04:40:00 PM"))
+	engine.Wait()
+	if len(receiver.alerts) != 1 || receiver.alerts[0].StationID != 423 {
+		t.Errorf("Expected only station 423 to alert as stale once, but received %+v", receiver.alerts)
+	}
+}
+
+func TestAlertEngineRetries(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = time.Second }()
+	receiver, server := newWebhookReceiver(t, "s3cret", http.StatusInternalServerError, http.StatusBadGateway)
+	engine := startTestAlertEngine(t, Subscription{ID: "ops", URL: server.URL, Secret: "s3cret", Conditions: []AlertCondition{{Type: ConditionNotInService}}})
+
+	engine.Evaluate(fixtureStationData(t))
+	engine.Wait()
+	if receiver.attempts != 3 || len(receiver.alerts) != 1 {
+		t.Errorf("Expected the alert to arrive on the third attempt, but received %d attempts", receiver.attempts)
+	}
+	if deadLetters, _ := engine.store.DeadLetters("ops"); len(deadLetters) != 0 {
+		t.Errorf("Expected no dead letters, but received %+v", deadLetters)
+	}
+}
+
+func TestAlertEngineDeadLetters(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = time.Second }()
+	statuses := make([]int, webhookMaxAttempts)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	receiver, server := newWebhookReceiver(t, "s3cret", statuses...)
+	engine := startTestAlertEngine(t, Subscription{ID: "ops", URL: server.URL, Secret: "s3cret", Conditions: []AlertCondition{{Type: ConditionNotInService}}})
+
+	engine.Evaluate(fixtureStationData(t))
+	engine.Wait()
+	deadLetters, err := engine.store.DeadLetters("ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receiver.attempts != webhookMaxAttempts || len(deadLetters) != 1 || deadLetters[0].Attempts != webhookMaxAttempts || deadLetters[0].Alert.StationID != 423 {
+		t.Errorf("Expected station 423's alert to be dead-lettered after %d attempts, but received %+v", webhookMaxAttempts, deadLetters)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	apiKeyPrefix = "sk_"
+
+	// bootstrapKeyID - the id of the admin key taken from the environment, which is never stored
+	bootstrapKeyID = "bootstrap"
+)
+
+var (
+	apiKeysBucket = []byte("apiKeys")
+
+	// apiKeys - issued API keys; nil when authentication is disabled
+	apiKeys *KeyStore
+)
+
+// APIKey - an issued key. Only the SHA-256 hash of the key itself is kept.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	Quota     int        `json:"quota"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// quotaUsage - requests made with one key on one UTC day
+type quotaUsage struct {
+	day   string
+	count int
+}
+
+// KeyStore - API keys in a BoltDB file, indexed in memory by hash, with daily usage counted in memory
+type KeyStore struct {
+	db     *bolt.DB
+	mu     sync.Mutex
+	keys   map[string]APIKey
+	hashes map[string]string
+	usage  map[string]quotaUsage
+}
+
+/*
+ * 	Returns the stored form of a key
+ */
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+ * 	Returns a new random key
+ */
+func newAPIKeySecret() (string, error) {
+	secret, err := randomHex(24)
+	return apiKeyPrefix + secret, err
+}
+
+/*
+ * 	Opens (creating if needed) the key database at path and loads its keys. A
+ * 	non-empty bootstrapKey is accepted as an admin key so the first keys can be
+ * 	created.
+ */
+func OpenKeyStore(path, bootstrapKey string) (*KeyStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &KeyStore{db: db, keys: map[string]APIKey{}, hashes: map[string]string{}, usage: map[string]quotaUsage{}}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(apiKeysBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			key := APIKey{}
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			s.index(key)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if bootstrapKey != "" {
+		s.index(APIKey{ID: bootstrapKeyID, Name: bootstrapKeyID, Hash: hashAPIKey(bootstrapKey), Scopes: []string{ScopeAdmin}})
+	}
+	return s, nil
+}
+
+/*
+ * 	Closes the underlying database file
+ */
+func (s *KeyStore) Close() error {
+	return s.db.Close()
+}
+
+/*
+ * 	Adds key to the in-memory indexes, replacing any earlier version of it
+ */
+func (s *KeyStore) index(key APIKey) {
+	if previous, ok := s.keys[key.ID]; ok {
+		delete(s.hashes, previous.Hash)
+	}
+	s.keys[key.ID] = key
+	if key.RevokedAt == nil {
+		s.hashes[key.Hash] = key.ID
+	}
+}
+
+/*
+ * 	Writes key to the database and the indexes; s.mu must be held
+ */
+func (s *KeyStore) put(key APIKey) error {
+	keyMarshal, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(key.ID), keyMarshal)
+	})
+	if err == nil {
+		s.index(key)
+	}
+	return err
+}
+
+/*
+ * 	Issues a new key, returning it with the secret the caller must keep
+ */
+func (s *KeyStore) Create(name string, scopes []string, quota int) (APIKey, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{ID: id, Name: name, Hash: hashAPIKey(secret), Scopes: scopes, Quota: quota, CreatedAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return key, secret, s.put(key)
+}
+
+/*
+ * 	Replaces a key's secret, keeping its id, scopes and quota. The old secret
+ * 	stops working immediately. Returns false if the key does not exist or is revoked.
+ */
+func (s *KeyStore) Rotate(id string) (APIKey, string, bool, error) {
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return APIKey{}, "", false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.RevokedAt != nil || id == bootstrapKeyID {
+		return APIKey{}, "", false, nil
+	}
+	now := time.Now().UTC()
+	key.Hash, key.RotatedAt = hashAPIKey(secret), &now
+	return key, secret, true, s.put(key)
+}
+
+/*
+ * 	Revokes a key; it stays listed but no longer authenticates. Returns false if
+ * 	the key does not exist or is already revoked.
+ */
+func (s *KeyStore) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.RevokedAt != nil || id == bootstrapKeyID {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	return true, s.put(key)
+}
+
+/*
+ * 	Returns every stored key, oldest first, without hashes
+ */
+func (s *KeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []APIKey{}
+	for _, key := range s.keys {
+		if key.ID != bootstrapKeyID {
+			key.Hash = ""
+			list = append(list, key)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+/*
+ * 	Returns the unrevoked key whose secret is credential
+ */
+func (s *KeyStore) Authenticate(credential string) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.hashes[hashAPIKey(credential)]
+	if !ok {
+		return APIKey{}, false
+	}
+	return s.keys[id], true
+}
+
+/*
+ * 	Counts one request against key's daily quota, returning how many remain
+ * 	and whether this request is allowed. Keys with no quota are unlimited.
+ */
+func (s *KeyStore) UseQuota(key APIKey) (int, bool) {
+	if key.Quota <= 0 {
+		return 0, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := time.Now().UTC().Format("2006-01-02")
+	usage := s.usage[key.ID]
+	if usage.day != day {
+		usage = quotaUsage{day: day}
+	}
+	if usage.count >= key.Quota {
+		return 0, false
+	}
+	usage.count++
+	s.usage[key.ID] = usage
+	return key.Quota - usage.count, true
+}
+
+/*
+ * 	Returns the seconds until quotas reset at midnight UTC
+ */
+func secondsUntilQuotaReset() int {
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(midnight.Sub(now).Seconds()) + 1
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKeyRequest - the body of POST /keys
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Quota  int      `json:"quota"`
+}
+
+// IssuedAPIKey - a created or rotated key together with its secret, which is only shown once
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+/*
+ * 	Checks a key request, returning a description of the first problem
+ */
+func validateAPIKeyRequest(keyRequest APIKeyRequest) error {
+	if keyRequest.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(keyRequest.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range keyRequest.Scopes {
+		if scope != ScopeReadStations && scope != ScopeAdmin {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if keyRequest.Quota < 0 {
+		return fmt.Errorf("quota must not be negative")
+	}
+	return nil
+}
+
+/*
+ * 	Writes a 503 and returns false when authentication is disabled
+ */
+func apiKeysEnabled(w http.ResponseWriter, l localizer) bool {
+	if apiKeys == nil {
+		http.Error(w, l.Message("keys.disabled", nil), http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+ *	Endpoint: POST /keys
+ *
+ *	Body: {"name": "dashboard", "scopes": ["read:stations"], "quota": 10000}.
+ *	Quota is requests per UTC day; 0 means unlimited. The key is only returned
+ *	by this request.
+ */
+func createAPIKey(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing createAPIKey entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !apiKeysEnabled(w, l) {
+		return
+	}
+
+	keyRequest := APIKeyRequest{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&keyRequest)
+	if err == nil {
+		err = validateAPIKeyRequest(keyRequest)
+	}
+	if err != nil {
+		http.Error(w, l.Message("keys.invalid", MessageArgs{"error": err.Error()}), http.StatusBadRequest)
+		return
+	}
+
+	key, secret, err := apiKeys.Create(keyRequest.Name, keyRequest.Scopes, keyRequest.Quota)
+	if err != nil {
+		contextLogger.Error("Error storing API key", err)
+		http.Error(w, l.Message("keys.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	key.Hash = ""
+	w.Header().Set("Location", "/keys/"+key.ID)
+	writeJSON(w, req, http.StatusCreated, IssuedAPIKey{APIKey: key, Key: secret}, contextLogger)
+}
+
+/*
+ *	Endpoint: GET /keys
+ *
+ *	Lists every key, including revoked ones, without secrets
+ */
+func listAPIKeys(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing listAPIKeys entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !apiKeysEnabled(w, l) {
+		return
+	}
+	writeJSON(w, req, http.StatusOK, apiKeys.List(), contextLogger)
+}
+
+/*
+ *	Endpoint: POST /keys/:id/rotate
+ *
+ *	Issues a new secret for the key; the old one stops working immediately
+ */
+func rotateAPIKey(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing rotateAPIKey entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"id":   mux.Vars(req)["id"],
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !apiKeysEnabled(w, l) {
+		return
+	}
+
+	key, secret, ok, err := apiKeys.Rotate(mux.Vars(req)["id"])
+	if err != nil {
+		contextLogger.Error("Error rotating API key", err)
+		http.Error(w, l.Message("keys.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, l.Message("keys.notFound", nil), http.StatusNotFound)
+		return
+	}
+	key.Hash = ""
+	writeJSON(w, req, http.StatusOK, IssuedAPIKey{APIKey: key, Key: secret}, contextLogger)
+}
+
+/*
+ *	Endpoint: DELETE /keys/:id
+ *
+ *	Revokes the key
+ */
+func revokeAPIKey(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing revokeAPIKey entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"id":   mux.Vars(req)["id"],
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !apiKeysEnabled(w, l) {
+		return
+	}
+
+	revoked, err := apiKeys.Revoke(mux.Vars(req)["id"])
+	if err != nil {
+		contextLogger.Error("Error revoking API key", err)
+		http.Error(w, l.Message("keys.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, l.Message("keys.notFound", nil), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+ * 	Enables authentication with a fresh key store accepting "admin-secret" as an admin key
+ */
+func enableTestAPIKeys(t *testing.T) {
+	keyStore, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys.db"), "admin-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = keyStore
+	t.Cleanup(func() {
+		apiKeys = nil
+		keyStore.Close()
+	})
+}
+
+/*
+ * 	Serves one request through the router, sending credential as a bearer token if not empty
+ */
+func serveWithKey(t *testing.T, method, path, credential, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	return w
+}
+
+/*
+ * 	Creates a key through the admin endpoint and returns it with its secret
+ */
+func issueTestKey(t *testing.T, body string) IssuedAPIKey {
+	w := serveWithKey(t, "POST", "/keys", "admin-secret", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusCreated)
+	}
+	issued := IssuedAPIKey{}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil {
+		t.Fatal(err)
+	}
+	return issued
+}
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	keyStore, err := OpenKeyStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, secret, err := keyStore.Create("dashboard", []string{ScopeReadStations}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore.Close()
+
+	keyStore, err = OpenKeyStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyStore.Close()
+	if authenticated, ok := keyStore.Authenticate(secret); !ok || authenticated.ID != key.ID {
+		t.Errorf("Expected the key to authenticate after a restart")
+	}
+	keyStore.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(k, v []byte) error {
+			if bytes.Contains(v, []byte(secret)) {
+				t.Errorf("Expected only the key's hash to be stored, but found the key in %s", v)
+			}
+			return nil
+		})
+	})
+
+	_, rotated, ok, err := keyStore.Rotate(key.ID)
+	if !ok || err != nil {
+		t.Fatalf("Expected the key to rotate, but received %v (%v)", ok, err)
+	}
+	if _, ok := keyStore.Authenticate(secret); ok {
+		t.Errorf("Expected the old secret to stop working after rotation")
+	}
+	if revoked, _ := keyStore.Revoke(key.ID); !revoked {
+		t.Fatalf("Expected the key to be revoked")
+	}
+	if _, ok := keyStore.Authenticate(rotated); ok {
+		t.Errorf("Expected a revoked key not to authenticate")
+	}
+	if list := keyStore.List(); len(list) != 1 || list[0].RevokedAt == nil || list[0].Hash != "" {
+		t.Errorf("Expected the revoked key to be listed without its hash, but received %+v", list)
+	}
+}
+
+func TestKeyStoreQuota(t *testing.T) {
+	enableTestAPIKeys(t)
+	key := APIKey{ID: "k", Quota: 2}
+	for i, expected := range []struct {
+		remaining int
+		allowed   bool
+	}{{1, true}, {0, true}, {0, false}} {
+		if remaining, allowed := apiKeys.UseQuota(key); remaining != expected.remaining || allowed != expected.allowed {
+			t.Errorf("Request %d: expected %d remaining and allowed %v, but received %d and %v", i+1, expected.remaining, expected.allowed, remaining, allowed)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	enableTestAPIKeys(t)
+	mockStationFeed(allStationsJSON)
+
+	if w := serveWithKey(t, "GET", "/stations", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected a public route to allow anonymous access, but received %v", w.Code)
+	}
+	if w := serveWithKey(t, "GET", "/stations/72/5", "", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected 401 with WWW-Authenticate without a key, but received %v", w.Code)
+	}
+	if w := serveWithKey(t, "GET", "/stations", "sk_unknown", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid key to be rejected on a public route, but received %v", w.Code)
+	}
+
+	reader := issueTestKey(t, `{"name":"dashboard","scopes":["read:stations"]}`)
+	if !strings.HasPrefix(reader.Key, apiKeyPrefix) || reader.Hash != "" {
+		t.Errorf("Expected a key to be returned without its hash, but received %+v", reader)
+	}
+	req, _ := http.NewRequest("GET", "/stations/72/5", nil)
+	req.Header.Set("X-API-Key", reader.Key)
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the key to be accepted from X-API-Key, but received %v", w.Code)
+	}
+	if w := serveWithKey(t, "GET", "/keys", reader.Key, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a read key to be refused admin routes, but received %v", w.Code)
+	}
+
+	w = serveWithKey(t, "POST", "/keys/"+reader.ID+"/rotate", "admin-secret", "")
+	rotated := IssuedAPIKey{}
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+	if w.Code != http.StatusOK || rotated.ID != reader.ID || rotated.Key == reader.Key {
+		t.Errorf("Expected a new key with the same id, but received %v %+v", w.Code, rotated)
+	}
+	if w := serveWithKey(t, "GET", "/stations/72/5", reader.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old key to be rejected after rotation, but received %v", w.Code)
+	}
+	if w := serveWithKey(t, "DELETE", "/keys/"+reader.ID, "admin-secret", ""); w.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusNoContent)
+	}
+	if w := serveWithKey(t, "GET", "/stations/72/5", rotated.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be rejected, but received %v", w.Code)
+	}
+}
+
+func TestAuthorizeWithoutAuthentication(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	subscriptions = openTestSubscriptionStore(t)
+	defer func() { subscriptions = nil }()
+
+	if w := serveWithKey(t, "GET", "/stations/72/5", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected read routes to be open without authentication, but received %v", w.Code)
+	}
+	for _, route := range []struct{ method, path string }{{"POST", "/subscriptions"}, {"GET", "/subscriptions"}, {"GET", "/keys"}} {
+		w := serveWithKey(t, route.method, route.path, "anything", `{"url":"https://example.com/hook","conditions":[{"type":"empty"}]}`)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: Expected admin routes to be refused without authentication, but received %v", route.method, route.path, w.Code)
+		}
+	}
+	if list := subscriptions.List(); len(list) != 0 {
+		t.Errorf("Expected no subscription to be created, but received %+v", list)
+	}
+}
+
+func TestAuthorizeQuota(t *testing.T) {
+	enableTestAPIKeys(t)
+	mockStationFeed(allStationsJSON)
+	limited := issueTestKey(t, `{"name":"batch","scopes":["read:stations"],"quota":2}`)
+
+	for i := 0; i < 2; i++ {
+		if w := serveWithKey(t, "GET", "/stations/72/5", limited.Key, ""); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != []string{"1", "0"}[i] {
+			t.Errorf("Request %d: expected 200 within the quota, but received %v with %s remaining", i+1, w.Code, w.Header().Get("X-Quota-Remaining"))
+		}
+	}
+	w := serveWithKey(t, "GET", "/stations/72/5", limited.Key, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After once the quota is used, but received %v", w.Code)
+	}
+}
+
+func TestCreateAPIKeyInvalid(t *testing.T) {
+	enableTestAPIKeys(t)
+	for _, body := range []string{`{"scopes":["admin"]}`, `{"name":"x","scopes":["write:stations"]}`, `{"name":"x","scopes":[]}`, `{"name":"x","scopes":["admin"],"quota":-1}`} {
+		if w := serveWithKey(t, "POST", "/keys", "admin-secret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, but received %v", body, w.Code)
+		}
+	}
+}
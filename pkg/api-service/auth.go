@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ScopeReadStations - read station data, history and forecasts
+	ScopeReadStations = "read:stations"
+	// ScopeAdmin - manage API keys and webhook subscriptions; implies every other scope
+	ScopeAdmin = "admin"
+
+	// scopePublic - routes anyone may call without credentials
+	scopePublic = ""
+)
+
+// Principal - the authenticated caller of a request
+type Principal struct {
+	Subject string
+	Issuer  string
+	KeyID   string
+	Scopes  []string
+}
+
+// principalContextKey - the context key a request's Principal is stored under
+type principalContextKey struct{}
+
+/*
+ * 	Returns whether the principal was granted scope, directly or through admin
+ */
+func (p Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * 	Returns the principal authenticated for req, if any
+ */
+func principalFrom(req *http.Request) (Principal, bool) {
+	principal, ok := req.Context().Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+/*
+ * 	Returns the API key sent in X-API-Key or as an Authorization bearer token
+ */
+func requestCredential(req *http.Request) string {
+	if key := req.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if authorization := req.Header.Get("Authorization"); len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+/*
+ * 	Wraps handler so it only runs for callers holding scope; scopePublic routes
+ * 	also accept anonymous callers. Callers are identified by a principal that
+ * 	JWT middleware already put in the context, or else by an API key. Keys that
+ * 	are sent are always checked, and each request made with one counts against
+ * 	its quota. Without API keys or JWT verification no caller can prove it
+ * 	holds admin, so admin routes are refused and every other route is public.
+ */
+func authorize(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		l := newLocalizer(req)
+		if apiKeys == nil && jwtVerifier == nil {
+			if scope == ScopeAdmin {
+				l.SetHeader(w)
+				http.Error(w, l.Message("auth.adminDisabled", nil), http.StatusForbidden)
+				return
+			}
+			handler(w, req)
+			return
+		}
+		var key APIKey
+		principal, authenticated := principalFrom(req)
+		if !authenticated {
+			credential := requestCredential(req)
+			if credential == "" {
+				if scope == scopePublic {
+					handler(w, req)
+					return
+				}
+				l.SetHeader(w)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="stations"`)
+				http.Error(w, l.Message("auth.missing", nil), http.StatusUnauthorized)
+				return
+			}
+
+			if apiKeys != nil {
+				key, authenticated = apiKeys.Authenticate(credential)
+			}
+			if !authenticated {
+				l.SetHeader(w)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="stations", error="invalid_token"`)
+				http.Error(w, l.Message("auth.invalid", nil), http.StatusUnauthorized)
+				return
+			}
+			principal = Principal{Subject: key.Name, KeyID: key.ID, Scopes: key.Scopes}
+		}
+
+		if scope != scopePublic && !principal.HasScope(scope) {
+			l.SetHeader(w)
+			http.Error(w, l.Message("auth.forbidden", MessageArgs{"scope": scope}), http.StatusForbidden)
+			return
+		}
+		if key.ID != "" && !allowQuota(w, key, l) {
+			return
+		}
+		handler(w, req.WithContext(context.WithValue(req.Context(), principalContextKey{}, principal)))
+	}
+}
+
+/*
+ * 	Counts a request against key's quota, setting X-Quota-* headers, and answers
+ * 	429 and returns false once the quota is used up
+ */
+func allowQuota(w http.ResponseWriter, key APIKey, l localizer) bool {
+	remaining, allowed := apiKeys.UseQuota(key)
+	if key.Quota <= 0 {
+		return true
+	}
+	w.Header().Set("X-Quota-Limit", strconv.Itoa(key.Quota))
+	w.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+	if !allowed {
+		l.SetHeader(w)
+		w.Header().Set("Retry-After", strconv.Itoa(secondsUntilQuotaReset()))
+		http.Error(w, l.Message("auth.quotaExceeded", MessageArgs{"quota": key.Quota}), http.StatusTooManyRequests)
+	}
+	return allowed
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxBulkDockableItems = 100
+	// maxBulkDockableBytes - room for maxBulkDockableItems pretty-printed requests
+	maxBulkDockableBytes = 64 << 10
+)
+
+var errTooManyDockableRequests = errors.New("more than maxBulkDockableItems requests")
+
+// DockableRequest - one station and bike count in a bulk dockable check
+type DockableRequest struct {
+	StationID int `json:"stationId"`
+	Bikes     int `json:"bikes"`
+}
+
+// DockableResult - the outcome for one DockableRequest: the DockableInfo once
+// the batch is evaluated, or the INVALID_COUNT or STATION_UNKNOWN reason of an
+// invalid request. Valid requests in a rejected batch carry only their id.
+type DockableResult struct {
+	StationID int `json:"stationId"`
+	*DockableInfo
+}
+
+/*
+ * 	Checks every request against the snapshot without evaluating any of them.
+ * 	Returns one result per request and whether all of them are valid.
+ */
+func validateDockableRequests(stations []Station, dockableRequests []DockableRequest, l localizer) ([]DockableResult, bool) {
+	valid := true
+	results := make([]DockableResult, len(dockableRequests))
+	for i, dockableRequest := range dockableRequests {
+		results[i] = DockableResult{StationID: dockableRequest.StationID}
+		var dockableInfo DockableInfo
+		if dockableRequest.Bikes <= 0 {
+			dockableInfo = dockableError(ReasonInvalidCount, dockableRequest.Bikes, l)
+		} else if _, found := findStation(stations, strconv.Itoa(dockableRequest.StationID)); !found {
+			dockableInfo = dockableError(ReasonStationUnknown, dockableRequest.Bikes, l)
+		} else {
+			continue
+		}
+		results[i].DockableInfo = &dockableInfo
+		valid = false
+	}
+	return results, valid
+}
+
+/*
+ * 	Decodes a JSON list of requests one item at a time, stopping with
+ * 	errTooManyDockableRequests as soon as there are more than
+ * 	maxBulkDockableItems instead of reading the whole list
+ */
+func decodeDockableRequests(body io.Reader) ([]DockableRequest, error) {
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, errors.New("expected a list")
+	}
+	var dockableRequests []DockableRequest
+	for decoder.More() {
+		if len(dockableRequests) == maxBulkDockableItems {
+			return nil, errTooManyDockableRequests
+		}
+		var dockableRequest DockableRequest
+		if err := decoder.Decode(&dockableRequest); err != nil {
+			return nil, err
+		}
+		dockableRequests = append(dockableRequests, dockableRequest)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return dockableRequests, nil
+}
+
+/*
+ * 	Reports whether err came from an http.MaxBytesReader that hit its limit;
+ * 	Go 1.15 has no typed error for it
+ */
+func bodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+/*
+ *	Endpoint: POST /stations/dockable
+ *
+ *	Takes a JSON list of {stationId, bikes} pairs and returns a DockableInfo for
+ *	each one, all evaluated against the same snapshot. The list is validated
+ *	before anything is evaluated; if any entry is invalid the response is a 400
+ *	carrying the reason for each invalid entry and nothing else. Lists of more
+ *	than maxBulkDockableItems entries are 400 and bodies over
+ *	maxBulkDockableBytes 413, without reading the rest of the body.
+ */
+func bulkDockable(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing bulkDockable entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	dockableRequests, decodeErr := decodeDockableRequests(http.MaxBytesReader(w, req.Body, maxBulkDockableBytes))
+	if bodyTooLarge(decodeErr) {
+		http.Error(w, l.Message("bulk.tooLarge", MessageArgs{"max": maxBulkDockableItems}), http.StatusRequestEntityTooLarge)
+		contextLogger.Warn("Bulk dockable request body is too large")
+		return
+	}
+	if decodeErr != nil && decodeErr != errTooManyDockableRequests {
+		http.Error(w, l.Message("bulk.invalidBody", nil), http.StatusBadRequest)
+		contextLogger.Error("Invalid bulk dockable request body", decodeErr)
+		return
+	}
+	if decodeErr == errTooManyDockableRequests || len(dockableRequests) == 0 {
+		http.Error(w, l.Message("bulk.invalidSize", MessageArgs{"max": maxBulkDockableItems}), http.StatusBadRequest)
+		contextLogger.Warn("Bulk dockable request has too few or too many stations")
+		return
+	}
+
+	stationData, err := store.Snapshot()
+	if err != nil {
+		contextLogger.Error("Error retrieving stations", err)
+		http.Error(w, l.Message("stations.unavailable", nil), http.StatusBadGateway)
+		return
+	}
+	stations := stationData.StationBeanList
+
+	results, valid := validateDockableRequests(stations, dockableRequests, l)
+	if valid {
+		for i := range results {
+			station, _ := findStation(stations, strconv.Itoa(results[i].StationID))
+			dockableInfo := checkDockable(stations, station, dockableRequests[i].Bikes, l)
+			results[i].DockableInfo = &dockableInfo
+		}
+	}
+
+	status := http.StatusOK
+	if !valid {
+		contextLogger.Warn("Bulk dockable request contained invalid entries")
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, req, status, results, contextLogger)
+}
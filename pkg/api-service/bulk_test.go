@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkDockable(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	body := bytes.NewBufferString(`[{"stationId": 83, "bikes": 20}, {"stationId": 72, "bikes": 33}]`)
+	req, err := http.NewRequest("POST", "/stations/dockable?pretty=true", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `[
+    {
+        "stationId": 83,
+        "dockable": true,
+        "message": "You are able to return all 20 of your bikes. There are 21 available docks.",
+        "reason": "OK",
+        "stationStatus": "In Service",
+        "availableDocks": 21,
+        "requested": 20,
+        "shortfall": 0
+    },
+    {
+        "stationId": 72,
+        "dockable": false,
+        "message": "You cannot return all 33 of your bikes. There are 32 available docks.",
+        "reason": "INSUFFICIENT_DOCKS",
+        "stationStatus": "In Service",
+        "availableDocks": 32,
+        "requested": 33,
+        "shortfall": 1,
+        "split": [
+            {
+                "id": 72,
+                "stationName": "W 52 St \u0026 11 Ave",
+                "stAddress1": "W 52 St \u0026 11 Ave",
+                "available": 32,
+                "bikes": 32,
+                "distanceKm": 0
+            },
+            {
+                "id": 116,
+                "stationName": "W 17 St \u0026 8 Ave",
+                "stAddress1": "W 17 St \u0026 8 Ave",
+                "available": 19,
+                "bikes": 1,
+                "distanceKm": 2.91
+            }
+        ]
+    }
+]`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestBulkDockableInvalidItems(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	body := bytes.NewBufferString(`[{"stationId": 83, "bikes": 20}, {"stationId": 1, "bikes": 2}, {"stationId": 72, "bikes": 0}]`)
+	req, err := http.NewRequest("POST", "/stations/dockable?pretty=true", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusBadRequest)
+	}
+
+	expected := `[
+    {
+        "stationId": 83
+    },
+    {
+        "stationId": 1,
+        "dockable": false,
+        "message": "Station not found. Please enter a valid station id.",
+        "reason": "STATION_UNKNOWN",
+        "availableDocks": 0,
+        "requested": 2,
+        "shortfall": 0
+    },
+    {
+        "stationId": 72,
+        "dockable": false,
+        "message": "Invalid value for number of bikes to return. Please enter a valid number.",
+        "reason": "INVALID_COUNT",
+        "availableDocks": 0,
+        "requested": 0,
+        "shortfall": 0
+    }
+]`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+
+	req.Header.Set("Accept-Language", "es")
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(`[{"stationId": 1, "bikes": 2}]`))
+	w = httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	var results []DockableResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || len(results) != 1 || results[0].DockableInfo == nil || results[0].Reason != ReasonStationUnknown {
+		t.Errorf("Expected reason %s whatever the language, but received %v %s", ReasonStationUnknown, w.Code, w.Body.String())
+	}
+}
+
+func TestBulkDockableInvalidBody(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	for _, body := range []string{`{"stationId": 83}`, `[]`} {
+		req, err := http.NewRequest("POST", "/stations/dockable", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+		if status := w.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v but wanted %v", body, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestBulkDockableLimits(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	item := `{"stationId": 83, "bikes": 1}`
+	tests := map[string]struct {
+		body   string
+		status int
+	}{
+		"at the item cap":     {"[" + strings.Repeat(item+",", maxBulkDockableItems-1) + item + "]", http.StatusOK},
+		"over the item cap":   {"[" + strings.Repeat(item+",", maxBulkDockableItems) + item + "]", http.StatusBadRequest},
+		"over the byte limit": {"[" + item + strings.Repeat(" ", maxBulkDockableBytes) + "]", http.StatusRequestEntityTooLarge},
+	}
+	for name, test := range tests {
+		req, err := http.NewRequest("POST", "/stations/dockable", bytes.NewBufferString(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+		if status := w.Code; status != test.status {
+			t.Errorf("handler returned wrong status code %s: got %v but wanted %v", name, status, test.status)
+		}
+	}
+}
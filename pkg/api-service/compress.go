@@ -0,0 +1,214 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+
+	// compressMinSize - responses shorter than this are sent uncompressed
+	compressMinSize = 1024
+)
+
+// compressor - a pooled gzip or brotli writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressors - pooled writers by content coding
+var compressors = map[string]*sync.Pool{
+	encodingBrotli: {New: func() interface{} { return brotli.NewWriterLevel(nil, brotli.DefaultCompression) }},
+	encodingGzip:   {New: func() interface{} { return gzip.NewWriter(nil) }},
+}
+
+// Compressor - negroni middleware compressing responses with brotli or gzip
+type Compressor struct {
+	minSize int
+}
+
+// compressResponseWriter - holds back the start of a response until it is
+// long enough to be worth compressing, then compresses the rest as it streams
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buffer   []byte
+	decided  bool
+	encoder  compressor
+}
+
+/*
+ * 	Creates compression middleware that leaves responses shorter than
+ * 	minSize bytes uncompressed
+ */
+func NewCompressor(minSize int) *Compressor {
+	return &Compressor{minSize: minSize}
+}
+
+/*
+ * 	Picks the content coding for an Accept-Encoding header, preferring brotli
+ * 	to gzip at equal quality. Returns "" when neither is acceptable.
+ */
+func negotiateEncoding(acceptEncoding string) string {
+	quality := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality[coding] = q
+	}
+	for _, coding := range []string{encodingBrotli, encodingGzip} {
+		if _, ok := quality[coding]; !ok {
+			if q, ok := quality["*"]; ok {
+				quality[coding] = q
+			}
+		}
+	}
+	if quality[encodingBrotli] > 0 && quality[encodingBrotli] >= quality[encodingGzip] {
+		return encodingBrotli
+	}
+	if quality[encodingGzip] > 0 {
+		return encodingGzip
+	}
+	return ""
+}
+
+/*
+ * 	Compresses the response with the coding the client prefers. WebSocket
+ * 	upgrades are passed through untouched so they can still be hijacked.
+ */
+func (c *Compressor) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" || req.Header.Get("Upgrade") != "" {
+		next(w, req)
+		return
+	}
+	cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, minSize: c.minSize}
+	defer cw.Close()
+	next(cw, req)
+}
+
+/*
+ * 	Records the status until the writer knows whether it compresses. Statuses
+ * 	without a body are sent straight away.
+ */
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+/*
+ * 	Buffers the start of the body until it reaches minSize, then writes
+ * 	through the compressor
+ */
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buffer = append(cw.buffer, p...)
+		if len(cw.buffer) >= cw.minSize {
+			if err := cw.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+/*
+ * 	Sends the headers and buffered body, compressing from here on if compress
+ * 	is set and the response has not been encoded or opened as an event stream
+ */
+func (cw *compressResponseWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" || strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		compress = false
+	}
+	if compress {
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", http.DetectContentType(cw.buffer))
+		}
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		cw.encoder = compressors[cw.encoding].Get().(compressor)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buffered := cw.buffer
+	cw.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buffered)
+	return err
+}
+
+/*
+ * 	Sends what has been written so far. Flushing means the handler streams,
+ * 	so the response is compressed even if it is still short.
+ */
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+/*
+ * 	Finishes the response: short bodies are sent as they are, compressed ones
+ * 	get their trailer and the compressor goes back to its pool
+ */
+func (cw *compressResponseWriter) Close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buffer) == 0 {
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		cw.encoder.Reset(nil)
+		compressors[cw.encoding].Put(cw.encoder)
+		cw.encoder = nil
+	}
+}
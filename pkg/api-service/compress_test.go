@@ -0,0 +1,87 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/urfave/negroni"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"gzip":                      encodingGzip,
+		"gzip, deflate, br":         encodingBrotli,
+		"br;q=0.5, gzip":            encodingGzip,
+		"br;q=0, gzip;q=0":          "",
+		"*":                         encodingBrotli,
+		"identity, *;q=0.1, br;q=0": encodingGzip,
+	}
+	for header, expected := range tests {
+		if encoding := negotiateEncoding(header); encoding != expected {
+			t.Errorf("Expected %q for Accept-Encoding %q, but received %q", expected, header, encoding)
+		}
+	}
+}
+
+func TestCompressor(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	router := Router()
+	n := negroni.New(NewCompressor(64))
+	n.UseHandler(router)
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, req)
+		return w
+	}
+	uncompressed := serve("/stations", "").Body.String()
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		encodingGzip:   func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		encodingBrotli: func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for encoding, decoder := range decoders {
+		w := serve("/stations", encoding)
+		if w.Header().Get("Content-Encoding") != encoding || w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a %s response, but received %v", encoding, w.Header())
+			continue
+		}
+		reader, err := decoder(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		if err != nil || string(body) != uncompressed {
+			t.Errorf("Expected the %s body to decode to the uncompressed body, but received %q (%v)", encoding, body, err)
+		}
+	}
+
+	if w := serve("/stations/9999/history", "gzip"); w.Header().Get("Content-Encoding") != "" || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a short response to be sent uncompressed, but received %v %v", w.Code, w.Header())
+	}
+}
+
+func TestCompactJSON(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, _ := http.NewRequest("GET", "/stations/atlantic", nil)
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+
+	expected := `[{"stationName":"Atlantic Ave \u0026 Fort Greene Pl","totalDocks":62,"availableBikes":40,"stAddress1":"Atlantic Ave \u0026 Fort Greene Pl"}]` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("Expected compact JSON by default, but received %s", w.Body.String())
+	}
+	var stations []Station
+	if err := json.Unmarshal(w.Body.Bytes(), &stations); err != nil || strings.Contains(w.Body.String(), "    ") {
+		t.Errorf("Expected valid unindented JSON, but received %s (%v)", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig - which cross-origin requests browsers are allowed to make
+type CORSConfig struct {
+	// AllowedOrigins - exact origins, "*" for any, or wildcard subdomains such as https://*.example.com
+	AllowedOrigins []string
+	// AllowedMethods - methods offered in preflight responses, where the route also accepts them
+	AllowedMethods []string
+	// AllowedHeaders - request headers browsers may send; "*" allows any
+	AllowedHeaders []string
+	// ExposedHeaders - response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials - whether cookies and Authorization headers may be sent
+	AllowCredentials bool
+	// MaxAge - how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// CORS - negroni middleware answering preflight requests and adding CORS headers
+type CORS struct {
+	config CORSConfig
+	router *mux.Router
+}
+
+// corsExposedHeaders - response headers clients of this API need to read
+var corsExposedHeaders = []string{"Content-Language", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "X-Quota-Limit", "X-Quota-Remaining"}
+
+/*
+ * 	Splits a comma-separated flag value, dropping blank entries
+ */
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+/*
+ * 	Creates CORS middleware for the routes of router. Preflight requests are
+ * 	answered with the methods the matching route accepts, so routes that only
+ * 	allow GET still get a preflight response instead of 405.
+ */
+func NewCORS(config CORSConfig, router *mux.Router) *CORS {
+	if len(config.ExposedHeaders) == 0 {
+		config.ExposedHeaders = corsExposedHeaders
+	}
+	return &CORS{config: config, router: router}
+}
+
+/*
+ * 	Returns whether origin matches pattern, where a single * in the pattern
+ * 	stands for one or more subdomain labels
+ */
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return strings.EqualFold(pattern, origin)
+	}
+	prefix, suffix := strings.ToLower(pattern[:star]), strings.ToLower(pattern[star+1:])
+	origin = strings.ToLower(origin)
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(subdomain, "/:@") && !strings.HasPrefix(subdomain, ".") && !strings.HasSuffix(subdomain, ".")
+}
+
+/*
+ * 	Returns whether requests from origin are allowed
+ */
+func (c *CORS) allowedOrigin(origin string) bool {
+	for _, pattern := range c.config.AllowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * 	Returns the configured methods that a route matching req's path accepts
+ */
+func (c *CORS) routeMethods(req *http.Request) []string {
+	var methods []string
+	for _, method := range c.config.AllowedMethods {
+		candidate := req.Clone(req.Context())
+		candidate.Method = method
+		match := mux.RouteMatch{}
+		if c.router == nil || c.router.Match(candidate, &match) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+/*
+ * 	Returns whether every header in the comma-separated requested list is allowed
+ */
+func (c *CORS) allowedHeaders(requested string) bool {
+	for _, header := range splitList(requested) {
+		allowed := false
+		for _, candidate := range c.config.AllowedHeaders {
+			if candidate == "*" || strings.EqualFold(candidate, header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * 	Sets the headers shared by preflight and actual responses. A literal *
+ * 	is only sent when any origin is allowed and credentials are not.
+ */
+func (c *CORS) setOriginHeaders(w http.ResponseWriter, origin string) {
+	w.Header().Add("Vary", "Origin")
+	if c.config.AllowCredentials || len(c.config.AllowedOrigins) != 1 || c.config.AllowedOrigins[0] != "*" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	if c.config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+/*
+ * 	Answers preflight requests itself and adds CORS headers to the responses
+ * 	of allowed cross-origin requests. Requests without an Origin header, and
+ * 	OPTIONS requests that are not preflights, pass straight through.
+ */
+func (c *CORS) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	origin := req.Header.Get("Origin")
+	requestedMethod := req.Header.Get("Access-Control-Request-Method")
+	if origin == "" {
+		next(w, req)
+		return
+	}
+	if req.Method != http.MethodOptions || requestedMethod == "" {
+		if c.allowedOrigin(origin) {
+			c.setOriginHeaders(w, origin)
+			if len(c.config.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.config.ExposedHeaders, ", "))
+			}
+		}
+		next(w, req)
+		return
+	}
+
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	l := newLocalizer(req)
+	if !c.allowedOrigin(origin) {
+		l.SetHeader(w)
+		http.Error(w, l.Message("cors.originForbidden", MessageArgs{"origin": origin}), http.StatusForbidden)
+		return
+	}
+	methods := c.routeMethods(req)
+	if len(methods) == 0 {
+		l.SetHeader(w)
+		http.Error(w, l.Message("cors.notFound", nil), http.StatusNotFound)
+		return
+	}
+	methodAllowed := false
+	for _, method := range methods {
+		methodAllowed = methodAllowed || method == requestedMethod
+	}
+	requestedHeaders := req.Header.Get("Access-Control-Request-Headers")
+	if !methodAllowed || !c.allowedHeaders(requestedHeaders) {
+		l.SetHeader(w)
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+		http.Error(w, l.Message("cors.preflightRejected", MessageArgs{"method": requestedMethod}), http.StatusForbidden)
+		return
+	}
+
+	c.setOriginHeaders(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if requestedHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(splitList(requestedHeaders), ", "))
+	}
+	if c.config.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.config.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/urfave/negroni"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		expected        bool
+	}{
+		{"https://map.example.com", "https://map.example.com", true},
+		{"https://map.example.com", "https://MAP.example.com", true},
+		{"https://map.example.com", "http://map.example.com", false},
+		{"https://*.example.com", "https://map.example.com", true},
+		{"https://*.example.com", "https://staging.map.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com", "https://example.com:8080.example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"*", "https://anywhere.example", true},
+	}
+	for _, test := range tests {
+		if matched := matchOrigin(test.pattern, test.origin); matched != test.expected {
+			t.Errorf("Expected %s matching %s to be %v", test.origin, test.pattern, test.expected)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	router := Router()
+	n := negroni.New(NewCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"GET", "POST", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}, router))
+	n.UseHandler(router)
+	serve := func(method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("OPTIONS", "/stations/72/5", "https://map.example.com", map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "authorization"})
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://map.example.com" || w.Header().Get("Access-Control-Allow-Methods") != "GET" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "authorization" || w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Expected a preflight response for a GET-only route, but received %v %v", w.Code, w.Header())
+	}
+	w = serve("OPTIONS", "/subscriptions/abc", "https://map.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"})
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, DELETE" {
+		t.Errorf("Expected the route's GET and DELETE methods, but received %v %v", w.Code, w.Header())
+	}
+	if w := serve("OPTIONS", "/stations", "https://map.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"}); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected a method the route does not accept to be refused, but received %v %v", w.Code, w.Header())
+	}
+	if w := serve("OPTIONS", "/stations", "https://map.example.com", map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Debug"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a header that is not allowed to be refused, but received %v", w.Code)
+	}
+	if w := serve("OPTIONS", "/stations", "https://evil.example", map[string]string{"Access-Control-Request-Method": "GET"}); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected another origin's preflight to be refused, but received %v %v", w.Code, w.Header())
+	}
+
+	w = serve("GET", "/stations", "https://map.example.com", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://map.example.com" || w.Header().Get("Vary") != "Origin" || w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Errorf("Expected CORS headers on an allowed request, but received %v %v", w.Code, w.Header())
+	}
+	if w := serve("GET", "/stations", "https://evil.example", nil); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for another origin, but received %v %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	router := Router()
+	n := negroni.New(NewCORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, AllowedHeaders: []string{"*"}}, router))
+	n.UseHandler(router)
+	req, _ := http.NewRequest("OPTIONS", "/stations/in-service", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+	w := httptest.NewRecorder()
+	n.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Headers") != "X-Custom" {
+		t.Errorf("Expected a wildcard preflight response, but received %v %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sync"
+)
+
+// StationEventType - the kind of change a StationEvent describes
+type StationEventType string
+
+// Event types emitted by DiffSnapshots
+const (
+	StationAdded    StationEventType = "StationAdded"
+	StationRemoved  StationEventType = "StationRemoved"
+	StatusChanged   StationEventType = "StatusChanged"
+	BikesChanged    StationEventType = "BikesChanged"
+	DocksChanged    StationEventType = "DocksChanged"
+	StationEmptied  StationEventType = "StationEmptied"
+	StationFull     StationEventType = "StationFull"
+	CapacityChanged StationEventType = "CapacityChanged"
+)
+
+// StationEvent - one change to a station between two snapshots. Previous is nil
+// for StationAdded and Current is nil for StationRemoved.
+type StationEvent struct {
+	Type          StationEventType `json:"type"`
+	StationID     int              `json:"stationId"`
+	ExecutionTime string           `json:"executionTime"`
+	Previous      *Station         `json:"previous,omitempty"`
+	Current       *Station         `json:"current,omitempty"`
+}
+
+// DiffSubscriber - receives events from a DiffEngine; Events is closed if the subscriber falls too far behind
+type DiffSubscriber struct {
+	events chan StationEvent
+	types  map[StationEventType]bool
+}
+
+// DiffListener - called with each published snapshot and its events since the previous one
+type DiffListener func(data StationData, events []StationEvent)
+
+// DiffEngine - compares each published snapshot with the previous one and sends the differences to listeners and subscribers
+type DiffEngine struct {
+	mu          sync.Mutex
+	previous    *StationData
+	listeners   []DiffListener
+	subscribers map[*DiffSubscriber]bool
+}
+
+var (
+	// diffs - the events between consecutive refreshed snapshots; the change
+	// stream, the WebSocket hub and webhook alerts all listen to it
+	diffs = newDiffEngine()
+)
+
+/*
+ * 	Returns the events that turn previous into current: additions and changes
+ * 	in current's order, then removals in previous's order. A station's events
+ * 	are ordered status, bikes, docks, capacity, then emptied and full.
+ */
+func DiffSnapshots(previous, current StationData) []StationEvent {
+	var events []StationEvent
+	before := map[int]Station{}
+	for _, station := range previous.StationBeanList {
+		before[station.ID] = station
+	}
+	after := map[int]bool{}
+
+	for i := range current.StationBeanList {
+		now := current.StationBeanList[i]
+		after[now.ID] = true
+		event := func(eventType StationEventType, was *Station) StationEvent {
+			return StationEvent{Type: eventType, StationID: now.ID, ExecutionTime: current.ExecutionTime, Previous: was, Current: &now}
+		}
+		was, ok := before[now.ID]
+		if !ok {
+			events = append(events, event(StationAdded, nil))
+			continue
+		}
+		if was.StatusValue != now.StatusValue {
+			events = append(events, event(StatusChanged, &was))
+		}
+		if was.AvailableBikes != now.AvailableBikes {
+			events = append(events, event(BikesChanged, &was))
+		}
+		if was.AvailableDocks != now.AvailableDocks {
+			events = append(events, event(DocksChanged, &was))
+		}
+		if was.TotalDocks != now.TotalDocks {
+			events = append(events, event(CapacityChanged, &was))
+		}
+		if was.AvailableBikes > 0 && now.AvailableBikes == 0 {
+			events = append(events, event(StationEmptied, &was))
+		}
+		if was.AvailableDocks > 0 && now.AvailableDocks == 0 {
+			events = append(events, event(StationFull, &was))
+		}
+	}
+
+	for i := range previous.StationBeanList {
+		if was := previous.StationBeanList[i]; !after[was.ID] {
+			events = append(events, StationEvent{Type: StationRemoved, StationID: was.ID, ExecutionTime: current.ExecutionTime, Previous: &was})
+		}
+	}
+	return events
+}
+
+/*
+ * 	Creates an engine with no previous snapshot and no subscribers
+ */
+func newDiffEngine() *DiffEngine {
+	return &DiffEngine{subscribers: map[*DiffSubscriber]bool{}}
+}
+
+/*
+ * 	Registers a listener called synchronously on every Publish, in the order
+ * 	listeners were registered. Listeners must not block.
+ */
+func (e *DiffEngine) OnDiff(listener DiffListener) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, listener)
+}
+
+/*
+ * 	Returns a subscriber receiving events of the given types, or of every type
+ * 	if none are given, buffering up to buffer events
+ */
+func (e *DiffEngine) Subscribe(buffer int, types ...StationEventType) *DiffSubscriber {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	subscriber := &DiffSubscriber{events: make(chan StationEvent, buffer)}
+	if len(types) > 0 {
+		subscriber.types = map[StationEventType]bool{}
+		for _, eventType := range types {
+			subscriber.types[eventType] = true
+		}
+	}
+	e.subscribers[subscriber] = true
+	return subscriber
+}
+
+/*
+ * 	Removes a subscriber, closing its channel unless Publish already has
+ */
+func (e *DiffEngine) Unsubscribe(subscriber *DiffSubscriber) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.subscribers[subscriber] {
+		delete(e.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+/*
+ * 	Returns the channel events are delivered on
+ */
+func (subscriber *DiffSubscriber) Events() <-chan StationEvent {
+	return subscriber.events
+}
+
+/*
+ * 	Diffs data against the previously published snapshot and sends the events
+ * 	to listeners and subscribers. The first snapshot only sets the baseline:
+ * 	listeners receive it with no events. Subscribers that cannot keep up are
+ * 	closed rather than slowing down the refresh.
+ */
+func (e *DiffEngine) Publish(data StationData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous := e.previous
+	e.previous = &data
+	var events []StationEvent
+	if previous != nil {
+		events = DiffSnapshots(*previous, data)
+	}
+	for _, listener := range e.listeners {
+		listener(data, events)
+	}
+	if previous == nil {
+		return
+	}
+
+	for subscriber := range e.subscribers {
+		for _, event := range events {
+			if subscriber.types != nil && !subscriber.types[event.Type] {
+				continue
+			}
+			select {
+			case subscriber.events <- event:
+				continue
+			default:
+				delete(e.subscribers, subscriber)
+				close(subscriber.events)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+/*
+ * 	Summarises events as "Type:id" strings for comparison
+ */
+func eventSummary(events []StationEvent) string {
+	var summary []string
+	for _, event := range events {
+		summary = append(summary, fmt.Sprintf("%s:%d", event.Type, event.StationID))
+	}
+	return strings.Join(summary, " ")
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := map[string]struct {
+		replacements []string
+		expected     string
+	}{
+		"unchanged": {nil, ""},
+		"bikes and docks": {
+			[]string{`"availableDocks":32,"totalDocks":39`, `"availableDocks":30,"totalDocks":39`, `"availableBikes":7`, `"availableBikes":9`},
+			"BikesChanged:72 DocksChanged:72",
+		},
+		"status": {
+			[]string{`"statusValue":"Not In Service"`, `"statusValue":"In Service"`},
+			"StatusChanged:423",
+		},
+		"emptied": {
+			[]string{`"availableDocks":19,"totalDocks":39`, `"availableDocks":39,"totalDocks":39`, `"availableBikes":19`, `"availableBikes":0`},
+			"BikesChanged:116 DocksChanged:116 StationEmptied:116",
+		},
+		"full": {
+			[]string{`"availableDocks":27,"totalDocks":27`, `"availableDocks":0,"totalDocks":27`, `"availableBikes":0,"stAddress1":"St James`, `"availableBikes":27,"stAddress1":"St James`},
+			"BikesChanged:82 DocksChanged:82 StationFull:82",
+		},
+		"capacity": {
+			[]string{`"availableDocks":21,"totalDocks":62`, `"availableDocks":21,"totalDocks":64`},
+			"CapacityChanged:83",
+		},
+		"added and removed": {
+			[]string{`"id":116`, `"id":3000`},
+			"StationAdded:3000 StationRemoved:116",
+		},
+	}
+	previous := fixtureStationData(t)
+	for name, test := range tests {
+		events := DiffSnapshots(previous, fixtureStationData(t, test.replacements...))
+		if summary := eventSummary(events); summary != test.expected {
+			t.Errorf("%s: expected %q, but received %q", name, test.expected, summary)
+		}
+	}
+}
+
+func TestDiffSnapshotsValues(t *testing.T) {
+	current := fixtureStationData(t, "04:32:49 PM", "04:40:00 PM", `"availableBikes":7`, `"availableBikes":9`, `"id":116`, `"id":3000`)
+	events := DiffSnapshots(fixtureStationData(t), current)
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, but received %q", eventSummary(events))
+	}
+	bikes, added, removed := events[0], events[1], events[2]
+	if bikes.Previous.AvailableBikes != 7 || bikes.Current.AvailableBikes != 9 || bikes.ExecutionTime != "2016-01-22 04:40:00 PM" {
+		t.Errorf("Expected bikes to go from 7 to 9 at 04:40:00 PM, but received %+v", bikes)
+	}
+	if added.Previous != nil || added.Current.StationName != "W 17 St & 8 Ave" {
+		t.Errorf("Expected an added station with no previous values, but received %+v", added)
+	}
+	if removed.Current != nil || removed.Previous.ID != 116 {
+		t.Errorf("Expected a removed station with no current values, but received %+v", removed)
+	}
+}
+
+func TestDiffEngine(t *testing.T) {
+	engine := newDiffEngine()
+	all := engine.Subscribe(10)
+	status := engine.Subscribe(10, StatusChanged)
+	engine.Publish(fixtureStationData(t))
+	engine.Publish(fixtureStationData(t, `"statusValue":"Not In Service"`, `"statusValue":"In Service"`, `"availableBikes":40`, `"availableBikes":39`))
+	engine.Unsubscribe(all)
+	engine.Unsubscribe(status)
+
+	var received []StationEvent
+	for event := range all.Events() {
+		received = append(received, event)
+	}
+	if summary := eventSummary(received); summary != "StatusChanged:423 BikesChanged:83" {
+		t.Errorf("Expected status and bike changes, but received %q", summary)
+	}
+	received = nil
+	for event := range status.Events() {
+		received = append(received, event)
+	}
+	if summary := eventSummary(received); summary != "StatusChanged:423" {
+		t.Errorf("Expected only the status change, but received %q", summary)
+	}
+}
+
+func TestDiffEngineDropsSlowSubscribers(t *testing.T) {
+	engine := newDiffEngine()
+	slow := engine.Subscribe(1)
+	engine.Publish(fixtureStationData(t))
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`, `"availableBikes":7`, `"availableBikes":6`))
+
+	count := 0
+	for range slow.Events() {
+		count++
+	}
+	if count != 1 || len(engine.subscribers) != 0 {
+		t.Errorf("Expected the slow subscriber to receive 1 event and be removed, but received %d", count)
+	}
+}
+
+func TestDiffEngineListeners(t *testing.T) {
+	engine := newDiffEngine()
+	var calls []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		engine.OnDiff(func(data StationData, events []StationEvent) {
+			calls = append(calls, fmt.Sprintf("%s:%d:%s", name, len(data.StationBeanList), eventSummary(events)))
+		})
+	}
+	engine.Publish(fixtureStationData(t))
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`))
+
+	expected := "first:6: second:6: first:6:BikesChanged:83 second:6:BikesChanged:83"
+	if summary := strings.Join(calls, " "); summary != expected {
+		t.Errorf("Expected listeners to be called in order with the baseline then the changes, but received %q", summary)
+	}
+}
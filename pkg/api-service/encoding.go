@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ * 	Returns whether req asked for indented JSON with ?pretty=true
+ */
+func wantsPretty(req *http.Request) bool {
+	pretty, err := strconv.ParseBool(req.URL.Query().Get("pretty"))
+	return err == nil && pretty
+}
+
+/*
+ * 	Streams v to w as JSON with status. Responses are compact unless the
+ * 	request asked for ?pretty=true, which indents them by four spaces.
+ */
+func writeJSON(w http.ResponseWriter, req *http.Request, status int, v interface{}, contextLogger *log.Entry) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	if wantsPretty(req) {
+		encoder.SetIndent("", "    ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		contextLogger.Error("Error writing JSON response", err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	// exportTimeLayout - how snapshot times are written in export filenames
+	exportTimeLayout = "20060102T150405"
+)
+
+// formatMediaTypes - the media types each export format is sent as and accepted by
+var formatMediaTypes = map[string][]string{
+	formatJSON:   {"application/json"},
+	formatCSV:    {"text/csv"},
+	formatNDJSON: {"application/x-ndjson", "application/ndjson"},
+}
+
+// ExportRows - a result that can be streamed one row at a time as CSV or NDJSON
+type ExportRows interface {
+	// Header - the CSV column names
+	Header() []string
+	// Len - the number of rows
+	Len() int
+	// Record - row i as CSV values, in Header order
+	Record(i int) []string
+	// Value - row i as it is encoded in NDJSON
+	Value(i int) interface{}
+}
+
+// stationRows - listing and search results, with one column per selected field
+type stationRows struct {
+	stations []SparseStation
+	fields   FieldSet
+}
+
+// bucketRows - the buckets of a station's history
+type bucketRows []HistoryBucket
+
+// stateRows - every station's state in a recorded snapshot
+type stateRows []StationState
+
+/*
+ * 	Picks the response format from ?format=, or else from the Accept header,
+ * 	answering 400 and returning false for a ?format= that is not supported
+ */
+func requestFormat(w http.ResponseWriter, req *http.Request, contextLogger *log.Entry) (string, bool) {
+	if format := strings.ToLower(req.URL.Query().Get("format")); format != "" {
+		if _, ok := formatMediaTypes[format]; !ok {
+			l := newLocalizer(req)
+			l.SetHeader(w)
+			http.Error(w, l.Message("format.unsupported", MessageArgs{"format": format}), http.StatusBadRequest)
+			contextLogger.Warn("Unsupported response format")
+			return "", false
+		}
+		return format, true
+	}
+	return negotiateFormat(req.Header.Get("Accept")), true
+}
+
+/*
+ * 	Returns the format whose media type the Accept header rates highest,
+ * 	defaulting to JSON
+ */
+func negotiateFormat(accept string) string {
+	best, bestQuality := formatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		for format, mediaTypes := range formatMediaTypes {
+			for _, candidate := range mediaTypes {
+				if candidate == mediaType && quality > bestQuality {
+					best, bestQuality = format, quality
+				}
+			}
+		}
+	}
+	return best
+}
+
+/*
+ * 	Returns the filename an export of name taken at snapshot is downloaded as
+ */
+func exportFilename(name string, snapshot time.Time, format string) string {
+	if snapshot.IsZero() {
+		return name + "." + format
+	}
+	return name + "-" + snapshot.In(feedLocation).Format(exportTimeLayout) + "." + format
+}
+
+/*
+ * 	Streams rows as CSV with a header row, or as one JSON object per line,
+ * 	with a Content-Disposition naming the file after name and the snapshot
+ */
+func writeExport(w http.ResponseWriter, format string, name string, snapshot time.Time, rows ExportRows, contextLogger *log.Entry) {
+	w.Header().Set("Content-Type", formatMediaTypes[format][0]+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": exportFilename(name, snapshot, format)}))
+	w.WriteHeader(http.StatusOK)
+
+	var err error
+	switch format {
+	case formatCSV:
+		writer := csv.NewWriter(w)
+		err = writer.Write(rows.Header())
+		for i := 0; i < rows.Len() && err == nil; i++ {
+			if err = writer.Write(rows.Record(i)); err == nil {
+				writer.Flush()
+				err = writer.Error()
+			}
+		}
+		writer.Flush()
+	case formatNDJSON:
+		encoder := json.NewEncoder(w)
+		for i := 0; i < rows.Len() && err == nil; i++ {
+			err = encoder.Encode(rows.Value(i))
+		}
+	}
+	if err != nil {
+		contextLogger.Error("Error writing export", err)
+	}
+}
+
+/*
+ * 	Writes a listing as JSON, or streams it as an export named after name and
+ * 	the feed's executionTime
+ */
+func writeStationList(w http.ResponseWriter, req *http.Request, format string, name string, executionTime string, stationInfo []SparseStation, fields FieldSet, contextLogger *log.Entry) {
+	if format == formatJSON {
+		writeJSON(w, req, http.StatusOK, stationInfo, contextLogger)
+		return
+	}
+	snapshot, _ := parseExecutionTime(executionTime)
+	writeExport(w, format, name, snapshot, stationRows{stations: stationInfo, fields: fields}, contextLogger)
+}
+
+/*
+ * 	Writes v as a CSV value
+ */
+func csvValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+/* Lists the selected fields of each station */
+func (rows stationRows) Header() []string { return rows.fields }
+
+func (rows stationRows) Len() int { return len(rows.stations) }
+
+func (rows stationRows) Record(i int) []string {
+	record := make([]string, len(rows.fields))
+	for j, name := range rows.fields {
+		record[j] = csvValue(stationFieldsByName[name].value(rows.stations[i].Station))
+	}
+	return record
+}
+
+func (rows stationRows) Value(i int) interface{} { return rows.stations[i] }
+
+/* Lists each history bucket with its stats flattened into columns */
+func (rows bucketRows) Header() []string {
+	return []string{"start", "samples",
+		"availableBikesMin", "availableBikesMax", "availableBikesAvg",
+		"availableDocksMin", "availableDocksMax", "availableDocksAvg", "statusValue"}
+}
+
+func (rows bucketRows) Len() int { return len(rows) }
+
+func (rows bucketRows) Record(i int) []string {
+	bucket := rows[i]
+	return []string{bucket.Start, csvValue(bucket.Samples),
+		csvValue(bucket.AvailableBikes.Min), csvValue(bucket.AvailableBikes.Max), csvValue(bucket.AvailableBikes.Avg),
+		csvValue(bucket.AvailableDocks.Min), csvValue(bucket.AvailableDocks.Max), csvValue(bucket.AvailableDocks.Avg), bucket.StatusValue}
+}
+
+func (rows bucketRows) Value(i int) interface{} { return rows[i] }
+
+/* Lists each station's recorded state */
+func (rows stateRows) Header() []string {
+	return []string{"id", "availableBikes", "availableDocks", "totalDocks", "statusValue"}
+}
+
+func (rows stateRows) Len() int { return len(rows) }
+
+func (rows stateRows) Record(i int) []string {
+	state := rows[i]
+	return []string{csvValue(state.ID), csvValue(state.AvailableBikes), csvValue(state.AvailableDocks), csvValue(state.TotalDocks), state.StatusValue}
+}
+
+func (rows stateRows) Value(i int) interface{} { return rows[i] }
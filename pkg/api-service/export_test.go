@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := map[string]string{
+		"":                                    formatJSON,
+		"*/*":                                 formatJSON,
+		"text/csv":                            formatCSV,
+		"application/x-ndjson":                formatNDJSON,
+		"application/json, text/csv;q=0.5":    formatJSON,
+		"application/json;q=0.5, text/csv":    formatCSV,
+		"text/html, application/ndjson;q=0.9": formatNDJSON,
+	}
+	for accept, expected := range tests {
+		if format := negotiateFormat(accept); format != expected {
+			t.Errorf("Expected %s for Accept %q, but received %s", expected, accept, format)
+		}
+	}
+}
+
+/*
+ * 	Serves a GET request for path with the given Accept header
+ */
+func serveExport(t *testing.T, path, accept string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	return w
+}
+
+func TestExportStationsCSV(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	w := serveExport(t, "/stations/not-in-service?format=csv&fields=id,stationName,availableDocks,latitude&include_stale=true", "")
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || w.Header().Get("Content-Disposition") != "attachment; filename=stations-not-in-service-20160122T163249.csv" {
+		t.Errorf("Expected a CSV attachment named after the snapshot, but received %v", w.Header())
+	}
+
+	expected := "id,stationName,availableDocks,latitude\n" +
+		"423,W 54 St & 9 Ave,3,40.76584941\n"
+	if w.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestExportSearchNDJSON(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	w := serveExport(t, "/stations/st?fields=id,availableBikes&include_stale=true", "application/x-ndjson")
+	if w.Header().Get("Content-Type") != "application/x-ndjson; charset=utf-8" || w.Header().Get("Content-Disposition") != "attachment; filename=stations-search-20160122T163249.ndjson" {
+		t.Errorf("Expected an NDJSON attachment, but received %v", w.Header())
+	}
+
+	expected := `{"id":72,"availableBikes":7}` + "\n" +
+		`{"id":423,"availableBikes":0}` + "\n" +
+		`{"id":79,"availableBikes":33}` + "\n" +
+		`{"id":82,"availableBikes":0}` + "\n" +
+		`{"id":116,"availableBikes":19}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+
+	if w := serveExport(t, "/stations/nowhere?format=csv", ""); w.Code != http.StatusOK || w.Body.String() != "stationName,totalDocks,availableBikes,stAddress1\n" {
+		t.Errorf("Expected a search without results to export only the header, but received %v %q", w.Code, w.Body.String())
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	recordTestHistory(t)
+	w := serveExport(t, "/stations/72/history?from=2016-01-22T16:00:00&to=2016-01-22T18:00:00&step=1h", "text/csv")
+	if w.Header().Get("Content-Disposition") != "attachment; filename=station-72-history-20160122T180000.csv" {
+		t.Errorf("Expected the export to be named after the end of the range, but received %v", w.Header())
+	}
+	expected := "start,samples,availableBikesMin,availableBikesMax,availableBikesAvg,availableDocksMin,availableDocksMax,availableDocksAvg,statusValue\n" +
+		"2016-01-22T16:00:00-05:00,2,7,9,8,30,32,31,In Service\n" +
+		"2016-01-22T17:00:00-05:00,1,4,4,4,35,35,35,In Service\n"
+	if w.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+
+	w = serveExport(t, "/stations/history/at?t=2016-01-22T17:00:00-05:00&format=ndjson", "")
+	if w.Header().Get("Content-Disposition") != "attachment; filename=stations-history-20160122T164000.ndjson" {
+		t.Errorf("Expected the export to be named after the snapshot, but received %v", w.Header())
+	}
+	if !strings.HasPrefix(w.Body.String(), `{"id":72,"availableBikes":9,"availableDocks":30,"totalDocks":39,"statusValue":"In Service"}`+"\n") {
+		t.Errorf("Expected station 72 with 9 bikes first, but received %s", w.Body.String())
+	}
+}
+
+func TestExportUnsupportedFormat(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	if w := serveExport(t, "/stations?format=xml", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported format, but received %v", w.Code)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StationResponse - a station as the listing and search endpoints return it.
+// It is copied from Station instead of hiding fields by zeroing them, so a
+// station with no available docks still reports 0.
+type StationResponse struct {
+	ID                    int     `json:"id"`
+	StationName           string  `json:"stationName"`
+	StAddress1            string  `json:"stAddress1"`
+	StatusValue           string  `json:"statusValue"`
+	AvailableBikes        int     `json:"availableBikes"`
+	AvailableDocks        int     `json:"availableDocks"`
+	TotalDocks            int     `json:"totalDocks"`
+	Latitude              float64 `json:"latitude"`
+	Longitude             float64 `json:"longitude"`
+	LastCommunicationTime string  `json:"lastCommunicationTime"`
+	Stale                 bool    `json:"stale"`
+}
+
+// FieldSet - the names of the StationResponse fields to encode, in order
+type FieldSet []string
+
+// SparseStation - a StationResponse that encodes only the fields in Fields
+type SparseStation struct {
+	Station StationResponse
+	Fields  FieldSet
+}
+
+// stationField - a field of StationResponse that ?fields= can select
+type stationField struct {
+	name  string
+	value func(StationResponse) interface{}
+}
+
+// stationFields - every selectable field, in the order of StationResponse
+var stationFields = []stationField{
+	{"id", func(s StationResponse) interface{} { return s.ID }},
+	{"stationName", func(s StationResponse) interface{} { return s.StationName }},
+	{"stAddress1", func(s StationResponse) interface{} { return s.StAddress1 }},
+	{"statusValue", func(s StationResponse) interface{} { return s.StatusValue }},
+	{"availableBikes", func(s StationResponse) interface{} { return s.AvailableBikes }},
+	{"availableDocks", func(s StationResponse) interface{} { return s.AvailableDocks }},
+	{"totalDocks", func(s StationResponse) interface{} { return s.TotalDocks }},
+	{"latitude", func(s StationResponse) interface{} { return s.Latitude }},
+	{"longitude", func(s StationResponse) interface{} { return s.Longitude }},
+	{"lastCommunicationTime", func(s StationResponse) interface{} { return s.LastCommunicationTime }},
+	{"stale", func(s StationResponse) interface{} { return s.Stale }},
+}
+
+// stationFieldsByName - stationFields indexed by name
+var stationFieldsByName = map[string]stationField{}
+
+// defaultStationFields - the fields each endpoint returns when the request has
+// no ?fields=. All of them show a station's name, address, bikes and docks:
+//
+//	/stations                  stationName, totalDocks, availableBikes, stAddress1
+//	/stations/in-service       stationName, totalDocks, availableBikes, stAddress1
+//	/stations/not-in-service   stationName, totalDocks, availableBikes, stAddress1
+//	/stations/{searchstring}   stationName, totalDocks, availableBikes, stAddress1
+//
+// With ?include_stale=true they show stale as well, so stale stations stand out.
+var defaultStationFields = map[string]FieldSet{
+	"/stations":                {"stationName", "totalDocks", "availableBikes", "stAddress1"},
+	"/stations/in-service":     {"stationName", "totalDocks", "availableBikes", "stAddress1"},
+	"/stations/not-in-service": {"stationName", "totalDocks", "availableBikes", "stAddress1"},
+	"/stations/{searchstring}": {"stationName", "totalDocks", "availableBikes", "stAddress1"},
+}
+
+func init() {
+	for _, field := range stationFields {
+		stationFieldsByName[field.name] = field
+	}
+}
+
+/*
+ * 	Copies the fields of station that responses can show
+ */
+func newStationResponse(station Station) StationResponse {
+	return StationResponse{
+		ID:                    station.ID,
+		StationName:           station.StationName,
+		StAddress1:            station.StAddress1,
+		StatusValue:           string(station.StatusValue),
+		AvailableBikes:        station.AvailableBikes,
+		AvailableDocks:        station.AvailableDocks,
+		TotalDocks:            station.TotalDocks,
+		Latitude:              station.Latitude,
+		Longitude:             station.Longitude,
+		LastCommunicationTime: formatFeedTime(station.LastCommunicationTime),
+		Stale:                 station.Stale,
+	}
+}
+
+/*
+ * 	Parses a comma-separated ?fields= value, returning defaults when it is
+ * 	empty and the names that are not fields of StationResponse, if any
+ */
+func parseFieldSet(value string, defaults FieldSet) (FieldSet, []string) {
+	names := splitList(value)
+	if len(names) == 0 {
+		return defaults, nil
+	}
+	var fields FieldSet
+	var unknown []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if _, ok := stationFieldsByName[name]; !ok {
+			unknown = append(unknown, name)
+		} else if !seen[name] {
+			seen[name] = true
+			fields = append(fields, name)
+		}
+	}
+	return fields, unknown
+}
+
+/*
+ * 	Encodes the selected fields in the order they were asked for
+ */
+func (s SparseStation) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, name := range s.Fields {
+		valueJSON, err := json.Marshal(stationFieldsByName[name].value(s.Station))
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.WriteString(`"` + name + `":`)
+		buffer.Write(valueJSON)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+/*
+ * 	Reads the field set for a listing endpoint from ?fields=, answering 400
+ * 	and returning false when it names fields that do not exist
+ */
+func requestFieldSet(w http.ResponseWriter, req *http.Request, endpoint string, contextLogger *log.Entry) (FieldSet, bool) {
+	defaults := defaultStationFields[endpoint]
+	if requestStationFilter(req).includeStale {
+		defaults = append(defaults[:len(defaults):len(defaults)], "stale")
+	}
+	fields, unknown := parseFieldSet(req.URL.Query().Get("fields"), defaults)
+	if len(unknown) > 0 {
+		l := newLocalizer(req)
+		l.SetHeader(w)
+		available := make([]string, len(stationFields))
+		for i, field := range stationFields {
+			available[i] = field.name
+		}
+		message := l.Message("fields.unknown", MessageArgs{"fields": strings.Join(unknown, ", "), "available": strings.Join(available, ", ")})
+		http.Error(w, message, http.StatusBadRequest)
+		contextLogger.Warn("Unknown fields requested")
+		return nil, false
+	}
+	return fields, true
+}
+
+/*
+ * 	Builds responses for stations[startResults:endResults] showing fields
+ */
+func buildStationResponses(stations []Station, startResults int, endResults int, fields FieldSet) []SparseStation {
+	var stationInfo []SparseStation
+	for i := startResults; i < endResults; i++ {
+		stationInfo = append(stationInfo, SparseStation{Station: newStationResponse(stations[i]), Fields: fields})
+	}
+	return stationInfo
+}
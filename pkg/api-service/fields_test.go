@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFieldSet(t *testing.T) {
+	defaults := FieldSet{"stationName"}
+	if fields, unknown := parseFieldSet("", defaults); !reflect.DeepEqual(fields, defaults) || unknown != nil {
+		t.Errorf("Expected the defaults without ?fields=, but received %v %v", fields, unknown)
+	}
+	if fields, unknown := parseFieldSet("availableDocks, id,availableDocks", defaults); !reflect.DeepEqual(fields, FieldSet{"availableDocks", "id"}) || unknown != nil {
+		t.Errorf("Expected availableDocks and id once each, but received %v %v", fields, unknown)
+	}
+	if _, unknown := parseFieldSet("id,secret,ID", defaults); !reflect.DeepEqual(unknown, []string{"secret", "ID"}) {
+		t.Errorf("Expected secret and ID to be unknown, but received %v", unknown)
+	}
+}
+
+func TestGetStationsWithFields(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/in-service?fields=id,availableDocks,statusValue", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `[{"id":72,"availableDocks":32,"statusValue":"In Service"},` +
+		`{"id":79,"availableDocks":0,"statusValue":"In Service"},` +
+		`{"id":82,"availableDocks":27,"statusValue":"In Service"},` +
+		`{"id":83,"availableDocks":21,"statusValue":"In Service"},` +
+		`{"id":116,"availableDocks":19,"statusValue":"In Service"}]` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestGetStationsWithUnknownFields(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/atlantic?fields=id,secret,bikes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "secret, bikes") || !strings.Contains(w.Body.String(), "availableBikes") {
+		t.Errorf("Expected 400 naming the unknown fields, but received %v %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	forecastSlot          = 30 * time.Minute
+	forecastLookback      = 28 * 24 * time.Hour
+	maxForecastHorizon    = 7 * 24 * time.Hour
+	trendHalfLife         = time.Hour
+	forecastZ             = 1.96
+	minForecastSamples    = 2
+	slotsPerDay           = int(24 * time.Hour / forecastSlot)
+	defaultForecastSpread = 0.2
+)
+
+// ForecastValue - a predicted count with a 95% interval around it
+type ForecastValue struct {
+	Predicted float64 `json:"predicted"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+}
+
+// Forecast - contains JSON fields needed for endpoint "/stations/:stationid/forecast"
+type Forecast struct {
+	ID             int           `json:"id"`
+	At             string        `json:"at"`
+	AvailableBikes ForecastValue `json:"availableBikes"`
+	AvailableDocks ForecastValue `json:"availableDocks"`
+	Samples        int           `json:"samples"`
+}
+
+// runningStats - count, mean and variance accumulated one value at a time (Welford)
+type runningStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+// slotProfile - bike and dock statistics for one weekday and time of day
+type slotProfile struct {
+	bikes runningStats
+	docks runningStats
+}
+
+// forecastModel - per-weekday, per-time-of-day averages of one station plus its latest sample
+type forecastModel struct {
+	weekly  [7][]slotProfile
+	daily   []slotProfile
+	latest  StationSample
+	trained bool
+}
+
+/*
+ * 	Adds one value to the running statistics
+ */
+func (stats *runningStats) add(value float64) {
+	stats.n++
+	delta := value - stats.mean
+	stats.mean += delta / float64(stats.n)
+	stats.m2 += delta * (value - stats.mean)
+}
+
+/*
+ * 	Returns the sample standard deviation, or zero with fewer than two values
+ */
+func (stats runningStats) stddev() float64 {
+	if stats.n < 2 {
+		return 0
+	}
+	return math.Sqrt(stats.m2 / float64(stats.n-1))
+}
+
+/*
+ * 	Returns the index of the time-of-day slot t falls in, in the feed's timezone
+ */
+func slotOf(t time.Time) int {
+	local := t.In(feedLocation)
+	return (local.Hour()*60 + local.Minute()) / int(forecastSlot/time.Minute)
+}
+
+/*
+ * 	Builds a model from one station's samples, which must be in time order
+ */
+func trainForecastModel(samples []StationSample) forecastModel {
+	model := forecastModel{daily: make([]slotProfile, slotsPerDay)}
+	for day := range model.weekly {
+		model.weekly[day] = make([]slotProfile, slotsPerDay)
+	}
+	for _, sample := range samples {
+		weekday, slot := sample.Time.In(feedLocation).Weekday(), slotOf(sample.Time)
+		model.weekly[weekday][slot].bikes.add(float64(sample.AvailableBikes))
+		model.weekly[weekday][slot].docks.add(float64(sample.AvailableDocks))
+		model.daily[slot].bikes.add(float64(sample.AvailableBikes))
+		model.daily[slot].docks.add(float64(sample.AvailableDocks))
+		model.latest = sample
+		model.trained = true
+	}
+	return model
+}
+
+/*
+ * 	Returns the profile for t's weekday and slot, falling back to the same slot on
+ * 	any weekday when that weekday has too few samples
+ */
+func (model forecastModel) profileAt(t time.Time) slotProfile {
+	profile := model.weekly[t.In(feedLocation).Weekday()][slotOf(t)]
+	if profile.bikes.n < minForecastSamples {
+		profile = model.daily[slotOf(t)]
+	}
+	return profile
+}
+
+/*
+ * 	Predicts one value: the profile mean plus the latest sample's deviation from
+ * 	its own profile, decayed by half every trendHalfLife of horizon. Without a
+ * 	profile for the target time the latest value is carried forward.
+ */
+func predictValue(profile, latestProfile runningStats, latest int, horizon time.Duration, capacity int) ForecastValue {
+	baseline := float64(latest)
+	spread := defaultForecastSpread * float64(capacity)
+	if profile.n > 0 {
+		baseline = profile.mean
+		spread = math.Max(profile.stddev(), 1)
+	}
+	if profile.n > 0 && latestProfile.n > 0 {
+		decay := math.Pow(0.5, float64(horizon)/float64(trendHalfLife))
+		baseline += (float64(latest) - latestProfile.mean) * decay
+	}
+
+	clamp := func(f float64) float64 {
+		return math.Round(math.Min(math.Max(f, 0), float64(capacity))*10) / 10
+	}
+	return ForecastValue{
+		Predicted: clamp(baseline),
+		Low:       clamp(baseline - forecastZ*spread),
+		High:      clamp(baseline + forecastZ*spread),
+	}
+}
+
+/*
+ * 	Predicts bikes and docks at time at. Without any samples the model cannot
+ * 	predict and returns false.
+ */
+func (model forecastModel) Predict(at time.Time) (Forecast, bool) {
+	if !model.trained {
+		return Forecast{}, false
+	}
+	horizon := at.Sub(model.latest.Time)
+	if horizon < 0 {
+		horizon = 0
+	}
+	profile, latestProfile := model.profileAt(at), model.profileAt(model.latest.Time)
+	return Forecast{
+		ID:             model.latest.ID,
+		At:             at.In(feedLocation).Format(time.RFC3339),
+		AvailableBikes: predictValue(profile.bikes, latestProfile.bikes, model.latest.AvailableBikes, horizon, model.latest.TotalDocks),
+		AvailableDocks: predictValue(profile.docks, latestProfile.docks, model.latest.AvailableDocks, horizon, model.latest.TotalDocks),
+		Samples:        profile.bikes.n,
+	}, true
+}
+
+/*
+ * 	Trains a model on the four weeks of history up to the latest recorded
+ * 	snapshot and predicts time at
+ */
+func forecastStation(stationID int, at time.Time) (Forecast, bool, error) {
+	latest, err := history.Latest()
+	if err != nil || latest.IsZero() {
+		return Forecast{}, false, err
+	}
+	samples, err := history.Series(stationID, latest.Add(-forecastLookback), latest)
+	if err != nil {
+		return Forecast{}, false, err
+	}
+	forecast, ok := trainForecastModel(samples).Predict(at)
+	return forecast, ok, nil
+}
+
+/*
+ * 	Decides whether numBikes can be returned to station at the time of forecast,
+ * 	using the predicted rather than the current number of available docks
+ */
+func checkDockableAt(stations []Station, station Station, numBikes int, forecast Forecast, l localizer) DockableInfo {
+	expected := station
+	expected.AvailableDocks = int(math.Floor(forecast.AvailableDocks.Predicted))
+	dockableInfo := checkDockable(stations, expected, numBikes, l)
+	args := MessageArgs{"bikes": numBikes, "docks": expected.AvailableDocks, "time": forecast.At}
+	switch dockableInfo.Reason {
+	case ReasonOK:
+		dockableInfo.Message = l.Message("dockable.forecastOk", args)
+	case ReasonInsufficientDocks:
+		dockableInfo.Message = l.Message("dockable.forecastInsufficient", args)
+	}
+	dockableInfo.Forecast = &forecast
+	return dockableInfo
+}
+
+/*
+ *	Endpoint: /stations/:stationid/forecast?at=
+ *
+ *	Predicts the station's available bikes and docks at time at (default: now),
+ *	with a 95% interval, from the last four weeks of recorded history.
+ */
+func getStationForecast(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing getStationForecast entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"stationid": mux.Vars(req)["stationid"],
+			"at":        req.URL.Query().Get("at"),
+			"Path":      req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !historyEnabled(w, l) {
+		return
+	}
+
+	stationID, _ := strconv.Atoi(mux.Vars(req)["stationid"])
+	at, timeErr := parseQueryTime(req.URL.Query().Get("at"), time.Now())
+	if timeErr != nil || at.Sub(time.Now()) > maxForecastHorizon {
+		http.Error(w, l.Message("forecast.invalidTime", nil), http.StatusBadRequest)
+		contextLogger.Warn("Invalid forecast time")
+		return
+	}
+	forecast, ok, err := forecastStation(stationID, at)
+	if err != nil {
+		contextLogger.Error("Error reading station history", err)
+		http.Error(w, l.Message("history.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, l.Message("history.notFound", nil), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, req, http.StatusOK, forecast, contextLogger)
+}
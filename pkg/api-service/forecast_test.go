@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+/*
+ * 	Builds four weeks of samples with 10 bikes at 8am and 2 bikes at 6pm every day
+ */
+func commuterSamples() []StationSample {
+	var samples []StationSample
+	start := time.Date(2016, 1, 4, 0, 0, 0, 0, feedLocation)
+	for day := 0; day < 28; day++ {
+		for _, reading := range []struct{ hour, bikes int }{{8, 9}, {8, 11}, {18, 2}} {
+			samples = append(samples, StationSample{
+				Time: start.AddDate(0, 0, day).Add(time.Duration(reading.hour) * time.Hour),
+				StationState: StationState{
+					ID:             72,
+					AvailableBikes: reading.bikes,
+					AvailableDocks: 20 - reading.bikes,
+					TotalDocks:     20,
+					StatusValue:    string(inServiceStatus),
+				},
+			})
+		}
+	}
+	return samples
+}
+
+func TestForecastModelProfile(t *testing.T) {
+	model := trainForecastModel(commuterSamples())
+	at := time.Date(2016, 2, 8, 8, 10, 0, 0, feedLocation)
+	forecast, ok := model.Predict(at)
+	if !ok {
+		t.Fatal("Expected a trained model to predict")
+	}
+	if forecast.AvailableBikes.Predicted != 10 || forecast.AvailableDocks.Predicted != 10 {
+		t.Errorf("Expected 10 bikes and 10 docks at 8am, but received %+v", forecast)
+	}
+	if forecast.AvailableBikes.Low >= 10 || forecast.AvailableBikes.High <= 10 || forecast.Samples != 8 {
+		t.Errorf("Expected an interval around 10 from 8 samples, but received %+v", forecast)
+	}
+}
+
+func TestForecastModelTrend(t *testing.T) {
+	samples := commuterSamples()
+	latest := samples[len(samples)-2]
+	latest.Time = latest.Time.Add(time.Minute)
+	latest.AvailableBikes = 16
+	model := trainForecastModel(append(samples[:len(samples)-1], latest))
+
+	soon, _ := model.Predict(latest.Time.Add(28 * time.Minute))
+	nextWeek, _ := model.Predict(latest.Time.Add(7 * 24 * time.Hour))
+	mean := (4*(9+11) + 16.0) / 9
+	if expected := mean + (16-mean)*math.Pow(0.5, 28.0/60); math.Abs(soon.AvailableBikes.Predicted-expected) > 0.05 {
+		t.Errorf("Expected about %.1f bikes shortly after an unusual reading, but received %.1f", expected, soon.AvailableBikes.Predicted)
+	}
+	if math.Abs(nextWeek.AvailableBikes.Predicted-mean) > 0.05 {
+		t.Errorf("Expected the trend to fade after a week, but received %.1f bikes", nextWeek.AvailableBikes.Predicted)
+	}
+}
+
+func TestForecastModelUntrained(t *testing.T) {
+	if _, ok := trainForecastModel(nil).Predict(time.Now()); ok {
+		t.Errorf("Expected an untrained model not to predict")
+	}
+}
+
+func TestGetStationForecast(t *testing.T) {
+	recordTestHistory(t)
+	req, err := http.NewRequest("GET", "/stations/72/forecast?at=2016-01-22T17:30:00&pretty=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `{
+    "id": 72,
+    "at": "2016-01-22T17:30:00-05:00",
+    "availableBikes": {
+        "predicted": 4,
+        "low": 0,
+        "high": 19.3
+    },
+    "availableDocks": {
+        "predicted": 35,
+        "low": 19.7,
+        "high": 39
+    },
+    "samples": 0
+}`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestReturnBikesWithArrival(t *testing.T) {
+	recordTestHistory(t)
+	tests := map[string]string{
+		"/stations/72/30?arrival=2016-01-22T17:30:00": "You should be able to return all 30 of your bikes. About 35 docks are expected to be available at 2016-01-22T17:30:00-05:00.",
+		"/stations/72/36?arrival=2016-01-22T17:30:00": "You may not be able to return all 36 of your bikes. About 35 docks are expected to be available at 2016-01-22T17:30:00-05:00.",
+	}
+	for path, message := range tests {
+		mockStationFeed(allStationsJSON)
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+
+		dockableInfo := decodeDockableInfo(t, w)
+		if dockableInfo.Message != message || dockableInfo.Forecast == nil || dockableInfo.AvailableDocks != 35 {
+			t.Errorf("Expected %q with a forecast of 35 docks, but received %+v", message, dockableInfo)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const (
+	defaultNearbyRadiusKm = 1.0
+	defaultNearbyLimit    = 10
+	maxGraphQLListLimit   = 1000
+)
+
+// GraphQLRequest - a query sent as JSON in a POST body, or as ?query=, ?variables= and ?operationName=
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// GraphQLError - an error in the shape GraphQL clients expect
+type GraphQLError struct {
+	Message string `json:"message"`
+}
+
+// NearbyStation - a station and how far it is from the point searched around
+type NearbyStation struct {
+	Station    StationResponse `json:"station"`
+	DistanceKm float64         `json:"distanceKm"`
+}
+
+// SystemInfo - totals across every station in a snapshot
+type SystemInfo struct {
+	ExecutionTime  string `json:"executionTime"`
+	Stations       int    `json:"stations"`
+	InService      int    `json:"inService"`
+	NotInService   int    `json:"notInService"`
+	AvailableBikes int    `json:"availableBikes"`
+	AvailableDocks int    `json:"availableDocks"`
+	TotalDocks     int    `json:"totalDocks"`
+}
+
+// graphqlContext - what resolvers share for one request: a single snapshot and the caller's language
+type graphqlContext struct {
+	data StationData
+	l    localizer
+}
+
+// graphqlContextKey - the context key a request's graphqlContext is stored under
+type graphqlContextKey struct{}
+
+// graphqlSchema - the Query type served at /graphql
+var graphqlSchema = newGraphQLSchema()
+
+// graphqlFieldScopes - the scope each Query field needs, matching its REST route
+var graphqlFieldScopes = map[string]string{
+	"search":   ScopeReadStations,
+	"dockable": ScopeReadStations,
+}
+
+/*
+ * 	Builds the schema. Station, NearbyStation, StationOption and DockableInfo
+ * 	resolve from the json tags of the REST response types, so both APIs name
+ * 	fields the same way. Fields that need a scope are nullable so that a
+ * 	caller without it still gets the rest of the query.
+ */
+func newGraphQLSchema() graphql.Schema {
+	stationStatus := graphql.NewEnum(graphql.EnumConfig{
+		Name: "StationStatus",
+		Values: graphql.EnumValueConfigMap{
+			"IN_SERVICE":     &graphql.EnumValueConfig{Value: string(inServiceStatus)},
+			"NOT_IN_SERVICE": &graphql.EnumValueConfig{Value: string(notInServiceStatus)},
+			"UNKNOWN":        &graphql.EnumValueConfig{Value: string(unknownStatus)},
+		},
+	})
+	dockableReason := graphql.NewEnum(graphql.EnumConfig{
+		Name: "DockableReason",
+		Values: graphql.EnumValueConfigMap{
+			string(ReasonOK):                &graphql.EnumValueConfig{Value: ReasonOK},
+			string(ReasonInsufficientDocks): &graphql.EnumValueConfig{Value: ReasonInsufficientDocks},
+			string(ReasonNotInService):      &graphql.EnumValueConfig{Value: ReasonNotInService},
+			string(ReasonStationUnknown):    &graphql.EnumValueConfig{Value: ReasonStationUnknown},
+			string(ReasonInvalidCount):      &graphql.EnumValueConfig{Value: ReasonInvalidCount},
+			string(ReasonStatusUnknown):     &graphql.EnumValueConfig{Value: ReasonStatusUnknown},
+		},
+	})
+
+	nearbyArgs := graphql.FieldConfigArgument{
+		"radiusKm": &graphql.ArgumentConfig{Type: graphql.Float, DefaultValue: defaultNearbyRadiusKm},
+		"limit":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultNearbyLimit},
+	}
+	includeTest := &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false, Description: "Also return test stations"}
+	includeStale := &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false, Description: "Also return stations that have stopped communicating"}
+	var nearbyStation *graphql.Object
+	station := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Station",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":                    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"stationName":           &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"stAddress1":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"statusValue":           &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"availableBikes":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"availableDocks":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"totalDocks":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"latitude":              &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"longitude":             &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"lastCommunicationTime": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"stale":                 &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+				"nearby": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(nearbyStation))),
+					Description: "Other stations within radiusKm of this one, nearest first",
+					Args:        nearbyArgs,
+					Resolve:     resolveStationNearby,
+				},
+			}
+		}),
+	})
+	nearbyStation = graphql.NewObject(graphql.ObjectConfig{
+		Name: "NearbyStation",
+		Fields: graphql.Fields{
+			"station":    &graphql.Field{Type: graphql.NewNonNull(station)},
+			"distanceKm": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+	stationOption := graphql.NewObject(graphql.ObjectConfig{
+		Name: "StationOption",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"stationName": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"stAddress1":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"available":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"bikes":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"distanceKm":  &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+	dockableInfo := graphql.NewObject(graphql.ObjectConfig{
+		Name: "DockableInfo",
+		Fields: graphql.Fields{
+			"dockable":       &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"message":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"reason":         &graphql.Field{Type: graphql.NewNonNull(dockableReason)},
+			"stationStatus":  &graphql.Field{Type: graphql.String},
+			"availableDocks": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"requested":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"shortfall":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"warning":        &graphql.Field{Type: graphql.String},
+			"alternatives":   &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(stationOption)))},
+			"split":          &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(stationOption)))},
+		},
+	})
+	system := graphql.NewObject(graphql.ObjectConfig{
+		Name: "System",
+		Fields: graphql.Fields{
+			"executionTime":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"stations":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"inService":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"notInService":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"availableBikes": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"availableDocks": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"totalDocks":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"station": &graphql.Field{
+				Type:    station,
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: resolveStation,
+			},
+			"stations": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(station))),
+				Description: "Stations in feed order, optionally only those with status. Test and stale stations are left out unless included.",
+				Args: graphql.FieldConfigArgument{
+					"status":       &graphql.ArgumentConfig{Type: stationStatus},
+					"limit":        &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: itemsPerPage},
+					"offset":       &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"includeTest":  includeTest,
+					"includeStale": includeStale,
+				},
+				Resolve: resolveStations,
+			},
+			"nearby": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(nearbyStation))),
+				Description: "Stations within radiusKm of a point, nearest first",
+				Args: graphql.FieldConfigArgument{
+					"latitude":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+					"longitude": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+					"radiusKm":  nearbyArgs["radiusKm"],
+					"limit":     nearbyArgs["limit"],
+				},
+				Resolve: resolveNearby,
+			},
+			"search": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(station)),
+				Description: "Stations whose name or address contains query, like /stations/{searchstring}",
+				Args: graphql.FieldConfigArgument{
+					"query":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"includeTest":  includeTest,
+					"includeStale": includeStale,
+				},
+				Resolve: resolveSearch,
+			},
+			"dockable": &graphql.Field{
+				Type:        dockableInfo,
+				Description: "Whether bikes can be returned to a station, like /stations/{stationid}/{bikestoreturn}",
+				Args: graphql.FieldConfigArgument{
+					"stationId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"bikes":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: resolveDockable,
+			},
+			"system": &graphql.Field{
+				Type:    graphql.NewNonNull(system),
+				Resolve: resolveSystem,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		log.Fatal("Error building GraphQL schema", err)
+	}
+	return schema
+}
+
+/*
+ * 	Returns the request's snapshot and localizer, after checking the caller
+ * 	holds the scope the resolved Query field needs
+ */
+func graphqlRequestContext(p graphql.ResolveParams) (*graphqlContext, error) {
+	gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+	scope, listed := graphqlFieldScopes[p.Info.FieldName]
+	if !listed || p.Info.ParentType.Name() != "Query" || apiKeys == nil && jwtVerifier == nil {
+		return gc, nil
+	}
+	if principal, ok := p.Context.Value(principalContextKey{}).(Principal); !ok || !principal.HasScope(scope) {
+		return nil, GraphQLError{Message: gc.l.Message("auth.forbidden", MessageArgs{"scope": scope})}
+	}
+	return gc, nil
+}
+
+/*
+ * 	Returns the error message
+ */
+func (e GraphQLError) Error() string {
+	return e.Message
+}
+
+/*
+ * 	Returns stations within radiusKm of origin, nearest first, leaving out
+ * 	origin itself and keeping at most limit
+ */
+func nearbyStations(stations []Station, origin Station, radiusKm float64, limit int) []NearbyStation {
+	nearby := []NearbyStation{}
+	for _, station := range stations {
+		if origin.ID != 0 && station.ID == origin.ID {
+			continue
+		}
+		if distance := distanceKm(origin, station); distance <= radiusKm {
+			nearby = append(nearby, NearbyStation{Station: newStationResponse(station), DistanceKm: distance})
+		}
+	}
+	sort.SliceStable(nearby, func(a, b int) bool {
+		return nearby[a].DistanceKm < nearby[b].DistanceKm
+	})
+	if len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby
+}
+
+/* Resolves Query.station */
+func resolveStation(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	station, found := findStation(gc.data.StationBeanList, strconv.Itoa(p.Args["id"].(int)))
+	if !found {
+		return nil, nil
+	}
+	return newStationResponse(station), nil
+}
+
+/*
+ * 	Reads the includeTest and includeStale arguments of a field
+ */
+func graphqlStationFilter(p graphql.ResolveParams) stationFilter {
+	includeTest, _ := p.Args["includeTest"].(bool)
+	includeStale, _ := p.Args["includeStale"].(bool)
+	return stationFilter{includeTest: includeTest, includeStale: includeStale}
+}
+
+/*
+ * 	Reads the limit argument of a field, rejecting a negative one and keeping
+ * 	it to at most maxGraphQLListLimit
+ */
+func graphqlLimit(p graphql.ResolveParams, l localizer) (int, error) {
+	limit := p.Args["limit"].(int)
+	if limit < 0 {
+		return 0, GraphQLError{Message: l.Message("graphql.invalidLimit", nil)}
+	}
+	return min(limit, maxGraphQLListLimit), nil
+}
+
+/* Resolves Query.stations */
+func resolveStations(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := graphqlLimit(p, gc.l)
+	if err != nil {
+		return nil, err
+	}
+	status, _ := p.Args["status"].(string)
+	offset := p.Args["offset"].(int)
+	stations := []StationResponse{}
+	for _, station := range graphqlStationFilter(p).apply(gc.data.StationBeanList) {
+		if status != "" && string(station.StatusValue) != status {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(stations) == limit {
+			break
+		}
+		stations = append(stations, newStationResponse(station))
+	}
+	return stations, nil
+}
+
+/* Resolves Query.nearby */
+func resolveNearby(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := graphqlLimit(p, gc.l)
+	if err != nil {
+		return nil, err
+	}
+	origin := Station{Latitude: p.Args["latitude"].(float64), Longitude: p.Args["longitude"].(float64)}
+	return nearbyStations(gc.data.StationBeanList, origin, p.Args["radiusKm"].(float64), limit), nil
+}
+
+/* Resolves Station.nearby */
+func resolveStationNearby(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := graphqlLimit(p, gc.l)
+	if err != nil {
+		return nil, err
+	}
+	source := p.Source.(StationResponse)
+	origin := Station{ID: source.ID, Latitude: source.Latitude, Longitude: source.Longitude}
+	return nearbyStations(gc.data.StationBeanList, origin, p.Args["radiusKm"].(float64), limit), nil
+}
+
+/* Resolves Query.search */
+func resolveSearch(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	stations := []StationResponse{}
+	for _, station := range matchStations(graphqlStationFilter(p).apply(gc.data.StationBeanList), p.Args["query"].(string)) {
+		stations = append(stations, newStationResponse(station))
+	}
+	return stations, nil
+}
+
+/* Resolves Query.dockable with the same answers as returnBikes */
+func resolveDockable(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	bikes := p.Args["bikes"].(int)
+	var dockableInfo DockableInfo
+	if bikes <= 0 {
+		dockableInfo = dockableError(ReasonInvalidCount, bikes, gc.l)
+	} else if station, found := findStation(gc.data.StationBeanList, strconv.Itoa(p.Args["stationId"].(int))); found {
+		dockableInfo = checkDockable(gc.data.StationBeanList, station, bikes, gc.l)
+	} else {
+		dockableInfo = dockableError(ReasonStationUnknown, bikes, gc.l)
+	}
+	if dockableInfo.Alternatives == nil {
+		dockableInfo.Alternatives = []StationOption{}
+	}
+	if dockableInfo.Split == nil {
+		dockableInfo.Split = []StationOption{}
+	}
+	return dockableInfo, nil
+}
+
+/* Resolves Query.system */
+func resolveSystem(p graphql.ResolveParams) (interface{}, error) {
+	gc, err := graphqlRequestContext(p)
+	if err != nil {
+		return nil, err
+	}
+	system := SystemInfo{ExecutionTime: gc.data.ExecutionTime, Stations: len(gc.data.StationBeanList)}
+	for _, station := range gc.data.StationBeanList {
+		switch station.StatusValue {
+		case inServiceStatus:
+			system.InService++
+		case notInServiceStatus:
+			system.NotInService++
+		}
+		system.AvailableBikes += station.AvailableBikes
+		system.AvailableDocks += station.AvailableDocks
+		system.TotalDocks += station.TotalDocks
+	}
+	return system, nil
+}
+
+/*
+ * 	Measures how deeply a query nests fields and how many it could resolve.
+ * 	Each field costs 1 plus the cost of its selections, which count once per
+ * 	item for list fields, as many as graphqlListSize says they may return.
+ * 	Introspection fields are free so GraphiQL can load the schema.
+ */
+func measureGraphQLQuery(document *ast.Document, schema graphql.Schema, variables map[string]interface{}, stationCount int) (depth int, complexity int) {
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	var measure func(selections *ast.SelectionSet, parent graphql.Type, level int, visiting map[string]bool) (int, int)
+	measure = func(selections *ast.SelectionSet, parent graphql.Type, level int, visiting map[string]bool) (int, int) {
+		if selections == nil {
+			return level - 1, 0
+		}
+		deepest, cost := level-1, 0
+		for _, selection := range selections.Selections {
+			var childDepth, childCost int
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if strings.HasPrefix(selection.Name.Value, "__") {
+					continue
+				}
+				var definition *graphql.FieldDefinition
+				var fieldType graphql.Type
+				if object, ok := parent.(*graphql.Object); ok {
+					if field, ok := object.Fields()[selection.Name.Value]; ok {
+						definition, fieldType = field, field.Type
+					}
+				}
+				isList := false
+				for unwrapped := true; unwrapped; {
+					switch wrapper := fieldType.(type) {
+					case *graphql.NonNull:
+						fieldType = wrapper.OfType
+					case *graphql.List:
+						fieldType, isList = wrapper.OfType, true
+					default:
+						unwrapped = false
+					}
+				}
+				childDepth, childCost = measure(selection.SelectionSet, fieldType, level+1, visiting)
+				if isList {
+					childCost *= graphqlListSize(selection, definition, variables, stationCount)
+				}
+				childDepth, childCost = max(childDepth, level), childCost+1
+			case *ast.InlineFragment:
+				childDepth, childCost = measure(selection.SelectionSet, fragmentType(schema, selection.TypeCondition, parent), level, visiting)
+			case *ast.FragmentSpread:
+				fragment, ok := fragments[selection.Name.Value]
+				if !ok || visiting[fragment.Name.Value] {
+					continue
+				}
+				visiting[fragment.Name.Value] = true
+				childDepth, childCost = measure(fragment.SelectionSet, fragmentType(schema, fragment.TypeCondition, parent), level, visiting)
+				delete(visiting, fragment.Name.Value)
+			}
+			deepest, cost = max(deepest, childDepth), cost+childCost
+		}
+		return deepest, cost
+	}
+
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			operationDepth, operationCost := measure(operation.SelectionSet, schema.QueryType(), 1, map[string]bool{})
+			depth, complexity = max(depth, operationDepth), max(complexity, operationCost)
+		}
+	}
+	return depth, complexity
+}
+
+/*
+ * 	Returns the type a fragment applies to, or parent when it has no condition
+ */
+func fragmentType(schema graphql.Schema, condition *ast.Named, parent graphql.Type) graphql.Type {
+	if condition == nil || condition.Name == nil {
+		return parent
+	}
+	return schema.Type(condition.Name.Value)
+}
+
+/*
+ * 	Returns how many items a list field may return: its limit argument, given
+ * 	inline or as a variable and at most maxGraphQLListLimit, or the default
+ * 	the resolver uses when it is left out. Lists without a limit argument, and
+ * 	negative limits, may return every station.
+ */
+func graphqlListSize(field *ast.Field, definition *graphql.FieldDefinition, variables map[string]interface{}, stationCount int) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "limit" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if limit, err := strconv.Atoi(value.Value); err == nil && limit >= 0 {
+				return min(limit, maxGraphQLListLimit)
+			}
+		case *ast.Variable:
+			if limit, ok := variables[value.Name.Value].(float64); ok && limit >= 0 {
+				return min(int(limit), maxGraphQLListLimit)
+			}
+		}
+		return stationCount
+	}
+	if definition != nil {
+		for _, argument := range definition.Args {
+			if limit, ok := argument.DefaultValue.(int); ok && argument.Name() == "limit" {
+				return min(limit, maxGraphQLListLimit)
+			}
+		}
+	}
+	return stationCount
+}
+
+/*
+ * 	Reads a query from ?query=, ?variables= and ?operationName= or from a JSON body
+ */
+func readGraphQLRequest(req *http.Request) (GraphQLRequest, error) {
+	request := GraphQLRequest{}
+	if req.Method == "POST" {
+		err := json.NewDecoder(req.Body).Decode(&request)
+		return request, err
+	}
+	query := req.URL.Query()
+	request.Query = query.Get("query")
+	request.OperationName = query.Get("operationName")
+	if variables := query.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return request, err
+		}
+	}
+	return request, nil
+}
+
+/*
+ * 	Writes errors as a GraphQL response with status
+ */
+func writeGraphQLErrors(w http.ResponseWriter, req *http.Request, status int, contextLogger *log.Entry, messages ...string) {
+	errors := make([]GraphQLError, len(messages))
+	for i, message := range messages {
+		errors[i] = GraphQLError{Message: message}
+	}
+	writeJSON(w, req, status, map[string][]GraphQLError{"errors": errors}, contextLogger)
+}
+
+/*
+ *	Endpoint: /graphql
+ *
+ * 	Runs a GraphQL query against a single station snapshot. Queries nested
+ * 	more deeply than graphql-max-depth or costing more than
+ * 	graphql-max-complexity are rejected with 400 before they run, as are
+ * 	queries that cannot be parsed or validated.
+ */
+func serveGraphQL(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing serveGraphQL entrypoint")
+	contextLogger := log.WithFields(log.Fields{"Path": req.URL.Path})
+
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	request, err := readGraphQLRequest(req)
+	if err != nil || strings.TrimSpace(request.Query) == "" {
+		writeGraphQLErrors(w, req, http.StatusBadRequest, contextLogger, l.Message("graphql.invalidRequest", nil))
+		contextLogger.Warn("Invalid GraphQL request")
+		return
+	}
+
+	data, err := store.Snapshot()
+	if err != nil {
+		contextLogger.Error("Error retrieving stations", err)
+		writeGraphQLErrors(w, req, http.StatusBadGateway, contextLogger, l.Message("stations.unavailable", nil))
+		return
+	}
+	if document, err := parser.Parse(parser.ParseParams{Source: request.Query}); err == nil {
+		depth, complexity := measureGraphQLQuery(document, graphqlSchema, request.Variables, len(data.StationBeanList))
+		var violations []string
+		if depth > *graphqlMaxDepth {
+			violations = append(violations, l.Message("graphql.tooDeep", MessageArgs{"depth": depth, "max": *graphqlMaxDepth}))
+		}
+		if complexity > *graphqlMaxComplexity {
+			violations = append(violations, l.Message("graphql.tooComplex", MessageArgs{"complexity": complexity, "max": *graphqlMaxComplexity}))
+		}
+		if len(violations) > 0 {
+			writeGraphQLErrors(w, req, http.StatusBadRequest, contextLogger, violations...)
+			contextLogger.WithFields(log.Fields{"depth": depth, "complexity": complexity}).Warn("GraphQL query over limits")
+			return
+		}
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        context.WithValue(req.Context(), graphqlContextKey{}, &graphqlContext{data: data, l: l}),
+	})
+	status := http.StatusOK
+	if result.Data == nil && result.HasErrors() {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, req, status, result, contextLogger)
+}
+
+/*
+ *	Endpoint: /graphiql
+ *
+ * 	Serves GraphiQL pointed at /graphql, for exploring the schema locally
+ */
+func serveGraphiQL(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(graphiqlPage))
+}
+
+// graphiqlPage - GraphiQL loaded from a CDN, sending queries to /graphql
+const graphiqlPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Stations GraphiQL</title>
+	<link rel="stylesheet" href="https://unpkg.com/graphiql@1.4.7/graphiql.min.css" />
+</head>
+<body style="margin: 0;">
+	<div id="graphiql" style="height: 100vh;"></div>
+	<script crossorigin src="https://unpkg.com/react@17/umd/react.production.min.js"></script>
+	<script crossorigin src="https://unpkg.com/react-dom@17/umd/react-dom.production.min.js"></script>
+	<script crossorigin src="https://unpkg.com/graphiql@1.4.7/graphiql.min.js"></script>
+	<script>
+		var fetcher = GraphiQL.createFetcher({ url: window.location.origin + "/graphql" });
+		ReactDOM.render(React.createElement(GraphiQL, { fetcher: fetcher }), document.getElementById("graphiql"));
+	</script>
+</body>
+</html>
+`
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+/*
+ * 	Posts query to /graphql and decodes the response
+ */
+func postGraphQL(t *testing.T, query string, variables map[string]interface{}) (int, map[string]interface{}) {
+	body, err := json.Marshal(GraphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serveWithKey(t, "POST", "/graphql", "", string(body))
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON response, but received %s", w.Body.String())
+	}
+	return w.Code, response
+}
+
+func TestGraphQLStationsAndSystem(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	status, response := postGraphQL(t, `{
+		stations(status: NOT_IN_SERVICE, includeStale: true) { id stationName availableDocks stale }
+		station(id: 83) { stationName latitude longitude availableBikes }
+		missing: station(id: 1) { id }
+		system { executionTime stations inService notInService availableBikes }
+	}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v but wanted %v: %v", status, http.StatusOK, response)
+	}
+	encoded := toJSON(t, response["data"])
+	expected := `{"missing":null,` +
+		`"station":{"availableBikes":40,"latitude":40.68382604,"longitude":-73.97632328,"stationName":"Atlantic Ave & Fort Greene Pl"},` +
+		`"stations":[{"availableDocks":3,"id":423,"stale":true,"stationName":"W 54 St & 9 Ave"}],` +
+		`"system":{"availableBikes":99,"executionTime":"2016-01-22 04:32:49 PM","inService":5,"notInService":1,"stations":6}}`
+	if encoded != expected {
+		t.Errorf("Expected %s, but received %s", expected, encoded)
+	}
+
+	_, response = postGraphQL(t, `query ($limit: Int) { stations(limit: $limit, offset: 1) { id } }`, map[string]interface{}{"limit": 2})
+	if encoded := toJSON(t, response["data"]); encoded != `{"stations":[{"id":79},{"id":82}]}` {
+		t.Errorf("Expected stations 79 and 82 once stale station 423 is left out, but received %s", encoded)
+	}
+}
+
+func TestGraphQLNearbySearchAndDockable(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	_, response := postGraphQL(t, `{
+		nearby(latitude: 40.7672, longitude: -73.9939, radiusKm: 1) { distanceKm station { id } }
+		search(query: "atlantic") { id }
+		dockable(stationId: 83, bikes: 22) { dockable reason shortfall alternatives { id } }
+		invalid: dockable(stationId: 83, bikes: 0) { reason }
+	}`, nil)
+	data, _ := response["data"].(map[string]interface{})
+	if nearby, _ := data["nearby"].([]interface{}); len(nearby) != 2 || !strings.Contains(toJSON(t, nearby[0]), `"id":72`) {
+		t.Errorf("Expected stations 72 then 423 within 1km, but received %v", data["nearby"])
+	}
+	if search := toJSON(t, data["search"]); search != `[{"id":83}]` {
+		t.Errorf("Expected station 83 to match atlantic, but received %s", search)
+	}
+
+	w := serveWithKey(t, "GET", "/stations/83/22", "", "")
+	expected := decodeDockableInfo(t, w)
+	dockable, _ := data["dockable"].(map[string]interface{})
+	if dockable["dockable"] != expected.Dockable || dockable["reason"] != string(expected.Reason) || int(dockable["shortfall"].(float64)) != expected.Shortfall {
+		t.Errorf("Expected %v like /stations/83/22, but received %v", expected, dockable)
+	}
+	if invalid := toJSON(t, data["invalid"]); invalid != `{"reason":"INVALID_COUNT"}` {
+		t.Errorf("Expected an invalid count, but received %s", invalid)
+	}
+}
+
+func TestGraphQLNestedNearby(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	_, response := postGraphQL(t, `{ station(id: 72) { nearby(radiusKm: 1) { station { id nearby(radiusKm: 1) { station { id } } } } } }`, nil)
+	expected := `{"station":{"nearby":[{"station":{"id":423,"nearby":[{"station":{"id":72}}]}}]}}`
+	if encoded := toJSON(t, response["data"]); encoded != expected {
+		t.Errorf("Expected %s, but received %s", expected, encoded)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	status, response := postGraphQL(t, `{ station(id: 72) { nearby { station { nearby { station { nearby { distanceKm } } } } } } }`, nil)
+	if status != http.StatusBadRequest || !strings.Contains(toJSON(t, response), "7 levels deep") {
+		t.Errorf("Expected 400 for a query 7 levels deep, but received %v %v", status, response)
+	}
+	status, response = postGraphQL(t, `{ stations(limit: 1000) { id stationName stAddress1 statusValue availableBikes availableDocks } }`, nil)
+	if status != http.StatusBadRequest || !strings.Contains(toJSON(t, response), "6001 fields") {
+		t.Errorf("Expected 400 for a query costing 6001, but received %v %v", status, response)
+	}
+	if status, response := postGraphQL(t, `{ stations { id } __schema { types { name fields { name type { ofType { ofType { ofType { name } } } } } } } }`, nil); status != http.StatusOK {
+		t.Errorf("Expected introspection not to count towards the limits, but received %v %v", status, response)
+	}
+}
+
+func TestMeasureGraphQLQuery(t *testing.T) {
+	document, err := parser.Parse(parser.ParseParams{Source: `
+		query { stations(limit: 5) { ...names } system { stations } }
+		fragment names on Station { id nearby(limit: $n) { distanceKm } }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	depth, complexity := measureGraphQLQuery(document, graphqlSchema, map[string]interface{}{"n": 3.0}, 6)
+	if depth != 3 || complexity != 1+5*(1+1+3*1)+2 {
+		t.Errorf("Expected depth 3 and complexity 28, but received %d and %d", depth, complexity)
+	}
+}
+
+func TestGraphQLNegativeLimits(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	query := `{ stations(limit: -1) { nearby(limit: -1, radiusKm: 1e6) { station { nearby(limit: $n, radiusKm: 1e6) { distanceKm } } } } }`
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, complexity := measureGraphQLQuery(document, graphqlSchema, map[string]interface{}{"n": -1.0}, 6); complexity != 1+6*(1+6*(1+1+6*1)) {
+		t.Errorf("Expected negative limits to cost every station, but received %d", complexity)
+	}
+
+	for _, query := range []string{
+		`{ stations(limit: -1) { id } }`,
+		`{ nearby(latitude: 40.7, longitude: -74, limit: -1) { distanceKm } }`,
+		`{ station(id: 72) { nearby(limit: -1, radiusKm: 1e6) { distanceKm } } }`,
+	} {
+		_, response := postGraphQL(t, query, nil)
+		if strings.Contains(toJSON(t, response["data"]), "distanceKm") || strings.Contains(toJSON(t, response["data"]), `"id"`) || !strings.Contains(toJSON(t, response["errors"]), "limit must not be negative") {
+			t.Errorf("%s: Expected the negative limit to be rejected, but received %v", query, response)
+		}
+	}
+}
+
+func TestGraphQLDefaultLimitsOnLargeFeed(t *testing.T) {
+	stationData := fixtureStationData(t)
+	template := stationData.StationBeanList[0]
+	stationData.StationBeanList = nil
+	for i := 1; i <= 1000; i++ {
+		station := template
+		station.ID = i
+		station.Latitude += float64(i) / 10000
+		stationData.StationBeanList = append(stationData.StationBeanList, station)
+	}
+	body, err := json.Marshal(stationData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockStationFeed(string(body))
+	defer mockStationFeed(allStationsJSON)
+
+	status, response := postGraphQL(t, `{ stations { id stationName availableBikes availableDocks nearby { distanceKm station { id stationName } } } }`, nil)
+	if status != http.StatusOK || len(response["data"].(map[string]interface{})["stations"].([]interface{})) != itemsPerPage {
+		t.Errorf("Expected a default query to cost its default limits and run, but received %v %v", status, response["errors"])
+	}
+}
+
+func TestGraphQLRequests(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	w := serveWithKey(t, "GET", "/graphql?query="+url.QueryEscape(`{ system { stations } }`), "", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"data":{"system":{"stations":6}}}` {
+		t.Errorf("Expected a GET query to run, but received %v %s", w.Code, w.Body.String())
+	}
+	if w := serveWithKey(t, "POST", "/graphql", "", `{"query":""}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a query, but received %v", w.Code)
+	}
+	if status, _ := postGraphQL(t, `{ stations { secret } }`, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid query, but received %v", status)
+	}
+
+	enableTestAPIKeys(t)
+	_, response := postGraphQL(t, `{ system { stations } search(query: "atlantic") { id } }`, nil)
+	if !strings.Contains(toJSON(t, response["errors"]), "read:stations") || toJSON(t, response["data"]) != `{"search":null,"system":{"stations":6}}` {
+		t.Errorf("Expected search to need read:stations but not system, but received %v", response)
+	}
+}
+
+func TestGraphiQL(t *testing.T) {
+	if w := serveWithKey(t, "GET", "/graphiql", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected GraphiQL to be off by default, but received %v", w.Code)
+	}
+	*graphiql = true
+	defer func() { *graphiql = false }()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/graphiql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"/graphql"`) {
+		t.Errorf("Expected the GraphiQL page, but received %v", w.Code)
+	}
+}
+
+/*
+ * 	Encodes v as compact JSON
+ */
+func toJSON(t *testing.T, v interface{}) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
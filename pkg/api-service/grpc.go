@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ropenta/golang-api/pkg/stationpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMethodScopes - the scope each StationService method needs, matching its REST route
+var grpcMethodScopes = map[string]string{
+	"/stations.v1.StationService/ListStations":   scopePublic,
+	"/stations.v1.StationService/GetStation":     scopePublic,
+	"/stations.v1.StationService/SearchStations": ScopeReadStations,
+	"/stations.v1.StationService/CheckDockable":  ScopeReadStations,
+	"/stations.v1.StationService/WatchStations":  ScopeReadStations,
+}
+
+// stationServer - serves StationService from the same station store as the REST handlers
+type stationServer struct {
+	stationpb.UnimplementedStationServiceServer
+}
+
+// authorizedStream - a server stream whose context carries the caller's Principal
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+/*
+ * 	Creates a gRPC server for StationService that authenticates calls like the
+ * 	REST routes do
+ */
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := authorizeGRPC(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := authorizeGRPC(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+		}),
+	)
+	stationpb.RegisterStationServiceServer(server, &stationServer{})
+	return server
+}
+
+/* Serves StationService on addr */
+func serveGRPC(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("Error listening for gRPC", err)
+	}
+	log.Info("Starting gRPC server on " + addr)
+	if err := newGRPCServer().Serve(listener); err != nil {
+		log.Fatal("Error serving gRPC", err)
+	}
+}
+
+/*
+ * 	Returns the stream's context, which carries the caller's Principal
+ */
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+/*
+ * 	Returns a localizer for the call's accept-language metadata
+ */
+func grpcLocalizer(ctx context.Context) localizer {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return localizerFor(strings.Join(md.Get("accept-language"), ","))
+}
+
+/*
+ * 	Returns the API key or JWT sent in x-api-key or authorization metadata
+ */
+func grpcCredential(md metadata.MD) string {
+	for _, key := range md.Get("x-api-key") {
+		if key != "" {
+			return key
+		}
+	}
+	for _, authorization := range md.Get("authorization") {
+		if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+			return strings.TrimSpace(authorization[7:])
+		}
+	}
+	return ""
+}
+
+/*
+ * 	Checks a call's credentials and scope the way authorize does for REST
+ * 	routes, returning a context carrying the caller's Principal. Methods
+ * 	without a listed scope need read:stations.
+ */
+func authorizeGRPC(ctx context.Context, method string) (context.Context, error) {
+	if apiKeys == nil && jwtVerifier == nil {
+		return ctx, nil
+	}
+	scope, listed := grpcMethodScopes[method]
+	if !listed {
+		scope = ScopeReadStations
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	l := localizerFor(strings.Join(md.Get("accept-language"), ","))
+	credential := grpcCredential(md)
+	if credential == "" {
+		if scope == scopePublic {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, l.Message("auth.missing", nil))
+	}
+
+	var principal Principal
+	var key APIKey
+	authenticated := false
+	if jwtVerifier != nil && strings.Count(credential, ".") == 2 {
+		var err error
+		if principal, err = jwtVerifier.Verify(credential); err != nil {
+			log.WithFields(log.Fields{"method": method}).Warn("Rejected bearer token", err)
+			return nil, status.Error(codes.Unauthenticated, l.Message("auth.invalidToken", nil))
+		}
+		authenticated = true
+	} else if apiKeys != nil {
+		if key, authenticated = apiKeys.Authenticate(credential); authenticated {
+			principal = Principal{Subject: key.Name, KeyID: key.ID, Scopes: key.Scopes}
+		}
+	}
+	if !authenticated {
+		return nil, status.Error(codes.Unauthenticated, l.Message("auth.invalid", nil))
+	}
+	if scope != scopePublic && !principal.HasScope(scope) {
+		return nil, status.Error(codes.PermissionDenied, l.Message("auth.forbidden", MessageArgs{"scope": scope}))
+	}
+	if key.ID != "" {
+		if _, allowed := apiKeys.UseQuota(key); !allowed && key.Quota > 0 {
+			return nil, status.Error(codes.ResourceExhausted, l.Message("auth.quotaExceeded", MessageArgs{"quota": key.Quota}))
+		}
+	}
+	return context.WithValue(ctx, principalContextKey{}, principal), nil
+}
+
+/*
+ * 	Converts a station into its protobuf message
+ */
+func newProtoStation(station Station) *stationpb.Station {
+	response := newStationResponse(station)
+	return &stationpb.Station{
+		Id:                    int32(response.ID),
+		StationName:           response.StationName,
+		StAddress1:            response.StAddress1,
+		StatusValue:           response.StatusValue,
+		AvailableBikes:        int32(response.AvailableBikes),
+		AvailableDocks:        int32(response.AvailableDocks),
+		TotalDocks:            int32(response.TotalDocks),
+		Latitude:              response.Latitude,
+		Longitude:             response.Longitude,
+		LastCommunicationTime: response.LastCommunicationTime,
+		Stale:                 response.Stale,
+	}
+}
+
+/*
+ * 	Converts suggested stations into their protobuf messages
+ */
+func newProtoStationOptions(options []StationOption) []*stationpb.StationOption {
+	var messages []*stationpb.StationOption
+	for _, option := range options {
+		messages = append(messages, &stationpb.StationOption{
+			Id:          int32(option.ID),
+			StationName: option.StationName,
+			StAddress1:  option.StAddress1,
+			Available:   int32(option.Available),
+			Bikes:       int32(option.Bikes),
+			DistanceKm:  option.DistanceKm,
+		})
+	}
+	return messages
+}
+
+/*
+ * 	Returns the current snapshot, or Unavailable if the feed cannot be read
+ */
+func grpcSnapshot(ctx context.Context, method string) (StationData, error) {
+	data, err := store.Snapshot()
+	if err != nil {
+		log.WithFields(log.Fields{"method": method}).Error("Error retrieving stations", err)
+		return data, status.Error(codes.Unavailable, grpcLocalizer(ctx).Message("stations.unavailable", nil))
+	}
+	return data, nil
+}
+
+/*
+ * 	Lists stations with the given status a page at a time, like /stations,
+ * 	/stations/in-service and /stations/not-in-service
+ */
+func (s *stationServer) ListStations(ctx context.Context, req *stationpb.ListStationsRequest) (*stationpb.ListStationsResponse, error) {
+	log.Info("Executing ListStations entrypoint")
+	data, err := grpcSnapshot(ctx, "ListStations")
+	if err != nil {
+		return nil, err
+	}
+	var stations []Station
+	filter := stationFilter{includeTest: req.IncludeTest, includeStale: req.IncludeStale}
+	for _, station := range filter.apply(data.StationBeanList) {
+		if req.Status == stationpb.StatusFilter_STATUS_FILTER_UNSPECIFIED ||
+			req.Status == stationpb.StatusFilter_STATUS_FILTER_IN_SERVICE && station.StatusValue == inServiceStatus ||
+			req.Status == stationpb.StatusFilter_STATUS_FILTER_NOT_IN_SERVICE && station.StatusValue == notInServiceStatus {
+			stations = append(stations, station)
+		}
+	}
+	pageInfo := ""
+	if req.Page > 0 {
+		pageInfo = strconv.Itoa(int(req.Page))
+	}
+	startResults, endResults := getStartAndEndIndices(len(stations), pageInfo)
+
+	response := &stationpb.ListStationsResponse{ExecutionTime: data.ExecutionTime}
+	for _, station := range stations[startResults:endResults] {
+		response.Stations = append(response.Stations, newProtoStation(station))
+	}
+	return response, nil
+}
+
+/*
+ * 	Returns one station by id
+ */
+func (s *stationServer) GetStation(ctx context.Context, req *stationpb.GetStationRequest) (*stationpb.Station, error) {
+	log.Info("Executing GetStation entrypoint")
+	data, err := grpcSnapshot(ctx, "GetStation")
+	if err != nil {
+		return nil, err
+	}
+	station, found := findStation(data.StationBeanList, strconv.Itoa(int(req.Id)))
+	if !found {
+		return nil, status.Error(codes.NotFound, grpcLocalizer(ctx).Message("station.notFound", nil))
+	}
+	return newProtoStation(station), nil
+}
+
+/*
+ * 	Searches station names and addresses like /stations/{searchstring}. No
+ * 	matches is an empty list rather than an error.
+ */
+func (s *stationServer) SearchStations(ctx context.Context, req *stationpb.SearchStationsRequest) (*stationpb.ListStationsResponse, error) {
+	log.WithFields(log.Fields{"searchString": req.Query}).Info("Executing SearchStations entrypoint")
+	data, err := grpcSnapshot(ctx, "SearchStations")
+	if err != nil {
+		return nil, err
+	}
+	response := &stationpb.ListStationsResponse{ExecutionTime: data.ExecutionTime}
+	for _, station := range matchStations(stationFilter{includeTest: req.IncludeTest, includeStale: req.IncludeStale}.apply(data.StationBeanList), req.Query) {
+		response.Stations = append(response.Stations, newProtoStation(station))
+	}
+	return response, nil
+}
+
+/*
+ * 	Decides whether bikes can be returned to a station like
+ * 	/stations/{stationid}/{bikestoreturn}, answering with the same reasons
+ */
+func (s *stationServer) CheckDockable(ctx context.Context, req *stationpb.CheckDockableRequest) (*stationpb.DockableInfo, error) {
+	log.WithFields(log.Fields{"stationid": req.StationId, "bikestoreturn": req.Bikes}).Info("Executing CheckDockable entrypoint")
+	l := grpcLocalizer(ctx)
+	var dockableInfo DockableInfo
+	if req.Bikes <= 0 {
+		dockableInfo = dockableError(ReasonInvalidCount, int(req.Bikes), l)
+	} else {
+		data, err := grpcSnapshot(ctx, "CheckDockable")
+		if err != nil {
+			return nil, err
+		}
+		if station, found := findStation(data.StationBeanList, strconv.Itoa(int(req.StationId))); found {
+			dockableInfo = checkDockable(data.StationBeanList, station, int(req.Bikes), l)
+		} else {
+			dockableInfo = dockableError(ReasonStationUnknown, int(req.Bikes), l)
+		}
+	}
+	return &stationpb.DockableInfo{
+		Dockable:       dockableInfo.Dockable,
+		Message:        dockableInfo.Message,
+		Reason:         string(dockableInfo.Reason),
+		StationStatus:  dockableInfo.StationStatus,
+		AvailableDocks: int32(dockableInfo.AvailableDocks),
+		Requested:      int32(dockableInfo.Requested),
+		Shortfall:      int32(dockableInfo.Shortfall),
+		Warning:        dockableInfo.Warning,
+		Alternatives:   newProtoStationOptions(dockableInfo.Alternatives),
+		Split:          newProtoStationOptions(dockableInfo.Split),
+	}, nil
+}
+
+/*
+ * 	Converts a streamed update into its protobuf message
+ */
+func newProtoStationUpdate(updateType stationpb.StationUpdate_Type, id int64, update StationUpdate) *stationpb.StationUpdate {
+	return &stationpb.StationUpdate{
+		Type:    updateType,
+		EventId: id,
+		Station: &stationpb.Station{
+			Id:             int32(update.ID),
+			StationName:    update.StationName,
+			StatusValue:    update.StatusValue,
+			AvailableBikes: int32(update.AvailableBikes),
+			AvailableDocks: int32(update.AvailableDocks),
+			TotalDocks:     int32(update.TotalDocks),
+			Latitude:       update.Latitude,
+			Longitude:      update.Longitude,
+		},
+		Changed: update.Changed,
+	}
+}
+
+/*
+ * 	Sends the matching stations of the current snapshot, then each change to
+ * 	them, like /stations/stream. Callers that fall too far behind are ended
+ * 	with ResourceExhausted.
+ */
+func (s *stationServer) WatchStations(req *stationpb.WatchStationsRequest, server stationpb.StationService_WatchStationsServer) error {
+	log.Info("Executing WatchStations entrypoint")
+	ctx := server.Context()
+	l := grpcLocalizer(ctx)
+	filter := streamFilter{}
+	if len(req.Ids) > 0 {
+		filter.ids = map[int]bool{}
+		for _, id := range req.Ids {
+			filter.ids[int(id)] = true
+		}
+	}
+	if len(req.Bbox) != 0 {
+		if len(req.Bbox) != 4 {
+			return status.Error(codes.InvalidArgument, l.Message("stream.invalidFilter", nil))
+		}
+		filter.bbox = req.Bbox
+	}
+	if !stream.HasSnapshot() {
+		if _, err := grpcSnapshot(ctx, "WatchStations"); err != nil {
+			return err
+		}
+	}
+
+	subscriber, snapshot, _, snapshotID := stream.Subscribe("")
+	defer stream.Unsubscribe(subscriber)
+	for _, update := range snapshot {
+		if filter.matches(update) {
+			if err := server.Send(newProtoStationUpdate(stationpb.StationUpdate_TYPE_SNAPSHOT, snapshotID, update)); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case events, open := <-subscriber.events:
+			if !open {
+				log.Warn("Disconnecting slow WatchStations client")
+				return status.Error(codes.ResourceExhausted, l.Message("stream.tooSlow", nil))
+			}
+			for _, event := range events {
+				if filter.matches(event.Update) {
+					if err := server.Send(newProtoStationUpdate(stationpb.StationUpdate_TYPE_CHANGE, event.ID, event.Update)); err != nil {
+						return err
+					}
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
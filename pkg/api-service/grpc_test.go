@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ropenta/golang-api/pkg/stationpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+/*
+ * 	Starts StationService on an in-memory listener and returns a client for it
+ */
+func dialTestGRPC(t *testing.T) stationpb.StationServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go server.Serve(listener)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return stationpb.NewStationServiceClient(conn)
+}
+
+/*
+ * 	Serves a GET request through the router and decodes its JSON body into v
+ */
+func getRESTJSON(t *testing.T, path string, v interface{}) {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusOK)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+ * 	Returns whether a protobuf station has the same fields as a REST one
+ */
+func sameStation(message *stationpb.Station, response StationResponse) bool {
+	return int(message.Id) == response.ID && message.StationName == response.StationName &&
+		message.StAddress1 == response.StAddress1 && message.StatusValue == response.StatusValue &&
+		int(message.AvailableBikes) == response.AvailableBikes && int(message.AvailableDocks) == response.AvailableDocks &&
+		int(message.TotalDocks) == response.TotalDocks && message.Latitude == response.Latitude &&
+		message.Longitude == response.Longitude && message.LastCommunicationTime == response.LastCommunicationTime &&
+		message.Stale == response.Stale
+}
+
+const allFields = "?fields=id,stationName,stAddress1,statusValue,availableBikes,availableDocks,totalDocks,latitude,longitude,lastCommunicationTime,stale"
+
+func TestGRPCListStationsMatchesREST(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	client := dialTestGRPC(t)
+	tests := map[stationpb.StatusFilter]string{
+		stationpb.StatusFilter_STATUS_FILTER_UNSPECIFIED:    "/stations",
+		stationpb.StatusFilter_STATUS_FILTER_IN_SERVICE:     "/stations/in-service",
+		stationpb.StatusFilter_STATUS_FILTER_NOT_IN_SERVICE: "/stations/not-in-service",
+	}
+	for filter, path := range tests {
+		response, err := client.ListStations(context.Background(), &stationpb.ListStationsRequest{Status: filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []StationResponse
+		getRESTJSON(t, path+allFields, &expected)
+		if len(response.Stations) != len(expected) || response.ExecutionTime != "2016-01-22 04:32:49 PM" {
+			t.Fatalf("Expected %d stations like %s, but received %v", len(expected), path, response)
+		}
+		for i := range expected {
+			if !sameStation(response.Stations[i], expected[i]) {
+				t.Errorf("Expected %v like %s, but received %v", expected[i], path, response.Stations[i])
+			}
+		}
+	}
+
+	stale, err := client.ListStations(context.Background(), &stationpb.ListStationsRequest{Status: stationpb.StatusFilter_STATUS_FILTER_NOT_IN_SERVICE, IncludeStale: true})
+	var expectedStale []StationResponse
+	getRESTJSON(t, "/stations/not-in-service"+allFields+"&include_stale=true", &expectedStale)
+	if err != nil || len(stale.Stations) != 1 || len(expectedStale) != 1 || !stale.Stations[0].Stale || !sameStation(stale.Stations[0], expectedStale[0]) {
+		t.Errorf("Expected stale station 423 like /stations/not-in-service?include_stale=true, but received %v %v", stale, err)
+	}
+
+	response, err := client.ListStations(context.Background(), &stationpb.ListStationsRequest{Page: 2})
+	var expected []StationResponse
+	getRESTJSON(t, "/stations"+allFields+"&page=2", &expected)
+	if err != nil || len(response.Stations) != len(expected) {
+		t.Errorf("Expected %d stations like /stations?page=2, but received %v %v", len(expected), response, err)
+	}
+}
+
+func TestGRPCGetAndSearchStations(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	client := dialTestGRPC(t)
+
+	station, err := client.GetStation(context.Background(), &stationpb.GetStationRequest{Id: 83})
+	if err != nil || station.StationName != "Atlantic Ave & Fort Greene Pl" || station.AvailableBikes != 40 {
+		t.Errorf("Expected station 83 with 40 bikes, but received %v %v", station, err)
+	}
+	if _, err := client.GetStation(context.Background(), &stationpb.GetStationRequest{Id: 1}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for an unknown station, but received %v", err)
+	}
+
+	response, err := client.SearchStations(context.Background(), &stationpb.SearchStationsRequest{Query: "atlantic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []StationResponse
+	getRESTJSON(t, "/stations/atlantic"+allFields, &expected)
+	if len(response.Stations) != len(expected) || !sameStation(response.Stations[0], expected[0]) {
+		t.Errorf("Expected %v like /stations/atlantic, but received %v", expected, response.Stations)
+	}
+	if response, err := client.SearchStations(context.Background(), &stationpb.SearchStationsRequest{Query: "nowhere"}); err != nil || len(response.Stations) != 0 {
+		t.Errorf("Expected no matches, but received %v %v", response, err)
+	}
+}
+
+func TestGRPCCheckDockableMatchesREST(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	client := dialTestGRPC(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "accept-language", "es")
+
+	info, err := client.CheckDockable(ctx, &stationpb.CheckDockableRequest{StationId: 83, Bikes: 22})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "/stations/83/22", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "es")
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	expected := decodeDockableInfo(t, w)
+	if info.Dockable != expected.Dockable || info.Message != expected.Message || info.Reason != string(expected.Reason) ||
+		int(info.Shortfall) != expected.Shortfall || len(info.Alternatives) != len(expected.Alternatives) {
+		t.Errorf("Expected %v like /stations/83/22, but received %v", expected, info)
+	}
+
+	if info, err := client.CheckDockable(ctx, &stationpb.CheckDockableRequest{StationId: 83, Bikes: -1}); err != nil || info.Reason != string(ReasonInvalidCount) {
+		t.Errorf("Expected an invalid count, but received %v %v", info, err)
+	}
+}
+
+func TestGRPCWatchStations(t *testing.T) {
+	stream = newStationStream()
+	engine := streamDiffs(stream)
+	engine.Publish(fixtureStationData(t))
+	client := dialTestGRPC(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := client.WatchStations(ctx, &stationpb.WatchStationsRequest{Ids: []int32{83, 72}, Bbox: []float64{-74, 40.6, -73.9, 40.7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := watch.Recv()
+	if err != nil || snapshot.Type != stationpb.StationUpdate_TYPE_SNAPSHOT || snapshot.Station.Id != 83 {
+		t.Fatalf("Expected a snapshot of station 83 only, but received %v %v", snapshot, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`, `"availableBikes":7`, `"availableBikes":6`))
+	change, err := watch.Recv()
+	if err != nil || change.Type != stationpb.StationUpdate_TYPE_CHANGE || change.Station.Id != 83 ||
+		change.Station.AvailableBikes != 39 || len(change.Changed) != 1 || change.Changed[0] != "availableBikes" {
+		t.Errorf("Expected a change to station 83's bikes, but received %v %v", change, err)
+	}
+
+	invalid, err := client.WatchStations(ctx, &stationpb.WatchStationsRequest{Bbox: []float64{1, 2, 3}})
+	if err == nil {
+		_, err = invalid.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a bbox of 3 values, but received %v", err)
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	enableTestAPIKeys(t)
+	reader := issueTestKey(t, `{"name":"dashboard","scopes":["read:stations"]}`)
+	client := dialTestGRPC(t)
+
+	if _, err := client.ListStations(context.Background(), &stationpb.ListStationsRequest{}); err != nil {
+		t.Errorf("Expected ListStations to be public, but received %v", err)
+	}
+	if _, err := client.SearchStations(context.Background(), &stationpb.SearchStationsRequest{Query: "atlantic"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without a key, but received %v", err)
+	}
+	invalid := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wrong")
+	if _, err := client.SearchStations(invalid, &stationpb.SearchStationsRequest{Query: "atlantic"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for an unknown key, but received %v", err)
+	}
+	authorized := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+reader.Key)
+	if _, err := client.SearchStations(authorized, &stationpb.SearchStationsRequest{Query: "atlantic"}); err != nil {
+		t.Errorf("Expected the key to be accepted, but received %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	// embedded so executionTime can be parsed on hosts without a zoneinfo database
+	_ "time/tzdata"
+
+	log "github.com/sirupsen/logrus"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	executionTimeLayout = "2006-01-02 03:04:05 PM"
+	// keyframeEvery - a full record is written after this many change records so queries replay little
+	keyframeEvery = 120
+)
+
+var (
+	snapshotsBucket = []byte("snapshots")
+	metaBucket      = []byte("meta")
+	latestKey       = []byte("latest")
+
+	// feedLocation - the timezone the feed's timestamps are written in
+	feedLocation = mustLoadLocation("America/New_York")
+)
+
+// StationState - the per-station values kept for every stored snapshot
+type StationState struct {
+	ID             int    `json:"id"`
+	AvailableBikes int    `json:"availableBikes"`
+	AvailableDocks int    `json:"availableDocks"`
+	TotalDocks     int    `json:"totalDocks"`
+	StatusValue    string `json:"statusValue"`
+}
+
+// snapshotRecord - one stored snapshot, holding only the stations that changed since the previous one
+type snapshotRecord struct {
+	Full    bool           `json:"full,omitempty"`
+	Changed []StationState `json:"changed,omitempty"`
+	Removed []int          `json:"removed,omitempty"`
+}
+
+// latestRecord - the full state of the most recently stored snapshot, used to diff the next one
+type latestRecord struct {
+	Time      int64                `json:"time"`
+	SinceFull int                  `json:"sinceFull"`
+	Stations  map[int]StationState `json:"stations"`
+}
+
+// StationSample - the state of one station at one stored snapshot
+type StationSample struct {
+	Time time.Time
+	StationState
+}
+
+// HistoryStore - persists feed snapshots to a local BoltDB file
+type HistoryStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+/*
+ * 	Loads a timezone, panicking if it is unknown since timestamps cannot be read without it
+ */
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+/*
+ * 	Parses the feed's executionTime, which is local New York time
+ */
+func parseExecutionTime(executionTime string) (time.Time, error) {
+	return time.ParseInLocation(executionTimeLayout, executionTime, feedLocation)
+}
+
+/*
+ * 	Resolves a feed time in the hour repeated when clocks go back, which
+ * 	parseExecutionTime reads as the earlier of the two: when that is not after
+ * 	previous, the same wall clock an hour later is the time that was meant
+ */
+func resolveRepeatedHour(t, previous time.Time) time.Time {
+	if later := t.Add(time.Hour); !t.After(previous) && formatFeedTime(later) == formatFeedTime(t) {
+		return later
+	}
+	return t
+}
+
+/*
+ * 	Writes t as the feed writes its timestamps, or "" when it is unknown
+ */
+func formatFeedTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(feedLocation).Format(executionTimeLayout)
+}
+
+/*
+ * 	Encodes a time as a key that sorts chronologically
+ */
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix()))
+	return key
+}
+
+/*
+ * 	Decodes a key written by timeKey
+ */
+func keyTime(key []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(key)), 0).In(feedLocation)
+}
+
+/*
+ * 	Opens or creates the store at path. Snapshots older than retention are
+ * 	folded into a single baseline by Compact.
+ */
+func OpenHistoryStore(path string, retention time.Duration) (*HistoryStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{snapshotsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &HistoryStore{db: db, retention: retention}, nil
+}
+
+/*
+ * 	Closes the underlying database file
+ */
+func (h *HistoryStore) Close() error {
+	return h.db.Close()
+}
+
+/*
+ * 	Stores a snapshot keyed by its executionTime in Unix seconds, keeping only
+ * 	the stations that differ from the previously stored snapshot. Snapshots that
+ * 	are not newer than the latest stored one are ignored; times in the hour
+ * 	repeated when clocks go back are read as the later hour when that makes
+ * 	them newer.
+ */
+func (h *HistoryStore) Record(data StationData) error {
+	executionTime, err := parseExecutionTime(data.ExecutionTime)
+	if err != nil {
+		return fmt.Errorf("Unable to parse executionTime %q: %s", data.ExecutionTime, err.Error())
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		latest := latestRecord{}
+		if raw := tx.Bucket(metaBucket).Get(latestKey); raw != nil {
+			if err := json.Unmarshal(raw, &latest); err != nil {
+				return err
+			}
+		}
+		if latest.Stations != nil {
+			executionTime = resolveRepeatedHour(executionTime, time.Unix(latest.Time, 0))
+			if executionTime.Unix() <= latest.Time {
+				return nil
+			}
+		}
+
+		current := map[int]StationState{}
+		for _, station := range data.StationBeanList {
+			current[station.ID] = StationState{
+				ID:             station.ID,
+				AvailableBikes: station.AvailableBikes,
+				AvailableDocks: station.AvailableDocks,
+				TotalDocks:     station.TotalDocks,
+				StatusValue:    string(station.StatusValue),
+			}
+		}
+		sinceFull := latest.SinceFull + 1
+		previous := latest.Stations
+		if sinceFull > keyframeEvery {
+			previous = nil
+		}
+		record := diffStates(previous, current)
+		if record.Full {
+			sinceFull = 0
+		}
+
+		recordMarshal, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(snapshotsBucket).Put(timeKey(executionTime), recordMarshal); err != nil {
+			return err
+		}
+		latestMarshal, err := json.Marshal(latestRecord{Time: executionTime.Unix(), SinceFull: sinceFull, Stations: current})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(latestKey, latestMarshal)
+	})
+}
+
+/*
+ * 	Builds the record that turns previous into current. With no previous state
+ * 	the record is a full one holding every station.
+ */
+func diffStates(previous, current map[int]StationState) snapshotRecord {
+	record := snapshotRecord{Full: previous == nil}
+	for id, state := range current {
+		if old, ok := previous[id]; !ok || old != state {
+			record.Changed = append(record.Changed, state)
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			record.Removed = append(record.Removed, id)
+		}
+	}
+	sort.Slice(record.Changed, func(i, j int) bool { return record.Changed[i].ID < record.Changed[j].ID })
+	sort.Ints(record.Removed)
+	return record
+}
+
+/*
+ * 	Applies a stored record to state in place
+ */
+func (record snapshotRecord) apply(state map[int]StationState) {
+	if record.Full {
+		for id := range state {
+			delete(state, id)
+		}
+	}
+	for _, station := range record.Changed {
+		state[station.ID] = station
+	}
+	for _, id := range record.Removed {
+		delete(state, id)
+	}
+}
+
+/*
+ * 	Replays stored snapshots up to and including to, calling visit with the full
+ * 	state after each one. Replay starts from the last full record at or before
+ * 	from, so visit also sees some snapshots before from. Returns the time and
+ * 	state of the last snapshot replayed, or a zero time if there is none.
+ */
+func (h *HistoryStore) replay(from, to time.Time, visit func(t time.Time, state map[int]StationState)) (time.Time, map[int]StationState, error) {
+	state := map[int]StationState{}
+	var last time.Time
+	fromKey, toKey := timeKey(from), timeKey(to)
+	err := h.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(snapshotsBucket).Cursor()
+		k, v := cursor.Seek(fromKey)
+		if k == nil {
+			k, v = cursor.Last()
+		} else if bytes.Compare(k, fromKey) > 0 {
+			k, v = cursor.Prev()
+		}
+		for ; k != nil; k, v = cursor.Prev() {
+			record := snapshotRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.Full {
+				break
+			}
+		}
+		if k == nil {
+			k, v = cursor.First()
+		}
+
+		for ; k != nil && bytes.Compare(k, toKey) <= 0; k, v = cursor.Next() {
+			record := snapshotRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			record.apply(state)
+			last = keyTime(k)
+			if visit != nil {
+				visit(last, state)
+			}
+		}
+		return nil
+	})
+	return last, state, err
+}
+
+/*
+ * 	Returns the time of the most recently recorded snapshot, or a zero time if
+ * 	nothing has been recorded
+ */
+func (h *HistoryStore) Latest() (time.Time, error) {
+	latest := latestRecord{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(metaBucket).Get(latestKey); raw != nil {
+			return json.Unmarshal(raw, &latest)
+		}
+		return nil
+	})
+	if err != nil || latest.Stations == nil {
+		return time.Time{}, err
+	}
+	return time.Unix(latest.Time, 0).In(feedLocation), nil
+}
+
+/*
+ * 	Returns the time and full system state of the last snapshot at or before t,
+ * 	or a zero time if nothing was recorded by then
+ */
+func (h *HistoryStore) StateAt(t time.Time) (time.Time, []StationState, error) {
+	last, state, err := h.replay(t, t, nil)
+	if err != nil || last.IsZero() {
+		return last, nil, err
+	}
+	stations := make([]StationState, 0, len(state))
+	for _, station := range state {
+		stations = append(stations, station)
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].ID < stations[j].ID })
+	return last, stations, nil
+}
+
+/*
+ * 	Returns one sample per stored snapshot between from and to, inclusive, in
+ * 	which the station was present
+ */
+func (h *HistoryStore) Series(stationID int, from, to time.Time) ([]StationSample, error) {
+	var samples []StationSample
+	_, _, err := h.replay(from, to, func(t time.Time, state map[int]StationState) {
+		if station, ok := state[stationID]; ok && !t.Before(from) {
+			samples = append(samples, StationSample{Time: t, StationState: station})
+		}
+	})
+	return samples, err
+}
+
+/*
+ * 	Folds every snapshot older than the retention period into one full baseline
+ * 	record, so later snapshots can still be reconstructed from it
+ */
+func (h *HistoryStore) Compact(now time.Time) (int, error) {
+	cutoff := timeKey(now.Add(-h.retention))
+	removed := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(snapshotsBucket)
+		state := map[int]StationState{}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil && string(k) < string(cutoff); k, v = cursor.Next() {
+			record := snapshotRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			record.apply(state)
+			keys = append(keys, append([]byte{}, k...))
+		}
+		if len(keys) < 2 {
+			return nil
+		}
+
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		baseline := diffStates(nil, state)
+		baselineMarshal, err := json.Marshal(baseline)
+		if err != nil {
+			return err
+		}
+		removed = len(keys) - 1
+		return bucket.Put(keys[len(keys)-1], baselineMarshal)
+	})
+	return removed, err
+}
+
+/*
+ * 	Compacts the store every interval until stop is closed
+ */
+func (h *HistoryStore) RunCompaction(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := h.Compact(time.Now())
+			log.SetFormatter(&log.JSONFormatter{})
+			if err != nil {
+				log.Error("Error compacting station history", err)
+			} else {
+				log.WithFields(log.Fields{"removed": removed}).Info("Compacted station history")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+/*
+ * 	Returns a store listener that records every new snapshot
+ */
+func (h *HistoryStore) Recorder() func(StationData) {
+	return func(data StationData) {
+		if err := h.Record(data); err != nil {
+			log.SetFormatter(&log.JSONFormatter{})
+			log.WithFields(
+				log.Fields{
+					"executionTime": data.ExecutionTime,
+				},
+			).Error("Error recording station snapshot", err)
+		}
+	}
+}
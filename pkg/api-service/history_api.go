@@ -0,0 +1,247 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHistoryWindow = 24 * time.Hour
+	defaultHistoryStep   = time.Hour
+	minHistoryStep       = time.Minute
+	maxHistoryBuckets    = 2000
+)
+
+var (
+	// history - the snapshot store behind the history endpoints, nil when history is disabled
+	history *HistoryStore
+
+	// queryTimeLayouts - accepted time formats; those without an offset are read in feedLocation
+	queryTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+)
+
+// HistoryStats - minimum, maximum and mean of one value across a bucket
+type HistoryStats struct {
+	Min int     `json:"min"`
+	Max int     `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+// HistoryBucket - the downsampled samples of one station over one step
+type HistoryBucket struct {
+	Start          string       `json:"start"`
+	Samples        int          `json:"samples"`
+	AvailableBikes HistoryStats `json:"availableBikes"`
+	AvailableDocks HistoryStats `json:"availableDocks"`
+	StatusValue    string       `json:"statusValue"`
+}
+
+// StationHistory - contains JSON fields needed for endpoint "/stations/:stationid/history"
+type StationHistory struct {
+	ID      int             `json:"id"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Step    string          `json:"step"`
+	Buckets []HistoryBucket `json:"buckets"`
+}
+
+// SystemState - contains JSON fields needed for endpoint "/stations/history/at"
+type SystemState struct {
+	Time     string         `json:"time"`
+	Stations []StationState `json:"stations"`
+}
+
+/*
+ * 	Parses a time query parameter, returning fallback when it is empty. Times
+ * 	without an offset are read in the system's (New York) timezone.
+ */
+func parseQueryTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	var err error
+	for _, layout := range queryTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, feedLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+/*
+ * 	Adds one sample to running stats, where n is the number of samples before it
+ */
+func (stats *HistoryStats) add(value int, n int) {
+	if n == 0 || value < stats.Min {
+		stats.Min = value
+	}
+	if n == 0 || value > stats.Max {
+		stats.Max = value
+	}
+	stats.Avg += (float64(value) - stats.Avg) / float64(n+1)
+}
+
+/*
+ * 	Groups samples into step-sized buckets starting at from. Buckets with no
+ * 	samples are left out and the status is the last one seen in each bucket.
+ */
+func downsample(samples []StationSample, from time.Time, step time.Duration) []HistoryBucket {
+	buckets := []HistoryBucket{}
+	for _, sample := range samples {
+		start := from.Add(sample.Time.Sub(from) / step * step)
+		if len(buckets) == 0 || buckets[len(buckets)-1].Start != start.Format(time.RFC3339) {
+			buckets = append(buckets, HistoryBucket{Start: start.Format(time.RFC3339)})
+		}
+		bucket := &buckets[len(buckets)-1]
+		bucket.AvailableBikes.add(sample.AvailableBikes, bucket.Samples)
+		bucket.AvailableDocks.add(sample.AvailableDocks, bucket.Samples)
+		bucket.StatusValue = sample.StatusValue
+		bucket.Samples++
+	}
+	for i := range buckets {
+		buckets[i].AvailableBikes.Avg = math.Round(buckets[i].AvailableBikes.Avg*100) / 100
+		buckets[i].AvailableDocks.Avg = math.Round(buckets[i].AvailableDocks.Avg*100) / 100
+	}
+	return buckets
+}
+
+/*
+ * 	Writes an error response when history is disabled, returning whether it is enabled
+ */
+func historyEnabled(w http.ResponseWriter, l localizer) bool {
+	if history == nil {
+		http.Error(w, l.Message("history.disabled", nil), http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+ *	Endpoint: /stations/:stationid/history?from=&to=&step=
+ *
+ *	Returns the station's availableBikes, availableDocks and status between from
+ *	and to (default: the last 24 hours), downsampled into step-sized buckets
+ *	(default: 1h) with the min, max and mean of each value. Ranges of more
+ *	than maxHistoryBuckets steps are rejected, whether or not step is given.
+ *	?format=csv or ?format=ndjson exports one bucket per row.
+ */
+func getStationHistory(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing getStationHistory entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"stationid": mux.Vars(req)["stationid"],
+			"Path":      req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !historyEnabled(w, l) {
+		return
+	}
+	format, ok := requestFormat(w, req, contextLogger)
+	if !ok {
+		return
+	}
+
+	query := req.URL.Query()
+	stationID, _ := strconv.Atoi(mux.Vars(req)["stationid"])
+	to, toErr := parseQueryTime(query.Get("to"), time.Now().In(feedLocation))
+	from, fromErr := parseQueryTime(query.Get("from"), to.Add(-defaultHistoryWindow))
+	if toErr != nil || fromErr != nil || from.After(to) {
+		http.Error(w, l.Message("history.invalidTime", nil), http.StatusBadRequest)
+		contextLogger.Warn("Invalid history time range")
+		return
+	}
+	step := defaultHistoryStep
+	if stepInfo := query.Get("step"); stepInfo != "" {
+		var stepErr error
+		step, stepErr = time.ParseDuration(stepInfo)
+		if stepErr != nil || step < minHistoryStep {
+			http.Error(w, l.Message("history.invalidStep", MessageArgs{"max": maxHistoryBuckets}), http.StatusBadRequest)
+			contextLogger.Warn("Invalid history step")
+			return
+		}
+	}
+	if to.Sub(from)/step > maxHistoryBuckets {
+		http.Error(w, l.Message("history.rangeTooLong", MessageArgs{"max": maxHistoryBuckets, "step": step.String()}), http.StatusBadRequest)
+		contextLogger.Warn("History time range too long for its step")
+		return
+	}
+
+	samples, err := history.Series(stationID, from, to)
+	if err != nil {
+		contextLogger.Error("Error reading station history", err)
+		http.Error(w, l.Message("history.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	stationHistory := StationHistory{
+		ID:      stationID,
+		From:    from.In(feedLocation).Format(time.RFC3339),
+		To:      to.In(feedLocation).Format(time.RFC3339),
+		Step:    step.String(),
+		Buckets: downsample(samples, from.In(feedLocation), step),
+	}
+	if format != formatJSON {
+		writeExport(w, format, "station-"+strconv.Itoa(stationID)+"-history", to, bucketRows(stationHistory.Buckets), contextLogger)
+		return
+	}
+	writeJSON(w, req, http.StatusOK, stationHistory, contextLogger)
+}
+
+/*
+ *	Endpoint: /stations/history/at?t=
+ *
+ *	Reconstructs the state of every station at the last snapshot recorded at or
+ *	before t (default: now). ?format=csv or ?format=ndjson exports one station
+ *	per row.
+ */
+func getSystemStateAt(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing getSystemStateAt entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"t":    req.URL.Query().Get("t"),
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !historyEnabled(w, l) {
+		return
+	}
+	format, ok := requestFormat(w, req, contextLogger)
+	if !ok {
+		return
+	}
+
+	at, timeErr := parseQueryTime(req.URL.Query().Get("t"), time.Now())
+	if timeErr != nil {
+		http.Error(w, l.Message("history.invalidTime", nil), http.StatusBadRequest)
+		contextLogger.Warn("Invalid history time")
+		return
+	}
+	snapshotTime, stations, err := history.StateAt(at)
+	if err != nil {
+		contextLogger.Error("Error reading station history", err)
+		http.Error(w, l.Message("history.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	if snapshotTime.IsZero() {
+		http.Error(w, l.Message("history.notFound", nil), http.StatusNotFound)
+		return
+	}
+
+	if format != formatJSON {
+		writeExport(w, format, "stations-history", snapshotTime, stateRows(stations), contextLogger)
+		return
+	}
+	writeJSON(w, req, http.StatusOK, SystemState{Time: snapshotTime.Format(time.RFC3339), Stations: stations}, contextLogger)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+/*
+ * 	Records three snapshots of the fixture feed in which station 72 goes from
+ * 	7 to 9 to 4 bikes, at 4:32, 4:40 and 5:10 PM New York time
+ */
+func recordTestHistory(t *testing.T) {
+	history = openTestHistoryStore(t, 24*time.Hour)
+	t.Cleanup(func() { history = nil })
+	snapshots := []StationData{
+		fixtureStationData(t),
+		fixtureStationData(t, "04:32:49 PM", "04:40:00 PM", `"availableDocks":32,"totalDocks":39`, `"availableDocks":30,"totalDocks":39`, `"availableBikes":7`, `"availableBikes":9`),
+		fixtureStationData(t, "04:32:49 PM", "05:10:00 PM", `"availableDocks":32,"totalDocks":39`, `"availableDocks":35,"totalDocks":39`, `"availableBikes":7`, `"availableBikes":4`),
+	}
+	for _, data := range snapshots {
+		if err := history.Record(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseQueryTime(t *testing.T) {
+	tests := map[string]string{
+		"2016-01-22T16:30:00-05:00": "2016-01-22T21:30:00Z",
+		"2016-01-22T21:30:00Z":      "2016-01-22T21:30:00Z",
+		"2016-01-22T16:30:00":       "2016-01-22T21:30:00Z",
+		"2016-07-01":                "2016-07-01T04:00:00Z",
+	}
+	for value, expected := range tests {
+		parsed, err := parseQueryTime(value, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if utc := parsed.UTC().Format(time.RFC3339); utc != expected {
+			t.Errorf("Expected %s for %s, but received %s", expected, value, utc)
+		}
+	}
+	if _, err := parseQueryTime("yesterday", time.Time{}); err == nil {
+		t.Errorf("Expected an error for an invalid time")
+	}
+}
+
+func TestGetStationHistory(t *testing.T) {
+	recordTestHistory(t)
+	req, err := http.NewRequest("GET", "/stations/72/history?from=2016-01-22T16:00:00&to=2016-01-22T18:00:00&step=1h&pretty=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `{
+    "id": 72,
+    "from": "2016-01-22T16:00:00-05:00",
+    "to": "2016-01-22T18:00:00-05:00",
+    "step": "1h0m0s",
+    "buckets": [
+        {
+            "start": "2016-01-22T16:00:00-05:00",
+            "samples": 2,
+            "availableBikes": {
+                "min": 7,
+                "max": 9,
+                "avg": 8
+            },
+            "availableDocks": {
+                "min": 30,
+                "max": 32,
+                "avg": 31
+            },
+            "statusValue": "In Service"
+        },
+        {
+            "start": "2016-01-22T17:00:00-05:00",
+            "samples": 1,
+            "availableBikes": {
+                "min": 4,
+                "max": 4,
+                "avg": 4
+            },
+            "availableDocks": {
+                "min": 35,
+                "max": 35,
+                "avg": 35
+            },
+            "statusValue": "In Service"
+        }
+    ]
+}`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestGetStationHistoryInvalidParameters(t *testing.T) {
+	recordTestHistory(t)
+	for _, query := range []string{"?from=yesterday", "?step=1s", "?from=2016-01-22&to=2016-01-21", "?from=2016-01-01&to=2016-03-01&step=1m", "?from=2010-01-01&to=2016-01-22"} {
+		req, err := http.NewRequest("GET", "/stations/72/history"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+		if status := w.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v but wanted %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetSystemStateAt(t *testing.T) {
+	recordTestHistory(t)
+	req, err := http.NewRequest("GET", "/stations/history/at?t=2016-01-22T17:00:00-05:00", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	_, stations, err := history.StateAt(time.Date(2016, 1, 22, 22, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 6 || stations[0].ID != 72 || stations[0].AvailableBikes != 9 {
+		t.Errorf("Expected 6 stations with 9 bikes at station 72, but received %+v", stations)
+	}
+
+	req, err = http.NewRequest("GET", "/stations/history/at?t=2016-01-22T16:00:00-05:00", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusNotFound)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	req, err := http.NewRequest("GET", "/stations/history/at", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusServiceUnavailable)
+	}
+}
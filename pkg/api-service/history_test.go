@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+ * 	Opens a history store in a temporary directory that is removed after the test
+ */
+func openTestHistoryStore(t *testing.T, retention time.Duration) *HistoryStore {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := OpenHistoryStore(filepath.Join(dir, "history.db"), retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		history.Close()
+		os.RemoveAll(dir)
+	})
+	return history
+}
+
+/*
+ * 	Parses the fixture feed, replacing old with new in the raw JSON first
+ */
+func fixtureStationData(t *testing.T, replacements ...string) StationData {
+	stationData := StationData{}
+	raw := strings.NewReplacer(replacements...).Replace(allStationsJSON)
+	if err := json.Unmarshal([]byte(raw), &stationData); err != nil {
+		t.Fatal(err)
+	}
+	return stationData
+}
+
+/*
+ * 	Returns every stored record in key order
+ */
+func storedRecords(t *testing.T, history *HistoryStore) []snapshotRecord {
+	var records []snapshotRecord
+	err := history.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotsBucket).ForEach(func(k, v []byte) error {
+			record := snapshotRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestParseExecutionTime(t *testing.T) {
+	executionTime, err := parseExecutionTime("2016-01-22 04:32:49 PM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utc := executionTime.UTC().Format(time.RFC3339); utc != "2016-01-22T21:32:49Z" {
+		t.Errorf("Expected %s, but received %s", "2016-01-22T21:32:49Z", utc)
+	}
+}
+
+func TestHistoryStoreRecordsOnlyChanges(t *testing.T) {
+	history := openTestHistoryStore(t, time.Hour)
+	first := fixtureStationData(t)
+	second := fixtureStationData(t,
+		"2016-01-22 04:32:49 PM", "2016-01-22 04:35:00 PM",
+		`"availableDocks":21,"totalDocks":62`, `"availableDocks":20,"totalDocks":62`,
+	)
+	for _, data := range []StationData{first, second, first} {
+		if err := history.Record(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := storedRecords(t, history)
+	if len(records) != 2 {
+		t.Fatalf("Expected %d records, but received %d", 2, len(records))
+	}
+	if !records[0].Full || len(records[0].Changed) != 6 {
+		t.Errorf("Expected the first record to hold all %d stations, but received %+v", 6, records[0])
+	}
+	if records[1].Full || len(records[1].Changed) != 1 || records[1].Changed[0].ID != 83 || records[1].Changed[0].AvailableDocks != 20 {
+		t.Errorf("Expected the second record to hold only station 83, but received %+v", records[1])
+	}
+}
+
+func TestHistoryStoreRecordsRepeatedHour(t *testing.T) {
+	history := openTestHistoryStore(t, time.Hour)
+	// New York clocks went back from 2:00 AM EDT to 1:00 AM EST on 2016-11-06
+	for i, executionTime := range []string{"2016-11-06 01:30:00 AM", "2016-11-06 01:45:00 AM", "2016-11-06 01:15:00 AM", "2016-11-06 01:30:00 AM"} {
+		data := fixtureStationData(t, "2016-01-22 04:32:49 PM", executionTime)
+		data.StationBeanList[0].AvailableBikes = i
+		if err := history.Record(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records := storedRecords(t, history); len(records) != 4 {
+		t.Errorf("Expected every snapshot across the repeated hour to be recorded, but received %d records", len(records))
+	}
+	latest, err := history.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utc := latest.UTC().Format(time.RFC3339); utc != "2016-11-06T06:30:00Z" {
+		t.Errorf("Expected the last snapshot at %s, but received %s", "2016-11-06T06:30:00Z", utc)
+	}
+}
+
+func TestHistoryStoreCompact(t *testing.T) {
+	history := openTestHistoryStore(t, time.Hour)
+	snapshots := []StationData{
+		fixtureStationData(t),
+		fixtureStationData(t, "04:32:49 PM", "04:40:00 PM", `"availableBikes":7`, `"availableBikes":8`),
+		fixtureStationData(t, "04:32:49 PM", "05:50:00 PM", `"availableBikes":7`, `"availableBikes":9`),
+	}
+	for _, data := range snapshots {
+		if err := history.Record(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now, _ := parseExecutionTime("2016-01-22 06:00:00 PM")
+	removed, err := history.Compact(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected %d compacted record, but received %d", 1, removed)
+	}
+	records := storedRecords(t, history)
+	if len(records) != 2 || !records[0].Full || records[1].Full {
+		t.Fatalf("Expected a full baseline followed by one change, but received %+v", records)
+	}
+	for _, station := range records[0].Changed {
+		if station.ID == 72 && station.AvailableBikes != 8 {
+			t.Errorf("Expected the baseline to hold %d bikes at station 72, but received %d", 8, station.AvailableBikes)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLanguage = "en"
+)
+
+// MessageArgs - named values substituted into a catalogue message
+type MessageArgs map[string]interface{}
+
+// localizer - formats catalogue messages in a single language
+type localizer struct {
+	lang string
+}
+
+/*
+ * 	Message catalogue keyed by language and then message id. Messages use the
+ * 	ICU MessageFormat subset understood by formatMessage: {name} arguments,
+ * 	{name, plural, =0 {...} one {...} other {...}} with # for the count, and
+ * 	apostrophes to quote literal braces.
+ */
+var messageCatalogue = map[string]map[string]string{
+	"en": {
+		"dockable.ok":                   "You are able to return {bikes, plural, one {your # bike} other {all # of your bikes}}. There {docks, plural, one {is # available dock} other {are # available docks}}.",
+		"dockable.insufficient":         "You cannot return {bikes, plural, one {your # bike} other {all # of your bikes}}. There {docks, plural, one {is # available dock} other {are # available docks}}.",
+		"dockable.stale":                "This station last reported at {time}, so its numbers may be out of date.",
+		"dockable.invalidCount":         "Invalid value for number of bikes to return. Please enter a valid number.",
+		"dockable.forecastOk":           "You should be able to return {bikes, plural, one {your # bike} other {all # of your bikes}}. About {docks, plural, one {# dock is} other {# docks are}} expected to be available at {time}.",
+		"dockable.forecastInsufficient": "You may not be able to return {bikes, plural, one {your # bike} other {all # of your bikes}}. About {docks, plural, one {# dock is} other {# docks are}} expected to be available at {time}.",
+		"forecast.invalidTime":          "Invalid time. Please use RFC 3339, for example 2016-01-22T16:30:00-05:00, no more than 7 days ahead.",
+		"rent.ok":                       "You are able to rent {bikes, plural, one {# bike} other {all # bikes}}. There {available, plural, one {is # available bike} other {are # available bikes}}.",
+		"rent.insufficient":             "You cannot rent {bikes, plural, one {# bike} other {all # bikes}}. There {available, plural, one {is # available bike} other {are # available bikes}}.",
+		"rent.invalidCount":             "Invalid value for number of bikes to rent. Please enter a valid number.",
+		"station.notInService":          "Station {name} with ID {id} is Not In Service. Please choose an In Service station.",
+		"station.statusUnknown":         "Station {name} with ID {id} reports a status this service does not recognize. Please choose an In Service station.",
+		"station.notFound":              "Station not found. Please enter a valid station id.",
+		"stations.unavailable":          "Unable to retrieve stations. Please try again later.",
+		"search.noResults":              "No results found. Please try another search.",
+		"fields.unknown":                "Unknown fields: {fields}. Available fields are {available}.",
+		"format.unsupported":            "The format {format} is not supported. Use json, csv or ndjson.",
+		"validation.failed":             "The request has {count, plural, one {# invalid parameter} other {# invalid parameters}}.",
+		"validation.required":           "The parameter {name} is required.",
+		"validation.integer":            "The parameter {name} must be an integer.",
+		"validation.number":             "The parameter {name} must be a number.",
+		"validation.boolean":            "The parameter {name} must be true or false.",
+		"validation.minimum":            "The parameter {name} must be at least {min}.",
+		"validation.maximum":            "The parameter {name} must be at most {max}.",
+		"validation.maxLength":          "The parameter {name} must be at most {max} characters long.",
+		"validation.enum":               "The parameter {name} must be one of {values}.",
+		"graphql.invalidRequest":        "Send a GraphQL query in ?query= or as a JSON body with a query field.",
+		"graphql.tooDeep":               "The query nests fields {depth} levels deep, but at most {max} are allowed.",
+		"graphql.tooComplex":            "The query could resolve {complexity} fields, but at most {max} are allowed.",
+		"graphql.invalidLimit":          "limit must not be negative.",
+		"bulk.invalidBody":              "Invalid request body. Please send a JSON list of '{stationId, bikes}' objects.",
+		"bulk.invalidSize":              "Please send between 1 and {max} stations to check.",
+		"bulk.tooLarge":                 "The request body is too large. Please send at most {max} stations to check.",
+		"history.disabled":              "Station history is not enabled on this server.",
+		"history.unavailable":           "Unable to read station history. Please try again later.",
+		"history.notFound":              "No station history was recorded at or before that time.",
+		"history.invalidTime":           "Invalid time. Please use RFC 3339, for example 2016-01-22T16:30:00-05:00, with from before to.",
+		"history.invalidStep":           "Invalid step. Please use a duration such as 15m or 1h, at least 1m and with at most {max} buckets.",
+		"history.rangeTooLong":          "The time range is too long. Please ask for at most {max} steps of {step}, or use a larger step.",
+		"stream.invalidFilter":          "Invalid filter. Please use ids=72,83 and/or bbox=minLongitude,minLatitude,maxLongitude,maxLatitude.",
+		"stream.unsupported":            "Streaming is not supported by this connection.",
+		"stream.tooSlow":                "Disconnected because updates were not read quickly enough.",
+		"ws.invalidMessage":             "Invalid message. Please send the action \"subscribe\" or \"unsubscribe\" with ids, areas of [minLongitude, minLatitude, maxLongitude, maxLatitude] and/or outOfService.",
+		"subscriptions.disabled":        "Webhook subscriptions are not enabled on this server.",
+		"subscriptions.invalid":         "Invalid subscription: {error}.",
+		"subscriptions.notFound":        "No subscription has that id.",
+		"subscriptions.unavailable":     "Unable to store subscriptions. Please try again later.",
+		"auth.missing":                  "An API key is required. Please send it in the X-API-Key header or as a bearer token.",
+		"auth.invalid":                  "The API key is not valid or has been revoked.",
+		"auth.invalidToken":             "The bearer token is not valid, has expired or was not issued for this API.",
+		"auth.forbidden":                "The API key does not have the {scope} scope.",
+		"auth.adminDisabled":            "Admin routes are disabled because no API keys or JWT issuer are configured on this server.",
+		"auth.quotaExceeded":            "The API key has used its daily quota of {quota, plural, one {# request} other {# requests}}.",
+		"keys.disabled":                 "API keys are not enabled on this server.",
+		"keys.invalid":                  "Invalid API key request: {error}.",
+		"keys.notFound":                 "No active API key has that id.",
+		"keys.unavailable":              "Unable to store API keys. Please try again later.",
+		"rateLimit.exceeded":            "Too many requests. Please try again in {seconds, plural, one {# second} other {# seconds}}.",
+		"cors.originForbidden":          "Cross-origin requests from {origin} are not allowed.",
+		"cors.preflightRejected":        "Cross-origin {method} requests with these headers are not allowed for this resource.",
+		"cors.notFound":                 "No resource matches this path.",
+	},
+	"es": {
+		"dockable.ok":                   "Puedes devolver {bikes, plural, one {tu bicicleta} other {tus # bicicletas}}. Hay {docks, plural, one {# anclaje disponible} other {# anclajes disponibles}}.",
+		"dockable.insufficient":         "No puedes devolver {bikes, plural, one {tu bicicleta} other {tus # bicicletas}}. Hay {docks, plural, one {# anclaje disponible} other {# anclajes disponibles}}.",
+		"dockable.stale":                "Esta estación informó por última vez a las {time}, así que sus datos pueden estar desactualizados.",
+		"dockable.invalidCount":         "Número de bicicletas a devolver no válido. Introduce un número válido.",
+		"dockable.forecastOk":           "Deberías poder devolver {bikes, plural, one {tu bicicleta} other {tus # bicicletas}}. Se esperan unos {docks, plural, one {# anclaje disponible} other {# anclajes disponibles}} a las {time}.",
+		"dockable.forecastInsufficient": "Puede que no puedas devolver {bikes, plural, one {tu bicicleta} other {tus # bicicletas}}. Se esperan unos {docks, plural, one {# anclaje disponible} other {# anclajes disponibles}} a las {time}.",
+		"forecast.invalidTime":          "Hora no válida. Usa RFC 3339, por ejemplo 2016-01-22T16:30:00-05:00, con un máximo de 7 días de antelación.",
+		"rent.ok":                       "Puedes alquilar {bikes, plural, one {# bicicleta} other {las # bicicletas}}. Hay {available, plural, one {# bicicleta disponible} other {# bicicletas disponibles}}.",
+		"rent.insufficient":             "No puedes alquilar {bikes, plural, one {# bicicleta} other {las # bicicletas}}. Hay {available, plural, one {# bicicleta disponible} other {# bicicletas disponibles}}.",
+		"rent.invalidCount":             "Número de bicicletas a alquilar no válido. Introduce un número válido.",
+		"station.notInService":          "La estación {name} con ID {id} está fuera de servicio. Elige una estación en servicio.",
+		"station.statusUnknown":         "La estación {name} con ID {id} indica un estado desconocido. Elige una estación en servicio.",
+		"station.notFound":              "Estación no encontrada. Introduce un ID de estación válido.",
+		"stations.unavailable":          "No se pudieron obtener las estaciones. Inténtalo de nuevo más tarde.",
+		"search.noResults":              "No se encontraron resultados. Prueba otra búsqueda.",
+		"fields.unknown":                "Campos desconocidos: {fields}. Los campos disponibles son {available}.",
+		"format.unsupported":            "El formato {format} no es compatible. Usa json, csv o ndjson.",
+		"validation.failed":             "La solicitud tiene {count, plural, one {# parámetro no válido} other {# parámetros no válidos}}.",
+		"validation.required":           "El parámetro {name} es obligatorio.",
+		"validation.integer":            "El parámetro {name} debe ser un número entero.",
+		"validation.number":             "El parámetro {name} debe ser un número.",
+		"validation.boolean":            "El parámetro {name} debe ser true o false.",
+		"validation.minimum":            "El parámetro {name} debe ser como mínimo {min}.",
+		"validation.maximum":            "El parámetro {name} debe ser como máximo {max}.",
+		"validation.maxLength":          "El parámetro {name} debe tener como máximo {max} caracteres.",
+		"validation.enum":               "El parámetro {name} debe ser uno de {values}.",
+		"graphql.invalidRequest":        "Envía una consulta GraphQL en ?query= o como cuerpo JSON con un campo query.",
+		"graphql.tooDeep":               "La consulta anida campos a {depth} niveles de profundidad, pero se permiten como máximo {max}.",
+		"graphql.tooComplex":            "La consulta podría resolver {complexity} campos, pero se permiten como máximo {max}.",
+		"graphql.invalidLimit":          "limit no puede ser negativo.",
+		"bulk.invalidBody":              "Cuerpo de la solicitud no válido. Envía una lista JSON de objetos '{stationId, bikes}'.",
+		"bulk.invalidSize":              "Envía entre 1 y {max} estaciones para comprobar.",
+		"bulk.tooLarge":                 "El cuerpo de la solicitud es demasiado grande. Envía como máximo {max} estaciones para comprobar.",
+		"history.disabled":              "El historial de estaciones no está activado en este servidor.",
+		"history.unavailable":           "No se pudo leer el historial de estaciones. Inténtalo de nuevo más tarde.",
+		"history.notFound":              "No hay historial de estaciones registrado en ese momento o antes.",
+		"history.invalidTime":           "Hora no válida. Usa RFC 3339, por ejemplo 2016-01-22T16:30:00-05:00, con from anterior a to.",
+		"history.invalidStep":           "Intervalo no válido. Usa una duración como 15m o 1h, de al menos 1m y con {max} grupos como máximo.",
+		"history.rangeTooLong":          "El rango de tiempo es demasiado largo. Pide como máximo {max} intervalos de {step} o usa un intervalo mayor.",
+		"stream.invalidFilter":          "Filtro no válido. Usa ids=72,83 y/o bbox=longitudMínima,latitudMínima,longitudMáxima,latitudMáxima.",
+		"stream.unsupported":            "Esta conexión no admite transmisión en directo.",
+		"stream.tooSlow":                "Desconectado porque las actualizaciones no se leían con suficiente rapidez.",
+		"ws.invalidMessage":             "Mensaje no válido. Envía la acción \"subscribe\" o \"unsubscribe\" con ids, areas de [longitudMínima, latitudMínima, longitudMáxima, latitudMáxima] y/o outOfService.",
+		"subscriptions.disabled":        "Las suscripciones de webhooks no están habilitadas en este servidor.",
+		"subscriptions.invalid":         "Suscripción no válida: {error}.",
+		"subscriptions.notFound":        "No hay ninguna suscripción con ese id.",
+		"subscriptions.unavailable":     "No se pueden guardar las suscripciones. Inténtalo de nuevo más tarde.",
+		"auth.missing":                  "Se necesita una clave de API. Envíala en la cabecera X-API-Key o como token bearer.",
+		"auth.invalid":                  "La clave de API no es válida o ha sido revocada.",
+		"auth.invalidToken":             "El token bearer no es válido, ha caducado o no se emitió para esta API.",
+		"auth.forbidden":                "La clave de API no tiene el permiso {scope}.",
+		"auth.adminDisabled":            "Las rutas de administración están desactivadas porque este servidor no tiene claves de API ni emisor de JWT configurados.",
+		"auth.quotaExceeded":            "La clave de API ha agotado su cuota diaria de {quota, plural, one {# solicitud} other {# solicitudes}}.",
+		"keys.disabled":                 "Las claves de API no están habilitadas en este servidor.",
+		"keys.invalid":                  "Solicitud de clave de API no válida: {error}.",
+		"keys.notFound":                 "No hay ninguna clave de API activa con ese id.",
+		"keys.unavailable":              "No se pueden guardar las claves de API. Inténtalo de nuevo más tarde.",
+		"rateLimit.exceeded":            "Demasiadas solicitudes. Inténtalo de nuevo en {seconds, plural, one {# segundo} other {# segundos}}.",
+		"cors.originForbidden":          "No se permiten solicitudes de origen cruzado desde {origin}.",
+		"cors.preflightRejected":        "No se permiten solicitudes {method} de origen cruzado con estas cabeceras para este recurso.",
+		"cors.notFound":                 "Ningún recurso coincide con esta ruta.",
+	},
+	"zh": {
+		"dockable.ok":                   "您可以归还全部 {bikes} 辆自行车。目前有 {docks} 个空闲车桩。",
+		"dockable.insufficient":         "您无法归还全部 {bikes} 辆自行车。目前只有 {docks} 个空闲车桩。",
+		"dockable.stale":                "该站点最后一次上报是在 {time}，数据可能已过时。",
+		"dockable.invalidCount":         "归还自行车数量无效。请输入有效数字。",
+		"dockable.forecastOk":           "您应该可以归还全部 {bikes} 辆自行车。预计 {time} 时约有 {docks} 个空闲车桩。",
+		"dockable.forecastInsufficient": "您可能无法归还全部 {bikes} 辆自行车。预计 {time} 时约有 {docks} 个空闲车桩。",
+		"forecast.invalidTime":          "时间无效。请使用 RFC 3339 格式，例如 2016-01-22T16:30:00-05:00，且不超过未来 7 天。",
+		"rent.ok":                       "您可以租用全部 {bikes} 辆自行车。目前有 {available} 辆可用自行车。",
+		"rent.insufficient":             "您无法租用全部 {bikes} 辆自行车。目前只有 {available} 辆可用自行车。",
+		"rent.invalidCount":             "租用自行车数量无效。请输入有效数字。",
+		"station.notInService":          "站点 {name}（ID {id}）暂停服务。请选择正在服务的站点。",
+		"station.statusUnknown":         "站点 {name}（ID {id}）的状态无法识别。请选择正在服务的站点。",
+		"station.notFound":              "未找到站点。请输入有效的站点 ID。",
+		"stations.unavailable":          "暂时无法获取站点信息。请稍后再试。",
+		"search.noResults":              "未找到结果。请尝试其他搜索。",
+		"fields.unknown":                "未知字段：{fields}。可用字段为 {available}。",
+		"format.unsupported":            "不支持格式 {format}。请使用 json、csv 或 ndjson。",
+		"validation.failed":             "请求包含 {count} 个无效参数。",
+		"validation.required":           "参数 {name} 是必需的。",
+		"validation.integer":            "参数 {name} 必须是整数。",
+		"validation.number":             "参数 {name} 必须是数字。",
+		"validation.boolean":            "参数 {name} 必须是 true 或 false。",
+		"validation.minimum":            "参数 {name} 不能小于 {min}。",
+		"validation.maximum":            "参数 {name} 不能大于 {max}。",
+		"validation.maxLength":          "参数 {name} 最多只能有 {max} 个字符。",
+		"validation.enum":               "参数 {name} 必须是以下之一：{values}。",
+		"graphql.invalidRequest":        "请在 ?query= 中或以包含 query 字段的 JSON 请求体发送 GraphQL 查询。",
+		"graphql.tooDeep":               "查询的字段嵌套了 {depth} 层，但最多允许 {max} 层。",
+		"graphql.tooComplex":            "查询可能解析 {complexity} 个字段，但最多允许 {max} 个。",
+		"graphql.invalidLimit":          "limit 不能为负数。",
+		"bulk.invalidBody":              "请求内容无效。请发送由 '{stationId, bikes}' 对象组成的 JSON 列表。",
+		"bulk.invalidSize":              "请提交 1 到 {max} 个需要检查的站点。",
+		"bulk.tooLarge":                 "请求内容过大。请最多提交 {max} 个需要检查的站点。",
+		"history.disabled":              "此服务器未启用站点历史记录。",
+		"history.unavailable":           "暂时无法读取站点历史记录。请稍后再试。",
+		"history.notFound":              "该时间及之前没有站点历史记录。",
+		"history.invalidTime":           "时间无效。请使用 RFC 3339 格式，例如 2016-01-22T16:30:00-05:00，且 from 早于 to。",
+		"history.invalidStep":           "步长无效。请使用 15m 或 1h 等时长，至少 1m，且最多 {max} 个分组。",
+		"history.rangeTooLong":          "时间范围过长。请最多请求 {max} 个 {step} 的步长，或使用更大的步长。",
+		"stream.invalidFilter":          "筛选条件无效。请使用 ids=72,83 和/或 bbox=最小经度,最小纬度,最大经度,最大纬度。",
+		"stream.unsupported":            "此连接不支持实时推送。",
+		"stream.tooSlow":                "由于未能及时读取更新，连接已断开。",
+		"ws.invalidMessage":             "消息无效。请发送操作 \"subscribe\" 或 \"unsubscribe\"，并附带 ids、areas（[最小经度, 最小纬度, 最大经度, 最大纬度]）和/或 outOfService。",
+		"subscriptions.disabled":        "此服务器未启用 Webhook 订阅。",
+		"subscriptions.invalid":         "订阅无效：{error}。",
+		"subscriptions.notFound":        "没有该 id 的订阅。",
+		"subscriptions.unavailable":     "无法保存订阅，请稍后再试。",
+		"auth.missing":                  "需要 API 密钥。请通过 X-API-Key 请求头或 Bearer 令牌发送。",
+		"auth.invalid":                  "API 密钥无效或已被吊销。",
+		"auth.invalidToken":             "Bearer 令牌无效、已过期或并非为此 API 签发。",
+		"auth.forbidden":                "该 API 密钥没有 {scope} 权限。",
+		"auth.adminDisabled":            "此服务器未配置 API 密钥或 JWT 签发者，因此管理路由已禁用。",
+		"auth.quotaExceeded":            "该 API 密钥已用完每日 {quota} 次请求的配额。",
+		"keys.disabled":                 "此服务器未启用 API 密钥。",
+		"keys.invalid":                  "API 密钥请求无效：{error}。",
+		"keys.notFound":                 "没有该 id 的有效 API 密钥。",
+		"keys.unavailable":              "无法保存 API 密钥，请稍后再试。",
+		"rateLimit.exceeded":            "请求过多，请在 {seconds} 秒后重试。",
+		"cors.originForbidden":          "不允许来自 {origin} 的跨域请求。",
+		"cors.preflightRejected":        "此资源不允许带有这些请求头的跨域 {method} 请求。",
+		"cors.notFound":                 "没有与此路径匹配的资源。",
+	},
+}
+
+/*
+ * 	Picks the best supported language from the request's Accept-Language header,
+ * 	honouring q-values and falling back to English
+ */
+func newLocalizer(req *http.Request) localizer {
+	return localizerFor(req.Header.Get("Accept-Language"))
+}
+
+/*
+ * 	Picks the best supported language from an Accept-Language value
+ */
+func localizerFor(acceptLanguage string) localizer {
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.SplitN(strings.TrimSpace(fields[0]), "-", 2)[0])
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if _, supported := messageCatalogue[lang]; supported && quality > 0 {
+			candidates = append(candidates, candidate{lang: lang, quality: quality})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	if len(candidates) == 0 {
+		return localizer{lang: defaultLanguage}
+	}
+	return localizer{lang: candidates[0].lang}
+}
+
+/*
+ * 	Formats message id in the localizer's language, falling back to English
+ * 	when the language has no translation for it
+ */
+func (l localizer) Message(id string, args MessageArgs) string {
+	pattern, ok := messageCatalogue[l.lang][id]
+	lang := l.lang
+	if !ok {
+		pattern = messageCatalogue[defaultLanguage][id]
+		lang = defaultLanguage
+	}
+	return formatMessage(lang, pattern, args, "")
+}
+
+/*
+ * 	Sets the Content-Language header to the language messages are written in
+ */
+func (l localizer) SetHeader(w http.ResponseWriter) {
+	w.Header().Set("Content-Language", l.lang)
+}
+
+/*
+ * 	Returns the CLDR plural category of n. English and Spanish distinguish one
+ * 	from other; Chinese has no plural forms.
+ */
+func pluralCategory(lang string, n int) string {
+	if lang != "zh" && n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+/*
+ * 	Expands a message pattern. hash is the count substituted for # inside a
+ * 	plural branch, or empty outside one.
+ */
+func formatMessage(lang, pattern string, args MessageArgs, hash string) string {
+	var out strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\'':
+			if i+1 < len(pattern) && pattern[i+1] == '\'' {
+				out.WriteByte('\'')
+				i++
+			} else if i+1 < len(pattern) && strings.IndexByte("{}#", pattern[i+1]) >= 0 {
+				end := strings.IndexByte(pattern[i+1:], '\'')
+				if end < 0 {
+					end = len(pattern) - i - 1
+				}
+				out.WriteString(pattern[i+1 : i+1+end])
+				i += end + 1
+			} else {
+				out.WriteByte(c)
+			}
+		case c == '#' && hash != "":
+			out.WriteString(hash)
+		case c == '{':
+			end := matchingBrace(pattern, i)
+			out.WriteString(formatArgument(lang, pattern[i+1:end], args))
+			i = end
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+/*
+ * 	Returns the index of the brace closing the one at start
+ */
+func matchingBrace(pattern string, start int) int {
+	depth := 0
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(pattern)
+}
+
+/*
+ * 	Expands the inside of a {name} or {name, plural, ...} argument
+ */
+func formatArgument(lang, argument string, args MessageArgs) string {
+	parts := strings.SplitN(argument, ",", 3)
+	name := strings.TrimSpace(parts[0])
+	value := args[name]
+	if len(parts) < 3 || strings.TrimSpace(parts[1]) != "plural" {
+		return toString(value)
+	}
+
+	n, _ := value.(int)
+	branches := map[string]string{}
+	rest := parts[2]
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := matchingBrace(rest, open)
+		branches[strings.TrimSpace(rest[:open])] = rest[open+1 : min(end, len(rest))]
+		if end >= len(rest) {
+			break
+		}
+		rest = rest[end+1:]
+	}
+
+	branch, ok := branches["="+strconv.Itoa(n)]
+	if !ok {
+		branch, ok = branches[pluralCategory(lang, n)]
+	}
+	if !ok {
+		branch = branches["other"]
+	}
+	return formatMessage(lang, branch, args, strconv.Itoa(n))
+}
+
+/*
+ * 	Renders a message argument value as text
+ */
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewLocalizer(t *testing.T) {
+	tests := map[string]string{
+		"":                              "en",
+		"es":                            "es",
+		"zh-CN,zh;q=0.9":                "zh",
+		"fr-FR, es-419;q=0.5, en;q=0.8": "en",
+		"fr, es;q=0.4":                  "es",
+		"es;q=0, zh;q=0.1":              "zh",
+		"de":                            "en",
+	}
+	for header, lang := range tests {
+		req, err := http.NewRequest("GET", "/stations", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", header)
+		if l := newLocalizer(req); l.lang != lang {
+			t.Errorf("Expected language %s for %q, but received %s", lang, header, l.lang)
+		}
+	}
+}
+
+func TestLocalizerMessagePlurals(t *testing.T) {
+	tests := []struct {
+		lang     string
+		bikes    int
+		docks    int
+		expected string
+	}{
+		{"en", 1, 1, "You are able to return your 1 bike. There is 1 available dock."},
+		{"en", 2, 3, "You are able to return all 2 of your bikes. There are 3 available docks."},
+		{"es", 1, 2, "Puedes devolver tu bicicleta. Hay 2 anclajes disponibles."},
+		{"es", 3, 1, "Puedes devolver tus 3 bicicletas. Hay 1 anclaje disponible."},
+		{"zh", 1, 1, "您可以归还全部 1 辆自行车。目前有 1 个空闲车桩。"},
+	}
+	for _, test := range tests {
+		message := localizer{lang: test.lang}.Message("dockable.ok", MessageArgs{"bikes": test.bikes, "docks": test.docks})
+		if message != test.expected {
+			t.Errorf("Expected %q, but received %q", test.expected, message)
+		}
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	tests := map[string]string{
+		"{n, plural, =0 {no bikes} one {# bike} other {# bikes}}": "no bikes",
+		"Send '{stationId}' and it''s done":                       "Send {stationId} and it's done",
+		"{name} has {n} bikes":                                    "Pier 40 has 0 bikes",
+	}
+	for pattern, expected := range tests {
+		if message := formatMessage("en", pattern, MessageArgs{"n": 0, "name": "Pier 40"}, ""); message != expected {
+			t.Errorf("Expected %q, but received %q", expected, message)
+		}
+	}
+}
+
+func TestMessageCatalogueComplete(t *testing.T) {
+	for lang, messages := range messageCatalogue {
+		for id := range messageCatalogue[defaultLanguage] {
+			if _, ok := messages[id]; !ok {
+				t.Errorf("Language %s is missing message %s", lang, id)
+			}
+		}
+	}
+}
+
+func TestReturnBikesSpanish(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/83/1?pretty=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "es-US,es;q=0.9,en;q=0.8")
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+
+	if language := w.Header().Get("Content-Language"); language != "es" {
+		t.Errorf("Expected Content-Language %s, but received %s", "es", language)
+	}
+	expected := `{
+    "dockable": true,
+    "message": "Puedes devolver tu bicicleta. Hay 21 anclajes disponibles.",
+    "reason": "OK",
+    "stationStatus": "In Service",
+    "availableDocks": 21,
+    "requested": 1,
+    "shortfall": 0
+}`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
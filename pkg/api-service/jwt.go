@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.SHA256.New
+	_ "crypto/sha512" // registers SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// jwtLeeway - clock skew tolerated when checking exp and nbf
+	jwtLeeway = time.Minute
+
+	// jwksMinRefresh - the shortest time between fetches triggered by unknown key ids
+	jwksMinRefresh = time.Minute
+)
+
+var (
+	// jwtVerifier - checks bearer JWTs; nil when JWT authentication is disabled
+	jwtVerifier *JWTVerifier
+
+	// jwksClient - fetches key sets and OIDC discovery documents
+	jwksClient HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+	// jwtAlgorithms - the accepted signing algorithms and the hash each uses
+	jwtAlgorithms = map[string]crypto.Hash{
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	}
+
+	// jwtCurves - the curve each ECDSA algorithm is defined on
+	jwtCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+)
+
+// ClaimScope - grants Scope to tokens whose Claim is, or contains, Value
+type ClaimScope struct {
+	Claim string
+	Value string
+	Scope string
+}
+
+// JWTConfig - where keys come from and what tokens must contain. Keys are read
+// from JWKSFile, else JWKSURL, else the issuer's OIDC discovery document.
+type JWTConfig struct {
+	Issuer      string
+	Audience    string
+	JWKSURL     string
+	JWKSFile    string
+	CacheTTL    time.Duration
+	ClaimScopes []ClaimScope
+}
+
+// jsonWebKey - one entry of a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey - a parsed public key and the algorithm it is restricted to, if any
+type verificationKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// JWTVerifier - negroni middleware that verifies bearer JWTs against a cached key set
+type JWTVerifier struct {
+	config    JWTConfig
+	mu        sync.Mutex
+	keys      map[string]verificationKey
+	fetchedAt time.Time
+	attempted time.Time
+	now       func() time.Time
+}
+
+/*
+ * 	Creates a verifier and loads its keys. A key set URL that cannot be reached
+ * 	yet is only logged; it is fetched again when a token arrives.
+ */
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	if config.Issuer == "" {
+		return nil, fmt.Errorf("an issuer is required")
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = time.Hour
+	}
+	v := &JWTVerifier{config: config, keys: map[string]verificationKey{}, now: time.Now}
+	if err := v.refresh(); err != nil {
+		if config.JWKSFile != "" {
+			return nil, err
+		}
+		log.Warn("Error fetching JWKS, will retry", err)
+	}
+	return v, nil
+}
+
+/*
+ * 	Parses claim-scope mappings written as claim:value=scope pairs separated by
+ * 	commas, such as groups:bike-ops=admin,groups:bike-map=read:stations
+ */
+func ParseClaimScopes(value string) ([]ClaimScope, error) {
+	var mappings []ClaimScope
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		colon, equals := strings.Index(entry, ":"), strings.Index(entry, "=")
+		if colon <= 0 || equals < colon {
+			return nil, fmt.Errorf("claim scope %q is not claim:value=scope", entry)
+		}
+		mappings = append(mappings, ClaimScope{Claim: entry[:colon], Value: entry[colon+1 : equals], Scope: entry[equals+1:]})
+	}
+	return mappings, nil
+}
+
+/*
+ * 	Decodes unpadded base64url
+ */
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+/*
+ * 	Converts a JWK into a public key
+ */
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("key %q is not on its curve", k.Kid)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+/*
+ * 	GETs url and decodes its JSON body into v
+ */
+func fetchJSON(url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := jwksClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+/*
+ * 	Reads the key set from the configured file, URL or discovery document,
+ * 	skipping keys that are not for signatures or cannot be parsed. A JWKS URL
+ * 	found through discovery is recorded in config.
+ */
+func loadVerificationKeys(config *JWTConfig) (map[string]verificationKey, error) {
+	document := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	var err error
+	switch {
+	case config.JWKSFile != "":
+		var raw []byte
+		if raw, err = ioutil.ReadFile(config.JWKSFile); err == nil {
+			err = json.Unmarshal(raw, &document)
+		}
+	case config.JWKSURL != "":
+		err = fetchJSON(config.JWKSURL, &document)
+	default:
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err = fetchJSON(strings.TrimRight(config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err == nil {
+			config.JWKSURL = discovery.JWKSURI
+			err = fetchJSON(config.JWKSURL, &document)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]verificationKey{}
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Warn("Skipping JWKS key", err)
+			continue
+		}
+		keys[jwk.Kid] = verificationKey{key: key, alg: jwk.Alg}
+	}
+	return keys, nil
+}
+
+/*
+ * 	Loads the key set and swaps it in. The fetch runs without v.mu held, so
+ * 	tokens signed with keys already known are verified while it is in flight.
+ */
+func (v *JWTVerifier) refresh() error {
+	v.mu.Lock()
+	config := v.config
+	v.mu.Unlock()
+
+	keys, err := loadVerificationKeys(&config)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	v.keys, v.fetchedAt, v.config.JWKSURL = keys, v.now(), config.JWKSURL
+	v.mu.Unlock()
+	return nil
+}
+
+/*
+ * 	Returns the key with id kid, refetching the key set when it has expired or
+ * 	does not have kid, at most once per jwksMinRefresh. Other lookups keep
+ * 	using the current keys until the refetch completes.
+ */
+func (v *JWTVerifier) key(kid string) (verificationKey, bool) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	expired := v.now().Sub(v.fetchedAt) > v.config.CacheTTL
+	stale := (!ok || expired) && v.now().Sub(v.attempted) >= jwksMinRefresh
+	if stale {
+		v.attempted = v.now()
+	}
+	v.mu.Unlock()
+	if !stale {
+		return key, ok
+	}
+
+	if err := v.refresh(); err != nil {
+		log.Warn("Error refreshing JWKS", err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok = v.keys[kid]
+	return key, ok
+}
+
+/*
+ * 	Checks signature against signed with key using alg
+ */
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	hash := jwtAlgorithms[alg]
+	digest := hash.New()
+	digest.Write(signed)
+	sum := digest.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return fmt.Errorf("%s cannot be used with an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, sum, signature)
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if jwtCurves[alg] != pub.Curve.Params().Name {
+			return fmt.Errorf("%s cannot be used with a %s key", alg, pub.Curve.Params().Name)
+		}
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid %s signature", alg)
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, sum, r, s) {
+			return fmt.Errorf("invalid %s signature", alg)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key")
+}
+
+/*
+ * 	Returns a string or string-array claim as a list
+ */
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+/*
+ * 	Returns the scopes granted by a token's scope or scp claims and by the
+ * 	configured claim mappings, keeping only scopes this API knows
+ */
+func (v *JWTVerifier) scopes(claims map[string]interface{}) []string {
+	granted := map[string]bool{}
+	for _, claim := range []string{"scope", "scp"} {
+		for _, value := range claimStrings(claims[claim]) {
+			for _, scope := range strings.Fields(value) {
+				granted[scope] = true
+			}
+		}
+	}
+	for _, mapping := range v.config.ClaimScopes {
+		for _, value := range claimStrings(claims[mapping.Claim]) {
+			if value == mapping.Value {
+				granted[mapping.Scope] = true
+			}
+		}
+	}
+	var scopes []string
+	for _, scope := range []string{ScopeReadStations, ScopeAdmin} {
+		if granted[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+/*
+ * 	Verifies a compact JWS token's signature, issuer, audience, expiry and
+ * 	not-before time, returning the principal it identifies
+ */
+func (v *JWTVerifier) Verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, fmt.Errorf("token is not a signed JWT")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	headerJSON, err := decodeSegment(parts[0])
+	if err == nil {
+		err = json.Unmarshal(headerJSON, &header)
+	}
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid token header")
+	}
+	if _, ok := jwtAlgorithms[header.Alg]; !ok {
+		return Principal{}, fmt.Errorf("algorithm %q is not accepted", header.Alg)
+	}
+	key, ok := v.key(header.Kid)
+	if !ok {
+		return Principal{}, fmt.Errorf("unknown key %q", header.Kid)
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return Principal{}, fmt.Errorf("key %q is for %s, not %s", header.Kid, key.alg, header.Alg)
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid token signature")
+	}
+	if err := verifySignature(header.Alg, key.key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return Principal{}, err
+	}
+
+	claims := map[string]interface{}{}
+	claimsJSON, err := decodeSegment(parts[1])
+	if err == nil {
+		err = json.Unmarshal(claimsJSON, &claims)
+	}
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid token claims")
+	}
+	now := v.now()
+	if issuer, _ := claims["iss"].(string); issuer != v.config.Issuer {
+		return Principal{}, fmt.Errorf("token was issued by %q", issuer)
+	}
+	if v.config.Audience != "" {
+		audienceOK := false
+		for _, audience := range claimStrings(claims["aud"]) {
+			audienceOK = audienceOK || audience == v.config.Audience
+		}
+		if !audienceOK {
+			return Principal{}, fmt.Errorf("token is not for audience %q", v.config.Audience)
+		}
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-jwtLeeway).After(time.Unix(int64(exp), 0)) {
+		return Principal{}, fmt.Errorf("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return Principal{}, fmt.Errorf("token is not valid yet")
+	}
+
+	subject, _ := claims["sub"].(string)
+	return Principal{Subject: subject, Issuer: v.config.Issuer, Scopes: v.scopes(claims)}, nil
+}
+
+/*
+ * 	Verifies bearer tokens that look like JWTs and stores their principal in
+ * 	the request context; other requests, including those with API keys, pass
+ * 	through for authorize to check
+ */
+func (v *JWTVerifier) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) <= 7 || !strings.EqualFold(authorization[:7], "Bearer ") || strings.Count(authorization, ".") != 2 {
+		next(w, req)
+		return
+	}
+	principal, err := v.Verify(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		l := newLocalizer(req)
+		l.SetHeader(w)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stations", error="invalid_token"`)
+		http.Error(w, l.Message("auth.invalidToken", nil), http.StatusUnauthorized)
+		log.WithFields(log.Fields{"Path": req.URL.Path}).Warn("Rejected bearer token", err)
+		return
+	}
+	log.WithFields(log.Fields{"subject": principal.Subject, "issuer": principal.Issuer, "Path": req.URL.Path}).Info("Authenticated bearer token")
+	next(w, req.WithContext(context.WithValue(req.Context(), principalContextKey{}, principal)))
+}
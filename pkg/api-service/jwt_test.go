@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/urfave/negroni"
+)
+
+const testIssuer = "https://issuer.example"
+
+var (
+	testRSAKeyOnce sync.Once
+	testRSAKey     *rsa.PrivateKey
+	testECKey      *ecdsa.PrivateKey
+)
+
+/*
+ * 	Returns the RSA and EC keys test tokens are signed with, generating them once
+ */
+func testSigningKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	testRSAKeyOnce.Do(func() {
+		var err error
+		if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		if testECKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return testRSAKey, testECKey
+}
+
+/*
+ * 	Returns a JWKS document with the test keys as "rsa1" and "ec1"
+ */
+func testJWKS(t *testing.T) []byte {
+	rsaKey, ecKey := testSigningKeys(t)
+	encode := base64.RawURLEncoding.EncodeToString
+	document := map[string][]jsonWebKey{"keys": {
+		{Kty: "RSA", Kid: "rsa1", Use: "sig", Alg: "RS256", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "EC", Kid: "ec1", Crv: "P-256", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.Y.Bytes())},
+	}}
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return documentJSON
+}
+
+/*
+ * 	Signs claims with the test key matching alg under the given kid
+ */
+func signTestToken(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	rsaKey, ecKey := testSigningKeys(t)
+	encode := base64.RawURLEncoding.EncodeToString
+	headerJSON, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	claimsJSON, _ := json.Marshal(claims)
+	signed := encode(headerJSON) + "." + encode(claimsJSON)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		signature = []byte("unsigned")
+	}
+	return signed + "." + encode(signature)
+}
+
+/*
+ * 	Returns claims for a token from the test issuer, valid for an hour, with extra claims added
+ */
+func testClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss": testIssuer,
+		"aud": "stations-api",
+		"sub": "map-service",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+	return claims
+}
+
+/*
+ * 	Creates a verifier reading the test JWKS from a file
+ */
+func newTestJWTVerifier(t *testing.T) *JWTVerifier {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, testJWKS(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewJWTVerifier(JWTConfig{
+		Issuer:      testIssuer,
+		Audience:    "stations-api",
+		JWKSFile:    path,
+		ClaimScopes: []ClaimScope{{Claim: "groups", Value: "bike-ops", Scope: ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func TestJWTVerifier(t *testing.T) {
+	verifier := newTestJWTVerifier(t)
+
+	principal, err := verifier.Verify(signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"scope": "openid read:stations"})))
+	if err != nil || principal.Subject != "map-service" || principal.Issuer != testIssuer || len(principal.Scopes) != 1 || principal.Scopes[0] != ScopeReadStations {
+		t.Errorf("Expected map-service with read:stations, but received %+v (%v)", principal, err)
+	}
+	principal, err = verifier.Verify(signTestToken(t, "ES256", "ec1", testClaims(map[string]interface{}{"aud": []string{"other", "stations-api"}, "groups": []string{"bike-ops"}})))
+	if err != nil || !principal.HasScope(ScopeAdmin) {
+		t.Errorf("Expected group bike-ops to map to admin, but received %+v (%v)", principal, err)
+	}
+
+	invalid := map[string]string{
+		"expired":         signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"no expiry":       signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"exp": nil})),
+		"not yet valid":   signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"wrong issuer":    signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"iss": "https://evil.example"})),
+		"wrong audience":  signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"aud": "other"})),
+		"unknown key":     signTestToken(t, "RS256", "rsa2", testClaims(nil)),
+		"none algorithm":  signTestToken(t, "none", "rsa1", testClaims(nil)),
+		"hmac algorithm":  signTestToken(t, "HS256", "rsa1", testClaims(nil)),
+		"wrong key type":  signTestToken(t, "RS256", "ec1", testClaims(nil)),
+		"tampered claims": signTestToken(t, "RS256", "rsa1", testClaims(nil))[:20] + "x" + signTestToken(t, "RS256", "rsa1", testClaims(nil))[21:],
+		"not a JWT":       "sk_abc",
+	}
+	for name, token := range invalid {
+		if principal, err := verifier.Verify(token); err == nil {
+			t.Errorf("%s: expected an error, but received %+v", name, principal)
+		}
+	}
+}
+
+func TestJWTVerifierFetchesKeys(t *testing.T) {
+	jwksFetches := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": server.URL + "/jwks"})
+		case "/jwks":
+			jwksFetches++
+			w.Write(testJWKS(t))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	verifier, err := NewJWTVerifier(JWTConfig{Issuer: server.URL, CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+	token := signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"iss": server.URL, "exp": now.Add(3 * time.Hour).Unix()}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := verifier.Verify(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if jwksFetches != 1 {
+		t.Errorf("Expected the JWKS to be fetched once through discovery, but it was fetched %d times", jwksFetches)
+	}
+	verifier.Verify(signTestToken(t, "RS256", "rotated", testClaims(map[string]interface{}{"iss": server.URL})))
+	verifier.Verify(signTestToken(t, "RS256", "rotated", testClaims(map[string]interface{}{"iss": server.URL})))
+	if jwksFetches != 2 {
+		t.Errorf("Expected one refetch for unknown key ids within a minute, but the JWKS was fetched %d times", jwksFetches)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := verifier.Verify(token); err != nil || jwksFetches != 3 {
+		t.Errorf("Expected the JWKS to be refetched after it expired, but it was fetched %d times (%v)", jwksFetches, err)
+	}
+}
+
+func TestJWTVerifierVerifiesDuringRefresh(t *testing.T) {
+	blocked, release := make(chan struct{}), make(chan struct{})
+	jwksFetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if jwksFetches++; jwksFetches > 1 {
+			close(blocked)
+			<-release
+		}
+		w.Write(testJWKS(t))
+	}))
+	defer server.Close()
+
+	verifier, err := NewJWTVerifier(JWTConfig{Issuer: testIssuer, JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed := make(chan struct{})
+	go func() {
+		verifier.Verify(signTestToken(t, "RS256", "rotated", testClaims(nil)))
+		close(refreshed)
+	}()
+	<-blocked
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(signTestToken(t, "RS256", "rsa1", testClaims(nil)))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Expected a token with a known key to verify during a refresh, but received %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected a token with a known key not to wait for the JWKS refresh")
+	}
+	close(release)
+	<-refreshed
+}
+
+func TestVerifySignatureChecksCurve(t *testing.T) {
+	_, ecKey := testSigningKeys(t)
+	signed := []byte("header.claims")
+	for alg, hash := range map[string]crypto.Hash{"ES256": crypto.SHA256, "ES384": crypto.SHA384} {
+		digest := hash.New()
+		digest.Write(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		err = verifySignature(alg, &ecKey.PublicKey, signed, signature)
+		if alg == "ES256" && err != nil {
+			t.Errorf("Expected ES256 to verify with a P-256 key, but received %v", err)
+		}
+		if alg == "ES384" && err == nil {
+			t.Errorf("Expected ES384 to be refused for a P-256 key")
+		}
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	jwtVerifier = newTestJWTVerifier(t)
+	defer func() { jwtVerifier = nil }()
+	mockStationFeed(allStationsJSON)
+	router := Router()
+	n := negroni.New(jwtVerifier)
+	n.UseHandler(router)
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, req)
+		return w
+	}
+	reader := signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"scp": []string{"read:stations"}}))
+
+	if w := serve("/stations/72/5", reader); w.Code != http.StatusOK {
+		t.Errorf("Expected a valid token to be accepted, but received %v", w.Code)
+	}
+	if w := serve("/keys", reader); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a read token to be refused admin routes, but received %v", w.Code)
+	}
+	if w := serve("/stations", signTestToken(t, "RS256", "rsa1", testClaims(map[string]interface{}{"aud": "other"}))); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected a token for another audience to be rejected, but received %v", w.Code)
+	}
+	if w := serve("/stations/72/5", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous requests to protected routes to be rejected, but received %v", w.Code)
+	}
+	if w := serve("/stations", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected anonymous requests to public routes to be allowed, but received %v", w.Code)
+	}
+}
+
+func TestParseClaimScopes(t *testing.T) {
+	mappings, err := ParseClaimScopes("groups:bike-ops=admin, roles:viewer=read:stations")
+	if err != nil || len(mappings) != 2 || mappings[1] != (ClaimScope{Claim: "roles", Value: "viewer", Scope: ScopeReadStations}) {
+		t.Errorf("Expected two mappings, but received %+v (%v)", mappings, err)
+	}
+	if _, err := ParseClaimScopes("groups=admin"); err == nil {
+		t.Errorf("Expected an error for a mapping without a value")
+	}
+}
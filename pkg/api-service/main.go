@@ -1,7 +1,10 @@
 package main
 
 import (
+	"flag"
 	"net/http"
+	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -23,25 +26,191 @@ func init() {
 	Client = &http.Client{}
 }
 
-/* Handles API routes using Gorilla Mux */
-func handleRequests() {
+/*
+ * 	Registers API routes using Gorilla Mux. Each route names the scope callers
+ * 	need; scopePublic routes may also be called anonymously.
+ */
+func newRouter() *mux.Router {
 	router := mux.NewRouter()
-	router.Methods("GET").Path("/stations").HandlerFunc(getAllStations)
-	router.Methods("GET").Path("/stations/in-service").HandlerFunc(getInServiceStations)
-	router.Methods("GET").Path("/stations/not-in-service").HandlerFunc(getNotInServiceStations)
-	router.Methods("GET").Path("/stations/{searchstring}").HandlerFunc(searchStations)
-	router.Methods("GET").Path("/stations/{stationid}/{bikestoreturn}").HandlerFunc(returnBikes)
+	router.Methods("GET").Path("/ws").HandlerFunc(authorize(ScopeReadStations, serveWs))
+	router.Methods("POST").Path("/keys").HandlerFunc(authorize(ScopeAdmin, createAPIKey))
+	router.Methods("GET").Path("/keys").HandlerFunc(authorize(ScopeAdmin, listAPIKeys))
+	router.Methods("POST").Path("/keys/{id}/rotate").HandlerFunc(authorize(ScopeAdmin, rotateAPIKey))
+	router.Methods("DELETE").Path("/keys/{id}").HandlerFunc(authorize(ScopeAdmin, revokeAPIKey))
+	router.Methods("POST").Path("/subscriptions").HandlerFunc(authorize(ScopeAdmin, createSubscription))
+	router.Methods("GET").Path("/subscriptions").HandlerFunc(authorize(ScopeAdmin, listSubscriptions))
+	router.Methods("GET").Path("/subscriptions/{id}").HandlerFunc(authorize(ScopeAdmin, getSubscription))
+	router.Methods("DELETE").Path("/subscriptions/{id}").HandlerFunc(authorize(ScopeAdmin, deleteSubscription))
+	router.Methods("GET").Path("/subscriptions/{id}/dead-letters").HandlerFunc(authorize(ScopeAdmin, getDeadLetters))
+	router.Methods("GET").Path("/openapi.json").HandlerFunc(authorize(scopePublic, getOpenAPI))
+	router.Methods("GET").Path("/docs").HandlerFunc(serveSwaggerUI)
+	router.Methods("GET", "POST").Path("/graphql").HandlerFunc(authorize(scopePublic, serveGraphQL))
+	if *graphiql {
+		router.Methods("GET").Path("/graphiql").HandlerFunc(serveGraphiQL)
+	}
+	router.Methods("GET").Path("/stations").HandlerFunc(authorize(scopePublic, getAllStations))
+	router.Methods("GET").Path("/stations/in-service").HandlerFunc(authorize(scopePublic, getInServiceStations))
+	router.Methods("GET").Path("/stations/not-in-service").HandlerFunc(authorize(scopePublic, getNotInServiceStations))
+	router.Methods("GET").Path("/stations/suggest").HandlerFunc(authorize(scopePublic, suggestStations))
+	router.Methods("GET").Path("/stations/stream").HandlerFunc(authorize(ScopeReadStations, streamStations))
+	router.Methods("POST").Path("/stations/dockable").HandlerFunc(authorize(ScopeReadStations, bulkDockable))
+	router.Methods("GET").Path("/stations/history/at").HandlerFunc(authorize(ScopeReadStations, getSystemStateAt))
+	router.Methods("GET").Path("/stations/{stationid:[0-9]+}/history").HandlerFunc(authorize(ScopeReadStations, getStationHistory))
+	router.Methods("GET").Path("/stations/{stationid:[0-9]+}/forecast").HandlerFunc(authorize(ScopeReadStations, getStationForecast))
+	router.Methods("GET").Path("/stations/{searchstring}").HandlerFunc(authorize(ScopeReadStations, searchStations))
+	router.Methods("GET").Path("/stations/{stationid}/rent/{bikestorent}").HandlerFunc(authorize(ScopeReadStations, rentBikes))
+	router.Methods("GET").Path("/stations/{stationid}/{bikestoreturn}").HandlerFunc(authorize(ScopeReadStations, returnBikes))
+	return router
+}
 
+var (
+	refreshInterval      = flag.Duration("refresh-interval", stationCacheTTL, "how often the station feed is refetched in the background")
+	historyPath          = flag.String("history-db", "history.db", "BoltDB file that station snapshots are recorded to; empty disables history")
+	historyRetention     = flag.Duration("history-retention", 30*24*time.Hour, "how long individual snapshots are kept before compaction")
+	compactionInterval   = flag.Duration("compaction-interval", time.Hour, "how often old snapshots are compacted")
+	subscriptionsPath    = flag.String("subscriptions-db", "subscriptions.db", "BoltDB file that webhook subscriptions are stored in; empty disables alerts")
+	apiKeysPath          = flag.String("api-keys-db", "", "BoltDB file that API keys are stored in; empty disables API keys. Without API keys or jwt-issuer, admin routes are refused and the rest are public. ADMIN_API_KEY is accepted as an admin key")
+	rateLimit            = flag.String("rate-limit", "10/s:20", "default token bucket per client and route, as requests/period:burst; empty disables rate limiting")
+	routeRateLimits      = flag.String("route-rate-limits", "/stations/dockable=2/s:5", "comma-separated route template=requests/period:burst overrides")
+	trustedProxies       = flag.String("trusted-proxies", "", "comma-separated proxy addresses or CIDR ranges whose X-Forwarded-For is believed")
+	jwtIssuer            = flag.String("jwt-issuer", "", "issuer bearer JWTs must come from; empty disables JWT authentication")
+	jwtAudience          = flag.String("jwt-audience", "", "audience bearer JWTs must be for; empty accepts any")
+	jwksURL              = flag.String("jwks-url", "", "URL of the issuer's JWKS; empty uses OIDC discovery")
+	jwksFile             = flag.String("jwks-file", "", "local JWKS file used instead of fetching keys")
+	jwksCacheTTL         = flag.Duration("jwks-cache-ttl", time.Hour, "how long fetched JWKS keys are cached")
+	jwtClaimScopes       = flag.String("jwt-claim-scopes", "", "comma-separated claim:value=scope mappings, such as groups:bike-ops=admin")
+	corsOrigins          = flag.String("cors-origins", "", "comma-separated origins allowed to call the API from browsers, * or wildcard subdomains such as https://*.example.com; empty disables CORS")
+	corsMethods          = flag.String("cors-methods", "GET,POST,DELETE", "comma-separated methods allowed in cross-origin requests")
+	corsHeaders          = flag.String("cors-headers", "Accept,Accept-Language,Authorization,Content-Type,X-API-Key", "comma-separated request headers allowed in cross-origin requests; * allows any")
+	corsCredentials      = flag.Bool("cors-credentials", false, "allow cross-origin requests to send credentials")
+	corsMaxAge           = flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+	compression          = flag.Bool("compression", true, "compress responses with brotli or gzip when clients accept it")
+	grpcAddr             = flag.String("grpc-addr", ":4001", "address the StationService gRPC API listens on; empty disables gRPC")
+	graphqlMaxDepth      = flag.Int("graphql-max-depth", 6, "deepest nesting of fields a GraphQL query may have")
+	graphqlMaxComplexity = flag.Int("graphql-max-complexity", 5000, "most fields a GraphQL query may resolve, counting list fields once per item")
+	graphiql             = flag.Bool("graphiql", false, "serve GraphiQL at /graphiql for local development")
+	staleAfter           = flag.Duration("stale-after", time.Hour, "how long a station may go without communicating before it is flagged stale and left out of listings")
+)
+
+/* Starts the background feed refresh, the diff engine, the change stream, the WebSocket hub, the history recorder, webhook alerts, API keys and JWT verification */
+func startBackgroundJobs(stop <-chan struct{}) {
+	store.OnRefresh(diffs.Publish)
+	diffs.OnDiff(stream.Publish)
+	go hub.Run(stream, stop)
+	if *historyPath != "" {
+		var err error
+		history, err = OpenHistoryStore(*historyPath, *historyRetention)
+		if err != nil {
+			log.Fatal("Error opening station history", err)
+		}
+		store.OnRefresh(history.Recorder())
+		go history.RunCompaction(*compactionInterval, stop)
+	}
+	if *subscriptionsPath != "" {
+		var err error
+		subscriptions, err = OpenSubscriptionStore(*subscriptionsPath)
+		if err != nil {
+			log.Fatal("Error opening webhook subscriptions", err)
+		}
+		alerts = newAlertEngine(subscriptions)
+		diffs.OnDiff(func(data StationData, _ []StationEvent) { alerts.Evaluate(data) })
+		go alerts.Run(stop)
+	}
+	if *apiKeysPath != "" {
+		var err error
+		apiKeys, err = OpenKeyStore(*apiKeysPath, os.Getenv("ADMIN_API_KEY"))
+		if err != nil {
+			log.Fatal("Error opening API keys", err)
+		}
+	}
+	if *jwtIssuer != "" {
+		claimScopes, err := ParseClaimScopes(*jwtClaimScopes)
+		if err != nil {
+			log.Fatal("Invalid jwt-claim-scopes", err)
+		}
+		jwtVerifier, err = NewJWTVerifier(JWTConfig{
+			Issuer:      *jwtIssuer,
+			Audience:    *jwtAudience,
+			JWKSURL:     *jwksURL,
+			JWKSFile:    *jwksFile,
+			CacheTTL:    *jwksCacheTTL,
+			ClaimScopes: claimScopes,
+		})
+		if err != nil {
+			log.Fatal("Error loading JWKS", err)
+		}
+	}
+	go store.Run(*refreshInterval, stop)
+}
+
+/* Builds the rate limiter configured by the rate-limit flags */
+func newRateLimiterFromFlags(router *mux.Router) *RateLimiter {
+	defaultLimit, err := ParseRateLimit(*rateLimit)
+	if err != nil {
+		log.Fatal("Invalid rate-limit", err)
+	}
+	limits, err := ParseRouteRateLimits(*routeRateLimits)
+	if err != nil {
+		log.Fatal("Invalid route-rate-limits", err)
+	}
+	proxies, err := ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatal("Invalid trusted-proxies", err)
+	}
+	return NewRateLimiter(NewMemoryRateLimitStore(), router, defaultLimit, limits, proxies)
+}
+
+/* Builds the CORS middleware configured by the cors flags */
+func newCORSFromFlags(router *mux.Router) *CORS {
+	return NewCORS(CORSConfig{
+		AllowedOrigins:   splitList(*corsOrigins),
+		AllowedMethods:   splitList(*corsMethods),
+		AllowedHeaders:   splitList(*corsHeaders),
+		AllowCredentials: *corsCredentials,
+		MaxAge:           *corsMaxAge,
+	}, router)
+}
+
+/*
+ * 	Wraps router in the middleware the flags enable, outermost first: CORS
+ * 	answers preflights before JWT verification and rate limiting see them, and
+ * 	the compressor sees every response, including the validator's
+ */
+func newHandler(router *mux.Router) http.Handler {
 	n := negroni.Classic()
+	if *compression {
+		n.Use(NewCompressor(compressMinSize))
+	}
+	if *corsOrigins != "" {
+		n.Use(newCORSFromFlags(router))
+	}
+	if jwtVerifier != nil {
+		n.Use(jwtVerifier)
+	}
+	if *rateLimit != "" {
+		n.Use(newRateLimiterFromFlags(router))
+	}
+	n.Use(NewValidator(router, newOpenAPIDocument()))
 	n.UseHandler(router)
+	return n
+}
+
+/* Handles API routes using Gorilla Mux */
+func handleRequests() {
+	handler := newHandler(newRouter())
 
 	log.SetFormatter(&log.JSONFormatter{})
 	log.Info("Starting server with port 4000")
-	if err := http.ListenAndServe(":4000", n); err != nil {
+	if err := http.ListenAndServe(":4000", handler); err != nil {
 		log.Fatal("Error starting server", err)
 	}
 }
 
 func main() {
+	flag.Parse()
+	startBackgroundJobs(make(chan struct{}))
+	if *grpcAddr != "" {
+		go serveGRPC(*grpcAddr)
+	}
 	handleRequests()
 }
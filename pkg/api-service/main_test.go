@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerMiddlewareOrder(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	jwtVerifier = newTestJWTVerifier(t)
+	origins, limit := *corsOrigins, *rateLimit
+	*corsOrigins, *rateLimit = "https://map.example.com", "1/m:1"
+	defer func() { jwtVerifier, *corsOrigins, *rateLimit = nil, origins, limit }()
+	handler := newHandler(Router())
+	token := signTestToken(t, "RS256", "rsa1", testClaims(nil))
+	serve := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = "203.0.113.5:1234"
+		req.Header.Set("Origin", "https://map.example.com")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("OPTIONS", "/stations", map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "authorization"}); w.Code != http.StatusNoContent {
+			t.Errorf("Expected preflight %d to be answered before rate limiting, but received %v", i+1, w.Code)
+		}
+	}
+	if w := serve("GET", "/stations", map[string]string{"Authorization": "Bearer a.b.c"}); w.Code != http.StatusUnauthorized || w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("Expected an invalid token to be refused with CORS headers, but received %v %v", w.Code, w.Header())
+	}
+
+	w := serve("GET", "/stations?pretty=true&include_stale=true", map[string]string{"Authorization": "Bearer " + token, "Accept-Encoding": "gzip"})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Expected a compressed, rate-limited listing, but received %v %v", w.Code, w.Header())
+	}
+	if w := serve("GET", "/stations", map[string]string{"Authorization": "Bearer " + token}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the second listing to be rate limited, but received %v", w.Code)
+	}
+
+	w = serve("GET", "/stations/83/0", map[string]string{"Authorization": "Bearer " + token})
+	validationError := ValidationError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &validationError); err != nil || w.Code != http.StatusBadRequest || len(validationError.Violations) == 0 {
+		t.Errorf("Expected the validator to refuse a count of 0, but received %v %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	apiVersion     = "1.0"
+)
+
+// OpenAPIDocument - the OpenAPI 3 description of every route newRouter registers
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// OpenAPIInfo - the API's title, version and description
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// OpenAPIComponents - schemas and security schemes that operations refer to
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+// OpenAPISecurityScheme - one way of sending credentials
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// OpenAPIOperation - one method on one path
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+// OpenAPIParameter - a path, query or header parameter
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIRequestBody - the body an operation accepts
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIMediaType - the schema of a body in one media type
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIResponse - what an operation answers with one status code
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPISchema - the subset of JSON Schema that OpenAPI 3.0 uses
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Default              interface{}               `json:"default,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	OneOf                []*OpenAPISchema          `json:"oneOf,omitempty"`
+}
+
+// openAPIBuilder - collects operations and the component schemas they refer to
+type openAPIBuilder struct {
+	document OpenAPIDocument
+}
+
+var (
+	// openAPISchemaNames - component names for types not named after their Go type
+	openAPISchemaNames = map[reflect.Type]string{
+		reflect.TypeOf(StationResponse{}): "Station",
+	}
+
+	// openAPIEnums - the values of string types that only take a fixed set
+	openAPIEnums = map[reflect.Type][]interface{}{
+		reflect.TypeOf(ReasonOK):       {ReasonOK, ReasonInsufficientDocks, ReasonNotInService, ReasonStationUnknown, ReasonInvalidCount, ReasonStatusUnknown},
+		reflect.TypeOf(ConditionEmpty): {ConditionNotInService, ConditionEmpty, ConditionFull, ConditionStale},
+	}
+
+	// muxVariablePattern - a {name} or {name:pattern} variable in a mux path template
+	muxVariablePattern = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]+))?\}`)
+)
+
+/*
+ * 	Builds the OpenAPI document for the routes newRouter registers with the
+ * 	current flags. TestOpenAPIMatchesRoutes fails when the two disagree.
+ */
+func newOpenAPIDocument() OpenAPIDocument {
+	b := &openAPIBuilder{document: OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:   "Citi Bike stations API",
+			Version: apiVersion,
+			Description: "Station availability from the Citi Bike feed. Messages are translated " +
+				"according to Accept-Language (en, es or zh) and JSON is indented with ?pretty=true.",
+		},
+		Paths: map[string]map[string]*OpenAPIOperation{},
+		Components: OpenAPIComponents{
+			Schemas: map[string]*OpenAPISchema{
+				"Error": {Type: "string", Description: "A message in the language negotiated from Accept-Language"},
+			},
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", Description: "An API key or an OIDC JWT"},
+				"apiKey":     {Type: "apiKey", In: "header", Name: "X-API-Key"},
+			},
+		},
+	}}
+
+	page := queryParameter("page", "1-based page of "+strconv.Itoa(itemsPerPage)+" stations; every station without it", &OpenAPISchema{Type: "integer", Minimum: float64Pointer(1)})
+	fields := queryParameter("fields", "Comma-separated Station fields to return instead of the defaults", &OpenAPISchema{Type: "string"})
+	format := queryParameter("format", "Export format, overriding the Accept header", &OpenAPISchema{Type: "string", Enum: []interface{}{formatJSON, formatCSV, formatNDJSON}})
+	includeTest := queryParameter("include_test", "Also return test stations", &OpenAPISchema{Type: "boolean", Default: false})
+	includeStale := queryParameter("include_stale", "Also return stations that have stopped communicating, and show their stale flag by default", &OpenAPISchema{Type: "boolean", Default: false})
+	stationID := pathParameter("stationid", "Station id", &OpenAPISchema{Type: "integer"})
+	keyID := pathParameter("id", "API key id", &OpenAPISchema{Type: "string"})
+	subscriptionID := pathParameter("id", "Subscription id", &OpenAPISchema{Type: "string"})
+	queryTime := func(name, description string) OpenAPIParameter {
+		return queryParameter(name, description+". RFC 3339, or without an offset in New York time", &OpenAPISchema{Type: "string"})
+	}
+
+	stationList := func(id, summary string) *OpenAPIOperation {
+		return &OpenAPIOperation{
+			OperationID: id,
+			Summary:     summary,
+			Description: "Only the fields selected by ?fields= are returned. ?format=csv or ?format=ndjson, or the Accept header, exports the stations as a file instead. Test stations and stale stations are left out unless ?include_test= or ?include_stale= asks for them.",
+			Parameters:  []OpenAPIParameter{page, fields, format, includeTest, includeStale},
+			Responses: map[string]OpenAPIResponse{
+				"200": b.stationListResponse(),
+				"400": errorResponse("Unknown fields or unsupported format"),
+				"502": errorResponse("The station feed is unavailable"),
+			},
+		}
+	}
+	b.add("GET", "/stations", scopePublic, stationList("listStations", "List every station"))
+	b.add("GET", "/stations/in-service", scopePublic, stationList("listInServiceStations", "List stations that are in service"))
+	b.add("GET", "/stations/not-in-service", scopePublic, stationList("listNotInServiceStations", "List stations that are not in service"))
+	b.add("GET", "/stations/suggest", scopePublic, &OpenAPIOperation{
+		OperationID: "suggestStations",
+		Summary:     "Suggest stations whose name or address has a word starting with q",
+		Parameters: []OpenAPIParameter{
+			queryParameter("q", "Prefix to match", &OpenAPISchema{Type: "string", MaxLength: intPointer(maxSearchLength)}),
+			queryParameter("limit", "Most suggestions to return", &OpenAPISchema{Type: "integer", Minimum: float64Pointer(1), Maximum: float64Pointer(maxSuggestLimit), Default: defaultSuggestLimit}),
+		},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("Matching stations, best first", []Suggestion{}),
+			"502": errorResponse("The station feed is unavailable"),
+		},
+	})
+	b.add("GET", "/stations/stream", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "streamStations",
+		Summary:     "Stream station changes as server-sent events",
+		Description: "A snapshot event with every matching station, then a change event whenever a refresh changes one. Reconnecting with Last-Event-ID resumes from the missed changes.",
+		Parameters: []OpenAPIParameter{
+			queryParameter("ids", "Comma-separated station ids to watch", &OpenAPISchema{Type: "string"}),
+			queryParameter("bbox", "minLongitude,minLatitude,maxLongitude,maxLatitude", &OpenAPISchema{Type: "string"}),
+			{Name: "Last-Event-ID", In: "header", Description: "The id of the last event received", Schema: &OpenAPISchema{Type: "string"}},
+		},
+		Responses: map[string]OpenAPIResponse{
+			"200": {Description: "An event stream of StationUpdate data", Content: map[string]OpenAPIMediaType{"text/event-stream": {Schema: b.schemaOf(reflect.TypeOf(StationUpdate{}))}}},
+			"400": errorResponse("Invalid ids or bbox"),
+			"500": errorResponse("The connection cannot stream"),
+			"502": errorResponse("The station feed is unavailable"),
+		},
+	})
+	b.add("POST", "/stations/dockable", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "bulkDockable",
+		Summary:     "Check whether bikes can be returned to several stations at once",
+		RequestBody: &OpenAPIRequestBody{Required: true, Content: map[string]OpenAPIMediaType{"application/json": {Schema: &OpenAPISchema{
+			Type: "array", Items: b.schemaOf(reflect.TypeOf(DockableRequest{})), MinItems: intPointer(1), MaxItems: intPointer(maxBulkDockableItems),
+		}}}},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("One result per request, from the same snapshot", []DockableResult{}),
+			"400": {Description: "An invalid body or too many requests, or one result per request with the reason each invalid one was rejected", Content: mergeContent(
+				b.jsonContent([]DockableResult{}), errorResponse("").Content)},
+			"413": errorResponse("The body is over " + strconv.Itoa(maxBulkDockableBytes) + " bytes"),
+			"502": errorResponse("The station feed is unavailable"),
+		},
+	})
+	b.add("GET", "/stations/history/at", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "getSystemStateAt",
+		Summary:     "Every station's state in the last snapshot recorded at or before t",
+		Parameters:  []OpenAPIParameter{queryTime("t", "When to look at; now without it"), format},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.exportResponse("The recorded snapshot", SystemState{}),
+			"400": errorResponse("Invalid time or unsupported format"),
+			"404": errorResponse("Nothing was recorded before t"),
+			"500": errorResponse("History could not be read"),
+			"503": errorResponse("History is disabled"),
+		},
+	})
+	b.add("GET", "/stations/{stationid:[0-9]+}/history", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "getStationHistory",
+		Summary:     "A station's bikes and docks over time, downsampled into buckets",
+		Parameters: []OpenAPIParameter{
+			stationID,
+			queryTime("from", "Start of the range; 24 hours before to without it"),
+			queryTime("to", "End of the range; now without it"),
+			queryParameter("step", "Bucket size as a Go duration, at least 1m; from and to may span at most "+strconv.Itoa(maxHistoryBuckets)+" of them", &OpenAPISchema{Type: "string", Default: defaultHistoryStep.String()}),
+			format,
+		},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.exportResponse("The station's history", StationHistory{}),
+			"400": errorResponse("Invalid range, step or format"),
+			"500": errorResponse("History could not be read"),
+			"503": errorResponse("History is disabled"),
+		},
+	})
+	b.add("GET", "/stations/{stationid:[0-9]+}/forecast", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "getStationForecast",
+		Summary:     "Predict a station's bikes and docks from its recorded history",
+		Parameters:  []OpenAPIParameter{stationID, queryTime("at", "When to predict for; now without it")},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("The forecast with a 95% interval", Forecast{}),
+			"400": errorResponse("Invalid time or too far ahead"),
+			"404": errorResponse("No history for the station"),
+			"500": errorResponse("History could not be read"),
+			"503": errorResponse("History is disabled"),
+		},
+	})
+	search := stationList("searchStations", "Find stations whose name or address contains searchstring")
+	search.Parameters = []OpenAPIParameter{pathParameter("searchstring", "Text to look for, ignoring case", &OpenAPISchema{Type: "string", MaxLength: intPointer(maxSearchLength)}), fields, format, includeTest, includeStale}
+	search.Responses["200"] = OpenAPIResponse{
+		Description: "Matching stations, or a message in plain text when JSON was asked for and none match",
+		Content:     mergeContent(b.stationListResponse().Content, errorResponse("").Content),
+	}
+	b.add("GET", "/stations/{searchstring}", ScopeReadStations, search)
+	b.add("GET", "/stations/{stationid}/rent/{bikestorent}", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "rentBikes",
+		Summary:     "Check whether bikes can be rented from a station",
+		Parameters:  []OpenAPIParameter{stationID, pathParameter("bikestorent", "Bikes to rent", &OpenAPISchema{Type: "integer", Minimum: float64Pointer(1)})},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("Whether the bikes can be rented, with nearby alternatives when they cannot", RentableInfo{}),
+			"400": b.jsonResponse("The count is not a positive number", RentableInfo{}),
+			"404": b.jsonResponse("No station has that id", RentableInfo{}),
+			"502": errorResponse("The station feed is unavailable"),
+		},
+	})
+	b.add("GET", "/stations/{stationid}/{bikestoreturn}", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "returnBikes",
+		Summary:     "Check whether bikes can be returned to a station",
+		Parameters: []OpenAPIParameter{
+			stationID,
+			pathParameter("bikestoreturn", "Bikes to return", &OpenAPISchema{Type: "integer", Minimum: float64Pointer(1)}),
+			queryTime("arrival", "When the bikes will arrive, to answer from a forecast"),
+		},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("Whether the bikes can be returned, and why", DockableInfo{}),
+			"400": {Description: "The count is not a positive number, or an invalid arrival time", Content: mergeContent(
+				b.jsonContent(DockableInfo{}), errorResponse("").Content)},
+			"404": b.jsonResponse("No station has that id", DockableInfo{}),
+			"500": errorResponse("History could not be read"),
+			"502": errorResponse("The station feed is unavailable"),
+			"503": errorResponse("An arrival was given but history is disabled"),
+		},
+	})
+
+	b.add("GET", "/ws", ScopeReadStations, &OpenAPIOperation{
+		OperationID: "serveWs",
+		Summary:     "Subscribe to station changes over a WebSocket",
+		Description: "Send {\"action\": \"subscribe\" or \"unsubscribe\", \"ids\": [...], \"areas\": [[minLon, minLat, maxLon, maxLat]]} at any time to choose stations, and receive {\"type\": \"change\", \"station\": {...}} for matching ones, or {\"type\": \"snapshot\", \"stations\": [...]} when the server resyncs after falling behind.",
+		Responses: map[string]OpenAPIResponse{
+			"101": {Description: "Switched to the WebSocket protocol"},
+			"400": errorResponse("Not a WebSocket upgrade request"),
+		},
+	})
+	b.add("GET", "/graphql", scopePublic, b.graphqlOperation("queryGraphQL", []OpenAPIParameter{
+		queryParameter("query", "The GraphQL query", &OpenAPISchema{Type: "string"}),
+		queryParameter("variables", "Query variables as a JSON object", &OpenAPISchema{Type: "string"}),
+		queryParameter("operationName", "The operation to run", &OpenAPISchema{Type: "string"}),
+	}, nil))
+	b.add("POST", "/graphql", scopePublic, b.graphqlOperation("postGraphQL", nil, &OpenAPIRequestBody{Required: true, Content: b.jsonContent(GraphQLRequest{})}))
+	if *graphiql {
+		b.add("GET", "/graphiql", scopePublic, htmlOperation("graphiql", "GraphiQL for exploring /graphql"))
+	}
+	b.add("GET", "/openapi.json", scopePublic, &OpenAPIOperation{
+		OperationID: "getOpenAPI",
+		Summary:     "This document",
+		Responses:   map[string]OpenAPIResponse{"200": {Description: "The OpenAPI document", Content: map[string]OpenAPIMediaType{"application/json": {Schema: &OpenAPISchema{Type: "object"}}}}},
+	})
+	b.add("GET", "/docs", scopePublic, htmlOperation("docs", "Swagger UI for this document"))
+
+	b.add("POST", "/keys", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "createAPIKey",
+		Summary:     "Issue an API key; its secret is only returned here",
+		RequestBody: &OpenAPIRequestBody{Required: true, Content: b.jsonContent(APIKeyRequest{})},
+		Responses: map[string]OpenAPIResponse{
+			"201": b.jsonResponse("The key and its secret", IssuedAPIKey{}),
+			"400": errorResponse("Invalid name, scopes or quota"),
+			"500": errorResponse("The key could not be stored"),
+			"503": errorResponse("Authentication is disabled"),
+		},
+	})
+	b.add("GET", "/keys", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "listAPIKeys",
+		Summary:     "List API keys without their secrets",
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("Every key", []APIKey{}),
+			"503": errorResponse("Authentication is disabled"),
+		},
+	})
+	b.add("POST", "/keys/{id}/rotate", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "rotateAPIKey",
+		Summary:     "Replace an API key's secret",
+		Parameters:  []OpenAPIParameter{keyID},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("The key and its new secret", IssuedAPIKey{}),
+			"404": errorResponse("No such key"),
+			"500": errorResponse("The key could not be stored"),
+			"503": errorResponse("Authentication is disabled"),
+		},
+	})
+	b.add("DELETE", "/keys/{id}", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "revokeAPIKey",
+		Summary:     "Revoke an API key",
+		Parameters:  []OpenAPIParameter{keyID},
+		Responses: map[string]OpenAPIResponse{
+			"204": {Description: "The key was revoked"},
+			"404": errorResponse("No such key"),
+			"500": errorResponse("The key could not be stored"),
+			"503": errorResponse("Authentication is disabled"),
+		},
+	})
+	b.add("POST", "/subscriptions", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "createSubscription",
+		Summary:     "Subscribe a webhook to station alerts; its secret is only returned here",
+		RequestBody: &OpenAPIRequestBody{Required: true, Content: b.jsonContent(Subscription{})},
+		Responses: map[string]OpenAPIResponse{
+			"201": b.jsonResponse("The subscription and its secret", Subscription{}),
+			"400": errorResponse("Invalid subscription"),
+			"500": errorResponse("The subscription could not be stored"),
+			"503": errorResponse("Alerts are disabled"),
+		},
+	})
+	b.add("GET", "/subscriptions", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "listSubscriptions",
+		Summary:     "List webhook subscriptions without their secrets",
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("Every subscription", []Subscription{}),
+			"503": errorResponse("Alerts are disabled"),
+		},
+	})
+	b.add("GET", "/subscriptions/{id}", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "getSubscription",
+		Summary:     "Get a webhook subscription",
+		Parameters:  []OpenAPIParameter{subscriptionID},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("The subscription", Subscription{}),
+			"404": errorResponse("No such subscription"),
+			"503": errorResponse("Alerts are disabled"),
+		},
+	})
+	b.add("DELETE", "/subscriptions/{id}", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "deleteSubscription",
+		Summary:     "Delete a webhook subscription",
+		Parameters:  []OpenAPIParameter{subscriptionID},
+		Responses: map[string]OpenAPIResponse{
+			"204": {Description: "The subscription was deleted"},
+			"404": errorResponse("No such subscription"),
+			"500": errorResponse("The subscription could not be deleted"),
+			"503": errorResponse("Alerts are disabled"),
+		},
+	})
+	b.add("GET", "/subscriptions/{id}/dead-letters", ScopeAdmin, &OpenAPIOperation{
+		OperationID: "getDeadLetters",
+		Summary:     "Alerts that could not be delivered to a subscription",
+		Parameters:  []OpenAPIParameter{subscriptionID},
+		Responses: map[string]OpenAPIResponse{
+			"200": b.jsonResponse("Undelivered alerts, oldest first", []DeadLetter{}),
+			"404": errorResponse("No such subscription"),
+			"500": errorResponse("Dead letters could not be read"),
+			"503": errorResponse("Alerts are disabled"),
+		},
+	})
+	return b.document
+}
+
+/*
+ * 	Adds an operation for a mux path template, which may constrain variables
+ * 	with patterns. Every operation can be rate limited, those with parameters
+ * 	can fail validation, and those that need a scope also document how
+ * 	credentials are checked.
+ */
+func (b *openAPIBuilder) add(method string, template string, scope string, operation *OpenAPIOperation) {
+	path := muxVariablePattern.ReplaceAllString(template, "{$1}")
+	for _, match := range muxVariablePattern.FindAllStringSubmatch(template, -1) {
+		for i := range operation.Parameters {
+			if operation.Parameters[i].In == "path" && operation.Parameters[i].Name == match[1] && match[2] != "" {
+				schema := *operation.Parameters[i].Schema
+				schema.Pattern = "^" + match[2] + "$"
+				operation.Parameters[i].Schema = &schema
+			}
+		}
+	}
+
+	if len(operation.Parameters) > 0 {
+		invalid, ok := operation.Responses["400"]
+		if !ok {
+			invalid.Description = "Invalid parameters"
+		}
+		invalid.Content = mergeContent(invalid.Content, b.jsonContent(ValidationError{}))
+		operation.Responses["400"] = invalid
+	}
+	operation.Responses["429"] = errorResponse("Too many requests; Retry-After says when to try again")
+	if scope != scopePublic {
+		operation.Description = strings.TrimSpace(operation.Description + " Requires the " + scope + " scope.")
+		operation.Security = []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+		operation.Responses["401"] = errorResponse("Missing or invalid credentials")
+		operation.Responses["403"] = errorResponse("The credentials lack the " + scope + " scope")
+		operation.Responses["429"] = errorResponse("Too many requests, or the API key's daily quota is used up; Retry-After says when to try again")
+	}
+	if b.document.Paths[path] == nil {
+		b.document.Paths[path] = map[string]*OpenAPIOperation{}
+	}
+	b.document.Paths[path][strings.ToLower(method)] = operation
+}
+
+/*
+ * 	Returns a schema for t, adding named structs to the components and
+ * 	referring to them. Properties come from json tags; fields without
+ * 	omitempty are required, and embedded structs are flattened.
+ */
+func (b *openAPIBuilder) schemaOf(t reflect.Type) *OpenAPISchema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	}
+	if values, ok := openAPIEnums[t]; ok {
+		return &OpenAPISchema{Type: "string", Enum: values}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schemaOf(t.Elem())
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &OpenAPISchema{Type: "array", Items: b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		name, ok := openAPISchemaNames[t]
+		if !ok {
+			name = t.Name()
+		}
+		if _, exists := b.document.Components.Schemas[name]; !exists {
+			schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+			b.document.Components.Schemas[name] = schema
+			b.addProperties(schema, t, true)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	return &OpenAPISchema{}
+}
+
+/*
+ * 	Adds the json fields of struct t to schema, marking those without
+ * 	omitempty as required when required is true
+ */
+func (b *openAPIBuilder) addProperties(schema *OpenAPISchema, t reflect.Type, required bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" || field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous && tag[0] == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			b.addProperties(schema, embedded, required && field.Type.Kind() != reflect.Ptr)
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = b.schemaOf(field.Type)
+		if required && !strings.Contains(field.Tag.Get("json"), ",omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+/*
+ * 	Returns a JSON body of v's type
+ */
+func (b *openAPIBuilder) jsonContent(v interface{}) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{"application/json": {Schema: b.schemaOf(reflect.TypeOf(v))}}
+}
+
+/*
+ * 	Returns a JSON response of v's type
+ */
+func (b *openAPIBuilder) jsonResponse(description string, v interface{}) OpenAPIResponse {
+	return OpenAPIResponse{Description: description, Content: b.jsonContent(v)}
+}
+
+/*
+ * 	Returns a response that is JSON of v's type, or a CSV or NDJSON export
+ */
+func (b *openAPIBuilder) exportResponse(description string, v interface{}) OpenAPIResponse {
+	response := b.jsonResponse(description+"; CSV and NDJSON exports have one row per item", v)
+	response.Content["text/csv"] = OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string"}}
+	response.Content["application/x-ndjson"] = OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string"}}
+	return response
+}
+
+/*
+ * 	Returns the response of the listing and search endpoints
+ */
+func (b *openAPIBuilder) stationListResponse() OpenAPIResponse {
+	return b.exportResponse("Stations with the selected fields only", []StationResponse{})
+}
+
+/*
+ * 	Returns a /graphql operation taking its query from parameters or body
+ */
+func (b *openAPIBuilder) graphqlOperation(id string, parameters []OpenAPIParameter, body *OpenAPIRequestBody) *OpenAPIOperation {
+	result := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{
+		"data":   {Type: "object"},
+		"errors": {Type: "array", Items: b.schemaOf(reflect.TypeOf(GraphQLError{}))},
+	}}
+	return &OpenAPIOperation{
+		OperationID: id,
+		Summary:     "Run a GraphQL query against one station snapshot",
+		Description: "Queries deeper than graphql-max-depth or costlier than graphql-max-complexity are rejected before they run. search and dockable need the read:stations scope when authentication is enabled.",
+		Parameters:  parameters,
+		RequestBody: body,
+		Responses: map[string]OpenAPIResponse{
+			"200": {Description: "The query's data, with errors for any fields that failed", Content: map[string]OpenAPIMediaType{"application/json": {Schema: result}}},
+			"400": {Description: "No query, or one that is invalid or over the limits", Content: map[string]OpenAPIMediaType{"application/json": {Schema: result}}},
+			"502": {Description: "The station feed is unavailable", Content: map[string]OpenAPIMediaType{"application/json": {Schema: result}}},
+		},
+	}
+}
+
+/*
+ * 	Returns an operation serving an HTML page
+ */
+func htmlOperation(id, summary string) *OpenAPIOperation {
+	return &OpenAPIOperation{
+		OperationID: id,
+		Summary:     summary,
+		Responses:   map[string]OpenAPIResponse{"200": {Description: "An HTML page", Content: map[string]OpenAPIMediaType{"text/html": {Schema: &OpenAPISchema{Type: "string"}}}}},
+	}
+}
+
+/*
+ * 	Returns a plain text error response
+ */
+func errorResponse(description string) OpenAPIResponse {
+	return OpenAPIResponse{Description: description, Content: map[string]OpenAPIMediaType{"text/plain": {Schema: &OpenAPISchema{Ref: "#/components/schemas/Error"}}}}
+}
+
+/*
+ * 	Returns the media types of every content map. Different schemas for the
+ * 	same media type are combined with oneOf.
+ */
+func mergeContent(contents ...map[string]OpenAPIMediaType) map[string]OpenAPIMediaType {
+	merged := map[string]OpenAPIMediaType{}
+	for _, content := range contents {
+		for mediaType, media := range content {
+			existing, ok := merged[mediaType]
+			if !ok || reflect.DeepEqual(existing.Schema, media.Schema) {
+				merged[mediaType] = media
+				continue
+			}
+			schemas := existing.Schema.OneOf
+			if schemas == nil {
+				schemas = []*OpenAPISchema{existing.Schema}
+			}
+			merged[mediaType] = OpenAPIMediaType{Schema: &OpenAPISchema{OneOf: append(schemas, media.Schema)}}
+		}
+	}
+	return merged
+}
+
+/*
+ * 	Returns a required path parameter
+ */
+func pathParameter(name, description string, schema *OpenAPISchema) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "path", Description: description, Required: true, Schema: schema}
+}
+
+/*
+ * 	Returns an optional query parameter
+ */
+func queryParameter(name, description string, schema *OpenAPISchema) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+/*
+ * 	Returns a pointer to f, for optional schema bounds
+ */
+func float64Pointer(f float64) *float64 {
+	return &f
+}
+
+/*
+ * 	Returns a pointer to n, for optional schema lengths
+ */
+func intPointer(n int) *int {
+	return &n
+}
+
+/*
+ *	Endpoint: /openapi.json
+ *
+ * 	Returns the OpenAPI 3 document describing every route
+ */
+func getOpenAPI(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing getOpenAPI entrypoint")
+	contextLogger := log.WithFields(log.Fields{"Path": req.URL.Path})
+	writeJSON(w, req, http.StatusOK, newOpenAPIDocument(), contextLogger)
+}
+
+/*
+ *	Endpoint: /docs
+ *
+ * 	Serves Swagger UI for /openapi.json
+ */
+func serveSwaggerUI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
+
+// swaggerUIPage - Swagger UI loaded from a CDN, showing /openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Stations API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@4.5.0/swagger-ui.css" />
+</head>
+<body style="margin: 0;">
+	<div id="swagger-ui"></div>
+	<script crossorigin src="https://unpkg.com/swagger-ui-dist@4.5.0/swagger-ui-bundle.js"></script>
+	<script>
+		SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+	</script>
+</body>
+</html>
+`
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+ * 	Returns "METHOD /path" for every route the router registers, with path
+ * 	variables written as OpenAPI writes them
+ */
+func routerOperations(t *testing.T, router *mux.Router) []string {
+	var operations []string
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			operations = append(operations, method+" "+muxVariablePattern.ReplaceAllString(template, "{$1}"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+/*
+ * 	Returns "METHOD /path" for every operation in the document
+ */
+func documentOperations(document OpenAPIDocument) []string {
+	var operations []string
+	for path, methods := range document.Paths {
+		for method := range methods {
+			operations = append(operations, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		*graphiql = enabled
+		routes, documented := routerOperations(t, Router()), documentOperations(newOpenAPIDocument())
+		if !reflect.DeepEqual(routes, documented) {
+			t.Errorf("Expected the document to describe exactly the registered routes with graphiql=%v\nroutes:     %v\ndocumented: %v", enabled, routes, documented)
+		}
+	}
+	*graphiql = false
+
+	for path, methods := range newOpenAPIDocument().Paths {
+		var variables []string
+		for _, match := range muxVariablePattern.FindAllStringSubmatch(path, -1) {
+			variables = append(variables, match[1])
+		}
+		for method, operation := range methods {
+			var parameters []string
+			for _, parameter := range operation.Parameters {
+				if parameter.In == "path" {
+					parameters = append(parameters, parameter.Name)
+				}
+			}
+			if !reflect.DeepEqual(variables, parameters) {
+				t.Errorf("Expected %s %s to document path parameters %v, but it documents %v", method, path, variables, parameters)
+			}
+			if len(operation.Responses) == 0 {
+				t.Errorf("Expected %s %s to document its responses", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	document := newOpenAPIDocument()
+	station := document.Components.Schemas["Station"]
+	if station == nil || len(station.Properties) != len(stationFields) {
+		t.Fatalf("Expected a Station schema with every selectable field, but received %v", station)
+	}
+	for _, field := range stationFields {
+		if station.Properties[field.name] == nil {
+			t.Errorf("Expected the Station schema to have %s", field.name)
+		}
+	}
+
+	dockable := document.Components.Schemas["DockableInfo"]
+	if dockable == nil || len(dockable.Properties["reason"].Enum) != 6 || dockable.Properties["alternatives"].Items.Ref != "#/components/schemas/StationOption" {
+		t.Errorf("Expected DockableInfo with a reason enum and StationOption alternatives, but received %v", dockable)
+	}
+	if required := strings.Join(dockable.Required, ","); required != "dockable,message,reason,availableDocks,requested,shortfall" {
+		t.Errorf("Expected the fields without omitempty to be required, but received %s", required)
+	}
+	if issued := document.Components.Schemas["IssuedAPIKey"]; issued == nil || issued.Properties["key"] == nil || issued.Properties["scopes"] == nil {
+		t.Errorf("Expected IssuedAPIKey to include the embedded APIKey fields, but received %v", issued)
+	}
+
+	returnBikes := document.Paths["/stations/{stationid}/{bikestoreturn}"]["get"]
+	if returnBikes.Responses["200"].Content["application/json"].Schema.Ref != "#/components/schemas/DockableInfo" ||
+		returnBikes.Responses["401"].Content["text/plain"].Schema.Ref != "#/components/schemas/Error" {
+		t.Errorf("Expected returnBikes to answer DockableInfo or an Error, but received %v", returnBikes.Responses)
+	}
+	rent := document.Paths["/stations/{stationid}/rent/{bikestorent}"]["get"]
+	if oneOf := rent.Responses["400"].Content["application/json"].Schema.OneOf; len(oneOf) != 2 || oneOf[0].Ref != "#/components/schemas/RentableInfo" || oneOf[1].Ref != "#/components/schemas/ValidationError" {
+		t.Errorf("Expected rentBikes to answer 400 with RentableInfo or ValidationError, but received %v", rent.Responses["400"])
+	}
+	if oneOf := returnBikes.Responses["400"].Content["application/json"].Schema.OneOf; len(oneOf) != 2 || oneOf[0].Ref != "#/components/schemas/DockableInfo" || oneOf[1].Ref != "#/components/schemas/ValidationError" {
+		t.Errorf("Expected returnBikes to answer 400 with DockableInfo or ValidationError, but received %v", returnBikes.Responses["400"])
+	}
+	if returnBikes.Responses["404"].Content["application/json"].Schema.Ref != "#/components/schemas/DockableInfo" {
+		t.Errorf("Expected returnBikes to answer 404 with DockableInfo, but received %v", returnBikes.Responses["404"])
+	}
+	bulk := document.Paths["/stations/dockable"]["post"]
+	if items := bulk.RequestBody.Content["application/json"].Schema; items.MaxItems == nil || *items.MaxItems != maxBulkDockableItems || bulk.Responses["413"].Description == "" {
+		t.Errorf("Expected the bulk body to document its item cap and byte limit, but received %v", bulk)
+	}
+	if history := document.Paths["/stations/{stationid}/history"]["get"]; history.Parameters[0].Schema.Pattern != "^[0-9]+$" {
+		t.Errorf("Expected the stationid pattern from the route, but received %v", history.Parameters[0].Schema)
+	}
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	w := serveWithKey(t, "GET", "/openapi.json", "", "")
+	document := OpenAPIDocument{}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected the OpenAPI document, but received %v %s", w.Code, w.Body.String())
+	}
+	if document.OpenAPI != openAPIVersion || document.Paths["/stations"]["get"] == nil {
+		t.Errorf("Expected an OpenAPI %s document describing /stations, but received %v", openAPIVersion, document.Paths)
+	}
+
+	w = serveWithKey(t, "GET", "/docs", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"/openapi.json"`) {
+		t.Errorf("Expected Swagger UI for /openapi.json, but received %v", w.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	earthRadiusKm     = 6371.0
+	maxPlanDistanceKm = 5.0
+	maxAlternatives   = 3
+	maxSplitStations  = 5
+)
+
+// StationOption - a nearby station suggested when the requested station cannot serve every bike
+type StationOption struct {
+	ID          int     `json:"id"`
+	StationName string  `json:"stationName"`
+	StAddress1  string  `json:"stAddress1"`
+	Available   int     `json:"available"`
+	Bikes       int     `json:"bikes,omitempty"`
+	DistanceKm  float64 `json:"distanceKm"`
+}
+
+/*
+ * 	Returns the number of docks free for returning bikes
+ */
+func availableDocks(station Station) int {
+	return station.AvailableDocks
+}
+
+/*
+ * 	Returns the number of bikes free for renting
+ */
+func availableBikes(station Station) int {
+	return station.AvailableBikes
+}
+
+/*
+ * 	Returns the great-circle distance between two stations in kilometres
+ */
+func distanceKm(a, b Station) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRadians(b.Latitude - a.Latitude)
+	dLon := toRadians(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(a.Latitude))*math.Cos(toRadians(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+/*
+ * 	Finds other stations that can serve numBikes when origin cannot.
+ * 	available reports how many bikes a station can serve (docks for returns,
+ * 	bikes for rentals). Alternatives are the nearest in-service stations within
+ * 	maxPlanDistanceKm that can serve every bike on their own; test stations and
+ * 	stale stations, whose numbers cannot be trusted, are never suggested. Only when there are
+ * 	none is a split across the nearest stations returned, starting with origin
+ * 	itself if it is in service.
+ */
+func planAlternatives(stations []Station, origin Station, numBikes int, available func(Station) int) (alternatives []StationOption, split []StationOption) {
+	var nearby []StationOption
+	for _, v := range stations {
+		if v.ID == origin.ID || v.StatusValue != inServiceStatus || v.TestStation || v.Stale || available(v) <= 0 {
+			continue
+		}
+		distance := distanceKm(origin, v)
+		if distance > maxPlanDistanceKm {
+			continue
+		}
+		nearby = append(nearby, StationOption{
+			ID:          v.ID,
+			StationName: v.StationName,
+			StAddress1:  v.StAddress1,
+			Available:   available(v),
+			DistanceKm:  math.Round(distance*100) / 100,
+		})
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+
+	for _, option := range nearby {
+		if option.Available >= numBikes && len(alternatives) < maxAlternatives {
+			alternatives = append(alternatives, option)
+		}
+	}
+	if len(alternatives) > 0 {
+		return alternatives, nil
+	}
+
+	candidates := nearby
+	if origin.StatusValue == inServiceStatus && available(origin) > 0 {
+		self := StationOption{
+			ID:          origin.ID,
+			StationName: origin.StationName,
+			StAddress1:  origin.StAddress1,
+			Available:   available(origin),
+		}
+		candidates = append([]StationOption{self}, nearby...)
+	}
+	remaining := numBikes
+	for _, option := range candidates {
+		if remaining == 0 || len(split) == maxSplitStations {
+			break
+		}
+		option.Bikes = min(option.Available, remaining)
+		remaining -= option.Bikes
+		split = append(split, option)
+	}
+	if remaining > 0 {
+		return nil, nil
+	}
+	return nil, split
+}
+
+/*
+ *	Endpoint: /stations/:stationid/rent/:bikestorent
+ *
+ *	Returns boolean field denoting if the client can rent the requested number of
+ *	bikes at a station, and a message that explains why or why not. When they
+ *	cannot, nearby in-service stations with enough bikes are suggested. A count
+ *	that is not a positive number answers 400 and an unknown station 404.
+ */
+func rentBikes(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing rentBikes entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"stationid":   mux.Vars(req)["stationid"],
+			"bikestorent": mux.Vars(req)["bikestorent"],
+			"Path":        req.URL.Path,
+		},
+	)
+
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	stationID := strings.ToLower(mux.Vars(req)["stationid"])
+	numBikesToRent, numError := strconv.Atoi(mux.Vars(req)["bikestorent"])
+	if numError != nil || numBikesToRent <= 0 {
+		writeJSON(w, req, http.StatusBadRequest, RentableInfo{Message: l.Message("rent.invalidCount", nil)}, contextLogger)
+		contextLogger.Warn("Invalid number of bikes to rent")
+		return
+	}
+	stationData, ok := requestStationData(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	stations := stationData.StationBeanList
+
+	station, found := findStation(stations, stationID)
+	if !found {
+		writeJSON(w, req, http.StatusNotFound, RentableInfo{Message: l.Message("station.notFound", nil)}, contextLogger)
+		contextLogger.Warn("Station not found")
+		return
+	}
+	rentable := false
+	message := l.Message("rent.insufficient", MessageArgs{"bikes": numBikesToRent, "available": station.AvailableBikes})
+	if station.StatusValue == notInServiceStatus {
+		message = l.Message("station.notInService", MessageArgs{"name": station.StationName, "id": station.ID})
+	} else if station.StatusValue == unknownStatus {
+		message = l.Message("station.statusUnknown", MessageArgs{"name": station.StationName, "id": station.ID})
+	} else if numBikesToRent <= station.AvailableBikes {
+		rentable = true
+		message = l.Message("rent.ok", MessageArgs{"bikes": numBikesToRent, "available": station.AvailableBikes})
+	}
+
+	rentableInfo := RentableInfo{Rentable: rentable, Message: message}
+	if !rentable {
+		rentableInfo.Alternatives, rentableInfo.Split = planAlternatives(stations, station, numBikesToRent, availableBikes)
+	}
+	writeJSON(w, req, http.StatusOK, rentableInfo, contextLogger)
+}
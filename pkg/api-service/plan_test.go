@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlanAlternativesSplit(t *testing.T) {
+	stations := fixtureStationData(t).StationBeanList
+	origin := Station{}
+	for _, v := range stations {
+		if v.ID == 83 {
+			origin = v
+		}
+	}
+
+	alternatives, split := planAlternatives(stations, origin, 40, availableDocks)
+	if len(alternatives) != 0 {
+		t.Errorf("Expected no single alternative, but received %d", len(alternatives))
+	}
+	if len(split) != 2 || split[0].ID != 83 || split[0].Bikes != 21 || split[1].ID != 82 || split[1].Bikes != 19 {
+		t.Errorf("Expected a split of 21 bikes at 83 and 19 at 82, but received %+v", split)
+	}
+
+	alternatives, split = planAlternatives(stations, origin, 100, availableDocks)
+	if alternatives != nil || split != nil {
+		t.Errorf("Expected no plan when nearby stations cannot take every bike, but received %+v and %+v", alternatives, split)
+	}
+}
+
+func TestRentBikesRentable(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/83/rent/40?pretty=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `{
+    "rentable": true,
+    "message": "You are able to rent all 40 bikes. There are 40 available bikes."
+}`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestRentBikesNotRentable(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/82/rent/5?pretty=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `{
+    "rentable": false,
+    "message": "You cannot rent all 5 bikes. There are 0 available bikes.",
+    "alternatives": [
+        {
+            "id": 79,
+            "stationName": "Franklin St \u0026 W Broadway",
+            "stAddress1": "Franklin St \u0026 W Broadway",
+            "available": 33,
+            "distanceKm": 1.04
+        },
+        {
+            "id": 116,
+            "stationName": "W 17 St \u0026 8 Ave",
+            "stAddress1": "W 17 St \u0026 8 Ave",
+            "available": 19,
+            "distanceKm": 3.4
+        },
+        {
+            "id": 83,
+            "stationName": "Atlantic Ave \u0026 Fort Greene Pl",
+            "stAddress1": "Atlantic Ave \u0026 Fort Greene Pl",
+            "available": 40,
+            "distanceKm": 3.65
+        }
+    ]
+}`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestRentBikesErrors(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	tests := map[string]int{
+		"/stations/83/rent/0":    http.StatusBadRequest,
+		"/stations/83/rent/-3":   http.StatusBadRequest,
+		"/stations/83/rent/many": http.StatusBadRequest,
+		"/stations/1/rent/2":     http.StatusNotFound,
+	}
+	for path, code := range tests {
+		w := serveWithKey(t, "GET", path, "", "")
+		rentableInfo := RentableInfo{}
+		if err := json.Unmarshal(w.Body.Bytes(), &rentableInfo); err != nil || w.Code != code || rentableInfo.Rentable || rentableInfo.Message == "" {
+			t.Errorf("%s: Expected %v with a JSON message, but received %v %s", path, code, w.Code, w.Body.String())
+		}
+	}
+}
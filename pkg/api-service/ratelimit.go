@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitSweepEvery - how many takes the in-process store handles between sweeps of idle buckets
+const rateLimitSweepEvery = 1024
+
+// RateLimit - a token bucket refilling Requests tokens every Per, holding at most Burst
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// RateLimitResult - the outcome of taking a token
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// RateLimitStore - holds token buckets by key. The in-process store can be
+// replaced by a shared one when several instances serve the same clients.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) RateLimitResult
+}
+
+// tokenBucket - the tokens left in one bucket when it was last taken from
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// MemoryRateLimitStore - token buckets in a map, swept of full buckets now and then
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+// RateLimiter - negroni middleware limiting each client per route
+type RateLimiter struct {
+	store          RateLimitStore
+	router         *mux.Router
+	defaultLimit   RateLimit
+	routeLimits    map[string]RateLimit
+	trustedProxies []*net.IPNet
+}
+
+/*
+ * 	Creates an empty in-process store
+ */
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+/*
+ * 	Returns the limit's refill rate in tokens per second
+ */
+func (limit RateLimit) rate() float64 {
+	return float64(limit.Requests) / limit.Per.Seconds()
+}
+
+/*
+ * 	Returns the tokens in bucket at now after refilling
+ */
+func (bucket *tokenBucket) refill(now time.Time) float64 {
+	elapsed := now.Sub(bucket.last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(bucket.limit.Burst), bucket.tokens+elapsed*bucket.limit.rate())
+}
+
+/*
+ * 	Takes a token from key's bucket, creating a full bucket for new keys
+ */
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) RateLimitResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.takes++; s.takes%rateLimitSweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok || bucket.limit != limit {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens, bucket.last = bucket.refill(now), now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) / limit.rate() * float64(time.Second))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((float64(limit.Burst) - bucket.tokens) / limit.rate() * float64(time.Second))
+	return result
+}
+
+/*
+ * 	Removes buckets that have refilled completely, which behave like new ones
+ */
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if bucket.refill(now) >= float64(bucket.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+/*
+ * 	Parses a limit written as requests/period:burst, such as 10/s:20 or
+ * 	100/1m:100. The burst defaults to the number of requests.
+ */
+func ParseRateLimit(value string) (RateLimit, error) {
+	limit := RateLimit{}
+	rateInfo, burstInfo := value, ""
+	if colon := strings.LastIndex(value, ":"); colon >= 0 {
+		rateInfo, burstInfo = value[:colon], value[colon+1:]
+	}
+	parts := strings.SplitN(rateInfo, "/", 2)
+	if len(parts) != 2 {
+		return limit, fmt.Errorf("rate limit %q is not requests/period", value)
+	}
+	var err error
+	if limit.Requests, err = strconv.Atoi(parts[0]); err != nil || limit.Requests <= 0 {
+		return limit, fmt.Errorf("rate limit %q needs a positive number of requests", value)
+	}
+	period := parts[1]
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	if limit.Per, err = time.ParseDuration(period); err != nil || limit.Per <= 0 {
+		return limit, fmt.Errorf("rate limit %q needs a positive period", value)
+	}
+	limit.Burst = limit.Requests
+	if burstInfo != "" {
+		if limit.Burst, err = strconv.Atoi(burstInfo); err != nil || limit.Burst <= 0 {
+			return limit, fmt.Errorf("rate limit %q needs a positive burst", value)
+		}
+	}
+	return limit, nil
+}
+
+/*
+ * 	Parses per-route limits written as template=limit pairs separated by
+ * 	commas, such as /stations/{searchstring}=2/s:5,/stations/dockable=1/s
+ */
+func ParseRouteRateLimits(value string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		equals := strings.LastIndex(entry, "=")
+		if equals < 0 {
+			return nil, fmt.Errorf("route rate limit %q is not template=limit", entry)
+		}
+		limit, err := ParseRateLimit(entry[equals+1:])
+		if err != nil {
+			return nil, err
+		}
+		limits[entry[:equals]] = limit
+	}
+	return limits, nil
+}
+
+/*
+ * 	Parses a comma-separated list of proxy addresses or CIDR ranges
+ */
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+/*
+ * 	Creates a limiter applying defaultLimit to routes of router that have no
+ * 	entry in routeLimits, which is keyed by path template
+ */
+func NewRateLimiter(store RateLimitStore, router *mux.Router, defaultLimit RateLimit, routeLimits map[string]RateLimit, trustedProxies []*net.IPNet) *RateLimiter {
+	return &RateLimiter{store: store, router: router, defaultLimit: defaultLimit, routeLimits: routeLimits, trustedProxies: trustedProxies}
+}
+
+/*
+ * 	Returns whether ip belongs to a trusted proxy
+ */
+func (rl *RateLimiter) trusted(ip net.IP) bool {
+	for _, network := range rl.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * 	Returns the client's address. X-Forwarded-For is only believed when the
+ * 	request comes from a trusted proxy, and then only up to the first address
+ * 	from the right that is not itself a trusted proxy.
+ */
+func (rl *RateLimiter) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !rl.trusted(ip) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !rl.trusted(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+/*
+ * 	Returns who the request is limited as: the subject of a verified JWT, its
+ * 	API key if the key is valid, otherwise its client address. Unchecked keys
+ * 	are ignored so clients cannot get fresh buckets by sending made-up keys.
+ */
+func (rl *RateLimiter) identity(req *http.Request) string {
+	if principal, ok := principalFrom(req); ok {
+		return "sub:" + principal.Issuer + " " + principal.Subject
+	}
+	if apiKeys != nil {
+		if key, ok := apiKeys.Authenticate(requestCredential(req)); ok {
+			return "key:" + key.ID
+		}
+	}
+	return "ip:" + rl.clientIP(req)
+}
+
+/*
+ * 	Returns the matched route's path template and limit. Requests that match
+ * 	no route share one bucket per client.
+ */
+func (rl *RateLimiter) routeLimit(req *http.Request) (string, RateLimit) {
+	match := mux.RouteMatch{}
+	if rl.router == nil || !rl.router.Match(req, &match) || match.Route == nil {
+		return "", rl.defaultLimit
+	}
+	template, _ := match.Route.GetPathTemplate()
+	if limit, ok := rl.routeLimits[template]; ok {
+		return template, limit
+	}
+	return template, rl.defaultLimit
+}
+
+/*
+ * 	Takes a token for the client and route, setting RateLimit-Limit,
+ * 	RateLimit-Remaining and RateLimit-Reset, and answers 429 with Retry-After
+ * 	when the bucket is empty
+ */
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	template, limit := rl.routeLimit(req)
+	identity := rl.identity(req)
+	result := rl.store.Take(identity+" "+template, limit, time.Now())
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		l := newLocalizer(req)
+		l.SetHeader(w)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, l.Message("rateLimit.exceeded", MessageArgs{"seconds": retryAfter}), http.StatusTooManyRequests)
+		log.WithFields(log.Fields{"client": identity, "route": template}).Warn("Rate limit exceeded")
+		return
+	}
+	next(w, req)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/urfave/negroni"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 2, Per: time.Second, Burst: 3}
+	start := time.Date(2016, 1, 22, 16, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if result := store.Take("a", limit, start); !result.Allowed || result.Remaining != 2-i {
+			t.Errorf("Take %d: expected to be allowed with %d remaining, but received %+v", i+1, 2-i, result)
+		}
+	}
+	result := store.Take("a", limit, start)
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond || result.Reset != 1500*time.Millisecond {
+		t.Errorf("Expected an empty bucket to retry after 500ms, but received %+v", result)
+	}
+	if result := store.Take("b", limit, start); !result.Allowed {
+		t.Errorf("Expected another key to have its own bucket")
+	}
+	if result := store.Take("a", limit, start.Add(500*time.Millisecond)); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected one token to refill after 500ms, but received %+v", result)
+	}
+
+	store.sweep(start.Add(time.Minute))
+	if len(store.buckets) != 0 {
+		t.Errorf("Expected refilled buckets to be swept, but %d remain", len(store.buckets))
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := map[string]RateLimit{
+		"10/s:20":  {Requests: 10, Per: time.Second, Burst: 20},
+		"100/1m":   {Requests: 100, Per: time.Minute, Burst: 100},
+		"5/30s:10": {Requests: 5, Per: 30 * time.Second, Burst: 10},
+	}
+	for value, expected := range tests {
+		if limit, err := ParseRateLimit(value); err != nil || limit != expected {
+			t.Errorf("Expected %+v for %s, but received %+v (%v)", expected, value, limit, err)
+		}
+	}
+	for _, value := range []string{"10", "0/s", "10/x", "10/s:0", "ten/s"} {
+		if _, err := ParseRateLimit(value); err == nil {
+			t.Errorf("Expected an error for %s", value)
+		}
+	}
+
+	limits, err := ParseRouteRateLimits("/stations/{stationid:[0-9]+}/history=1/s:2, /stations=5/s")
+	if err != nil || limits["/stations/{stationid:[0-9]+}/history"].Burst != 2 || limits["/stations"].Requests != 5 {
+		t.Errorf("Expected two route limits, but received %+v (%v)", limits, err)
+	}
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), nil, RateLimit{}, nil, proxies)
+	tests := []struct {
+		remoteAddr, forwardedFor, expected string
+	}{
+		{"203.0.113.5:1234", "198.51.100.1", "203.0.113.5"},
+		{"10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:1234", "1.1.1.1, 198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"10.1.2.3:1234", "", "10.1.2.3"},
+		{"10.1.2.3:1234", "garbage, 10.0.0.7", "10.0.0.7"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/stations", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if ip := limiter.clientIP(req); ip != test.expected {
+			t.Errorf("Expected %s for %s via %q, but received %s", test.expected, test.remoteAddr, test.forwardedFor, ip)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	router := Router()
+	limits := map[string]RateLimit{"/stations/{searchstring}": {Requests: 1, Per: time.Minute, Burst: 1}}
+	n := negroni.New(NewRateLimiter(NewMemoryRateLimitStore(), router, RateLimit{Requests: 10, Per: time.Second, Burst: 2}, limits, nil))
+	n.UseHandler(router)
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/stations/Atlantic", "203.0.113.5:1234")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Errorf("Expected the route's own limit in the headers, but received %v %v", w.Code, w.Header())
+	}
+	w = serve("/stations/Franklin", "203.0.113.5:1234")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 429 with Retry-After 60, but received %v %v", w.Code, w.Header())
+	}
+	if w := serve("/stations", "203.0.113.5:1234"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" {
+		t.Errorf("Expected other routes to have their own bucket, but received %v", w.Code)
+	}
+	if w := serve("/stations/Atlantic", "203.0.113.6:1234"); w.Code != http.StatusOK {
+		t.Errorf("Expected other clients to have their own bucket, but received %v", w.Code)
+	}
+}
+
+func TestRateLimiterByAPIKey(t *testing.T) {
+	enableTestAPIKeys(t)
+	mockStationFeed(allStationsJSON)
+	reader := issueTestKey(t, `{"name":"dashboard","scopes":["read:stations"]}`)
+	router := Router()
+	n := negroni.New(NewRateLimiter(NewMemoryRateLimitStore(), router, RateLimit{Requests: 1, Per: time.Minute, Burst: 1}, nil, nil))
+	n.UseHandler(router)
+	serve := func(remoteAddr, credential string) int {
+		req, _ := http.NewRequest("GET", "/stations", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-API-Key", credential)
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := serve("203.0.113.5:1234", reader.Key); status != http.StatusOK {
+		t.Errorf("Expected the first request to be allowed, but received %v", status)
+	}
+	if status := serve("203.0.113.6:1234", reader.Key); status != http.StatusTooManyRequests {
+		t.Errorf("Expected the key to be limited from any address, but received %v", status)
+	}
+	if status := serve("203.0.113.7:1234", "sk_madeup"); status != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown key to be limited by address and then rejected, but received %v", status)
+	}
+	if status := serve("203.0.113.7:1234", "sk_another"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected made-up keys to share their address's bucket, but received %v", status)
+	}
+}
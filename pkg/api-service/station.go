@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -14,30 +15,118 @@ import (
 )
 
 const (
-	itemsPerPage = 20
+	itemsPerPage    = 20
+	maxSearchLength = 64
+	stationFeedURL  = "https://feeds.citibikenyc.com/stations/stations.json"
+
+	// inServiceStatus - the station rents and accepts bikes
+	inServiceStatus StationStatus = "In Service"
+	// notInServiceStatus - the station neither rents nor accepts bikes
+	notInServiceStatus StationStatus = "Not In Service"
+	// unknownStatus - the feed sent a statusValue this service does not know
+	unknownStatus StationStatus = "Unknown"
 )
 
-// Station - contains only the relevant fields for the endpoints
+// Station - a station with every field the upstream feed describes. Handlers
+// answer with StationResponse rather than encoding it directly.
 type Station struct {
-	ID             int    `json:"id,omitempty"`
-	StationName    string `json:"stationName"`
-	AvailableDocks int    `json:"availableDocks,omitempty"`
-	TotalDocks     int    `json:"totalDocks"`
-	StatusValue    string `json:"statusValue,omitempty"`
-	AvailableBikes int    `json:"availableBikes"`
-	StAddress1     string `json:"stAddress1"`
+	ID                    int           `json:"id"`
+	StationName           string        `json:"stationName"`
+	AvailableDocks        int           `json:"availableDocks"`
+	TotalDocks            int           `json:"totalDocks"`
+	Latitude              float64       `json:"latitude"`
+	Longitude             float64       `json:"longitude"`
+	StatusValue           StationStatus `json:"statusValue"`
+	StatusKey             int           `json:"statusKey"`
+	AvailableBikes        int           `json:"availableBikes"`
+	StAddress1            string        `json:"stAddress1"`
+	StAddress2            string        `json:"stAddress2"`
+	City                  string        `json:"city"`
+	PostalCode            string        `json:"postalCode"`
+	Location              string        `json:"location"`
+	Altitude              string        `json:"altitude"`
+	TestStation           bool          `json:"testStation"`
+	LastCommunicationTime time.Time     `json:"-"`
+	LandMark              string        `json:"landMark"`
+
+	// Stale - the station last communicated more than -stale-after before the feed's executionTime
+	Stale bool `json:"-"`
 }
 
+// StationStatus - whether a station is accepting rentals and returns, as the feed's statusValue
+type StationStatus string
+
 // StationData - metadata information that follows the external JSON format
 type StationData struct {
 	ExecutionTime   string    `json:"executionTime"`
 	StationBeanList []Station `json:"stationBeanList"`
 }
 
+// DockableReason - machine-readable explanation of a dockable answer
+type DockableReason string
+
+const (
+	// ReasonOK - every bike can be returned
+	ReasonOK DockableReason = "OK"
+	// ReasonInsufficientDocks - the station has fewer available docks than bikes
+	ReasonInsufficientDocks DockableReason = "INSUFFICIENT_DOCKS"
+	// ReasonNotInService - the station is not accepting returns
+	ReasonNotInService DockableReason = "NOT_IN_SERVICE"
+	// ReasonStationUnknown - no station has the requested id
+	ReasonStationUnknown DockableReason = "STATION_UNKNOWN"
+	// ReasonInvalidCount - the number of bikes is not a positive number
+	ReasonInvalidCount DockableReason = "INVALID_COUNT"
+	// ReasonStatusUnknown - the feed sent a status this service does not know, so
+	// the station may not be accepting returns
+	ReasonStatusUnknown DockableReason = "STATUS_UNKNOWN"
+)
+
 // DockableInfo - contains JSON fields needed for endpoint "/dockable/:stationid/:bikestoreturn"
 type DockableInfo struct {
-	Dockable bool   `json:"dockable"`
-	Message  string `json:"message"`
+	Dockable       bool            `json:"dockable"`
+	Message        string          `json:"message"`
+	Reason         DockableReason  `json:"reason"`
+	StationStatus  string          `json:"stationStatus,omitempty"`
+	AvailableDocks int             `json:"availableDocks"`
+	Requested      int             `json:"requested"`
+	Shortfall      int             `json:"shortfall"`
+	Warning        string          `json:"warning,omitempty"`
+	Forecast       *Forecast       `json:"forecast,omitempty"`
+	Alternatives   []StationOption `json:"alternatives,omitempty"`
+	Split          []StationOption `json:"split,omitempty"`
+}
+
+// RentableInfo - contains JSON fields needed for endpoint "/stations/:stationid/rent/:bikestorent"
+type RentableInfo struct {
+	Rentable     bool            `json:"rentable"`
+	Message      string          `json:"message"`
+	Alternatives []StationOption `json:"alternatives,omitempty"`
+	Split        []StationOption `json:"split,omitempty"`
+}
+
+/*
+ * 	Decodes a station from the feed, reading lastCommunicationTime as New York
+ * 	time. A missing or unreadable time is left zero, meaning unknown, and a
+ * 	statusValue other than In Service or Not In Service becomes Unknown,
+ * 	rather than failing the whole feed.
+ */
+func (s *Station) UnmarshalJSON(data []byte) error {
+	type feedStation Station
+	raw := struct {
+		*feedStation
+		LastCommunicationTime string `json:"lastCommunicationTime"`
+	}{feedStation: (*feedStation)(s)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if s.StatusValue != inServiceStatus && s.StatusValue != notInServiceStatus {
+		s.StatusValue = unknownStatus
+	}
+	s.LastCommunicationTime = time.Time{}
+	if communicated, err := parseExecutionTime(raw.LastCommunicationTime); err == nil {
+		s.LastCommunicationTime = communicated
+	}
+	return nil
 }
 
 /*
@@ -51,38 +140,104 @@ func min(a, b int) int {
 }
 
 /*
- * 	Retrieves external JSON and unmarshals the data into []Station
+ * 	Returns maximum of two ints
  */
-func getStations() []Station {
-	urlEndpoint := "https://feeds.citibikenyc.com/stations/stations.json"
-	log.SetFormatter(&log.JSONFormatter{})
-	contextLogger := log.WithFields(
-		log.Fields{
-			"urlEndpoint": urlEndpoint,
-		},
-	)
-	stationReq, urlErr := http.NewRequest(http.MethodGet, urlEndpoint, nil)
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+/*
+ * 	Returns the shared snapshot for a REST handler, answering 502 and returning
+ * 	false when the feed cannot be fetched
+ */
+func requestStationData(w http.ResponseWriter, req *http.Request, contextLogger *log.Entry) (StationData, bool) {
+	stationData, err := store.Snapshot()
+	if err != nil {
+		l := newLocalizer(req)
+		l.SetHeader(w)
+		contextLogger.Error("Error retrieving stations", err)
+		http.Error(w, l.Message("stations.unavailable", nil), http.StatusBadGateway)
+		return StationData{}, false
+	}
+	return stationData, true
+}
+
+/*
+ * 	Retrieves external JSON and unmarshals it, returning any failure to the caller
+ */
+func fetchStationData() (StationData, error) {
+	stationReq, urlErr := http.NewRequest(http.MethodGet, stationFeedURL, nil)
 	if urlErr != nil {
-		contextLogger.Fatal(urlErr)
+		return StationData{}, urlErr
 	}
 	res, getErr := Client.Do(stationReq)
 	if getErr != nil {
-		contextLogger.Fatal(getErr)
+		return StationData{}, getErr
 	}
 	if res.Body != nil {
 		defer res.Body.Close()
 	}
 	body, readErr := ioutil.ReadAll(res.Body)
 	if readErr != nil {
-		contextLogger.Fatal(readErr)
+		return StationData{}, readErr
 	}
 	stationData := StationData{}
 	jsonErr := json.Unmarshal(body, &stationData)
 	if jsonErr != nil {
-		contextLogger.Fatalf("Unable to unmarshal JSON value: %q, error: %s", string(body), jsonErr.Error())
+		return StationData{}, fmt.Errorf("Unable to unmarshal JSON value: %q, error: %s", string(body), jsonErr.Error())
 	}
-	stations := stationData.StationBeanList
-	return stations
+	stationData.markStale(*staleAfter)
+	return stationData, nil
+}
+
+/*
+ * 	Flags the stations whose last communication is more than threshold older
+ * 	than the feed's executionTime. Stations that never reported a time are not
+ * 	flagged, and nothing is when executionTime cannot be read.
+ */
+func (data *StationData) markStale(threshold time.Duration) {
+	executionTime, err := parseExecutionTime(data.ExecutionTime)
+	if err != nil {
+		return
+	}
+	for i := range data.StationBeanList {
+		station := &data.StationBeanList[i]
+		station.Stale = !station.LastCommunicationTime.IsZero() && executionTime.Sub(station.LastCommunicationTime) > threshold
+	}
+}
+
+// stationFilter - which hidden kinds of station a request asked to see
+type stationFilter struct {
+	includeTest  bool
+	includeStale bool
+}
+
+/*
+ * 	Reads ?include_test= and ?include_stale= from a request. Values that are
+ * 	not booleans count as false.
+ */
+func requestStationFilter(req *http.Request) stationFilter {
+	includeTest, _ := strconv.ParseBool(req.URL.Query().Get("include_test"))
+	includeStale, _ := strconv.ParseBool(req.URL.Query().Get("include_stale"))
+	return stationFilter{includeTest: includeTest, includeStale: includeStale}
+}
+
+/*
+ * 	Returns the stations the filter shows: test and stale stations are left out
+ * 	unless it includes them
+ */
+func (f stationFilter) apply(stations []Station) []Station {
+	var shown []Station
+	for _, v := range stations {
+		if (v.TestStation && !f.includeTest) || (v.Stale && !f.includeStale) {
+			continue
+		}
+		shown = append(shown, v)
+	}
+	return shown
 }
 
 /*
@@ -107,28 +262,15 @@ func getStartAndEndIndices(numStations int, pageInfo string) (start int, end int
 	return startResults, endResults
 }
 
-/*
- * 	Updates []Station array to only contain relevant fields for displaying
- * 	Relevant fields: station name, address, # bikes available, total # of docks.
- */
-func buildStationArry(stations []Station, startResults int, endResults int) []Station {
-	var stationInfo []Station
-	for i := startResults; i < endResults; i++ {
-		station := stations[i]
-		// remove these fields from view in JSON
-		station.AvailableDocks = 0
-		station.ID = 0
-		station.StatusValue = ""
-		stationInfo = append(stationInfo, station)
-	}
-	return stationInfo
-}
-
 /*
  *	Endpoint: /stations
  *
  * 	Return an array of station objects where each object includes the
  * 	station name, address, # bikes available, total # of docks.
+ * 	?fields= picks other fields instead, such as ?fields=id,availableDocks, and
+ * 	?format=csv or ?format=ndjson (or the Accept header) exports them instead.
+ * 	Test stations and stale stations are left out unless ?include_test=true or
+ * 	?include_stale=true asks for them.
  */
 func getAllStations(w http.ResponseWriter, req *http.Request) {
 	log.SetFormatter(&log.JSONFormatter{})
@@ -138,23 +280,32 @@ func getAllStations(w http.ResponseWriter, req *http.Request) {
 			"Path": req.URL.Path,
 		},
 	)
-	stations := getStations()
+	fields, ok := requestFieldSet(w, req, "/stations", contextLogger)
+	if !ok {
+		return
+	}
+	format, ok := requestFormat(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	stationData, ok := requestStationData(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	stations := requestStationFilter(req).apply(stationData.StationBeanList)
 	pageInfo := req.URL.Query().Get("page")
 	startResults, endResults := getStartAndEndIndices(len(stations), pageInfo)
 
-	var stationInfo []Station = buildStationArry(stations, startResults, endResults)
-	stationMarshal, marshalErr := json.MarshalIndent(stationInfo, "", "    ")
-	if marshalErr != nil {
-		contextLogger.Fatal("Error marshaling struct to JSON", marshalErr)
-	}
-	fmt.Fprint(w, string(stationMarshal))
+	stationInfo := buildStationResponses(stations, startResults, endResults, fields)
+	writeStationList(w, req, format, "stations", stationData.ExecutionTime, stationInfo, fields, contextLogger)
 }
 
 /*
  *	Endpoint: /stations/in-service
  *
  * 	Return only those stations that ARE in service
- * 	Users can also paginate results
+ * 	Users can also paginate results, pick fields with ?fields=, export
+ * 	them with ?format= and include test or stale stations like /stations
  */
 func getInServiceStations(w http.ResponseWriter, req *http.Request) {
 	log.SetFormatter(&log.JSONFormatter{})
@@ -164,10 +315,22 @@ func getInServiceStations(w http.ResponseWriter, req *http.Request) {
 			"Path": req.URL.Path,
 		},
 	)
-	allStations := getStations()
+	fields, ok := requestFieldSet(w, req, "/stations/in-service", contextLogger)
+	if !ok {
+		return
+	}
+	format, ok := requestFormat(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	stationData, ok := requestStationData(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	allStations := requestStationFilter(req).apply(stationData.StationBeanList)
 	var inServiceStations []Station
 	for _, v := range allStations {
-		if v.StatusValue == "In Service" {
+		if v.StatusValue == inServiceStatus {
 			inServiceStations = append(inServiceStations, v)
 		}
 	}
@@ -176,19 +339,16 @@ func getInServiceStations(w http.ResponseWriter, req *http.Request) {
 	pageInfo := req.URL.Query().Get("page")
 	startResults, endResults := getStartAndEndIndices(len(stations), pageInfo)
 
-	var stationInfo []Station = buildStationArry(stations, startResults, endResults)
-	stationMarshal, marshalErr := json.MarshalIndent(stationInfo, "", "    ")
-	if marshalErr != nil {
-		contextLogger.Fatal("Error marshaling struct to JSON", marshalErr)
-	}
-	fmt.Fprint(w, string(stationMarshal))
+	stationInfo := buildStationResponses(stations, startResults, endResults, fields)
+	writeStationList(w, req, format, "stations-in-service", stationData.ExecutionTime, stationInfo, fields, contextLogger)
 }
 
 /*
  *	Endpoint: /stations/not-in-service
  *
  * 	Return only those stations that are NOT in service
- * 	Users can also paginate results
+ * 	Users can also paginate results, pick fields with ?fields=, export
+ * 	them with ?format= and include test or stale stations like /stations
  */
 func getNotInServiceStations(w http.ResponseWriter, req *http.Request) {
 	log.SetFormatter(&log.JSONFormatter{})
@@ -198,10 +358,22 @@ func getNotInServiceStations(w http.ResponseWriter, req *http.Request) {
 			"Path": req.URL.Path,
 		},
 	)
-	allStations := getStations()
+	fields, ok := requestFieldSet(w, req, "/stations/not-in-service", contextLogger)
+	if !ok {
+		return
+	}
+	format, ok := requestFormat(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	stationData, ok := requestStationData(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	allStations := requestStationFilter(req).apply(stationData.StationBeanList)
 	var notInServiceStations []Station
 	for _, v := range allStations {
-		if v.StatusValue == "Not In Service" {
+		if v.StatusValue == notInServiceStatus {
 			notInServiceStations = append(notInServiceStations, v)
 		}
 	}
@@ -210,12 +382,8 @@ func getNotInServiceStations(w http.ResponseWriter, req *http.Request) {
 	pageInfo := req.URL.Query().Get("page")
 	startResults, endResults := getStartAndEndIndices(len(stations), pageInfo)
 
-	var stationInfo []Station = buildStationArry(stations, startResults, endResults)
-	stationMarshal, marshalErr := json.MarshalIndent(stationInfo, "", "    ")
-	if marshalErr != nil {
-		contextLogger.Fatal("Error marshaling struct to JSON", marshalErr)
-	}
-	fmt.Fprint(w, string(stationMarshal))
+	stationInfo := buildStationResponses(stations, startResults, endResults, fields)
+	writeStationList(w, req, format, "stations-not-in-service", stationData.ExecutionTime, stationInfo, fields, contextLogger)
 }
 
 /*
@@ -223,6 +391,8 @@ func getNotInServiceStations(w http.ResponseWriter, req *http.Request) {
  *
  * 	Performs a case-insensitive search through both the station name (stationName)
  * 	and the street address (stAddress1) fields, returns matching results
+ * 	with the fields picked by ?fields= in the format picked by ?format=.
+ * 	Test and stale stations only match with ?include_test= and ?include_stale=.
  */
 func searchStations(w http.ResponseWriter, req *http.Request) {
 	log.SetFormatter(&log.JSONFormatter{})
@@ -233,39 +403,121 @@ func searchStations(w http.ResponseWriter, req *http.Request) {
 			"Path":         req.URL.Path,
 		},
 	)
+	fields, ok := requestFieldSet(w, req, "/stations/{searchstring}", contextLogger)
+	if !ok {
+		return
+	}
+	format, ok := requestFormat(w, req, contextLogger)
+	if !ok {
+		return
+	}
 
-	allStations := getStations()
-	var matchingStations []Station
-	searchstring := strings.ToLower(mux.Vars(req)["searchstring"])
+	stationData, ok := requestStationData(w, req, contextLogger)
+	if !ok {
+		return
+	}
+	stations := matchStations(requestStationFilter(req).apply(stationData.StationBeanList), mux.Vars(req)["searchstring"])
+	if stations == nil && format == formatJSON {
+		l := newLocalizer(req)
+		l.SetHeader(w)
+		fmt.Fprint(w, l.Message("search.noResults", nil))
+		return
+	}
 
-	for _, v := range allStations {
+	startResults := 0
+	endResults := len(stations)
+
+	stationInfo := buildStationResponses(stations, startResults, endResults, fields)
+	writeStationList(w, req, format, "stations-search", stationData.ExecutionTime, stationInfo, fields, contextLogger)
+}
+
+/*
+ * 	Returns the stations whose name or street address contains searchstring,
+ * 	ignoring case
+ */
+func matchStations(stations []Station, searchstring string) []Station {
+	searchstring = strings.ToLower(searchstring)
+	var matchingStations []Station
+	for _, v := range stations {
 		if strings.Contains(strings.ToLower(v.StAddress1), searchstring) || strings.Contains(strings.ToLower(v.StationName), searchstring) {
 			matchingStations = append(matchingStations, v)
 		}
 	}
+	return matchingStations
+}
 
-	stations := matchingStations
-	if stations == nil {
-		fmt.Fprint(w, "No results found. Please try another search.")
-		return
+/*
+ * 	Looks up a station by its id as given in a request
+ */
+func findStation(stations []Station, stationID string) (Station, bool) {
+	for _, v := range stations {
+		if strconv.Itoa(v.ID) == stationID {
+			return v, true
+		}
 	}
+	return Station{}, false
+}
 
-	startResults := 0
-	endResults := len(stations)
+/*
+ * 	Decides whether numBikes can be returned to station, explaining why or why not
+ * 	and suggesting nearby stations from the same snapshot when they cannot.
+ */
+func checkDockable(stations []Station, station Station, numBikes int, l localizer) DockableInfo {
+	dockableInfo := DockableInfo{
+		Reason:         ReasonInsufficientDocks,
+		Message:        l.Message("dockable.insufficient", MessageArgs{"bikes": numBikes, "docks": station.AvailableDocks}),
+		StationStatus:  string(station.StatusValue),
+		AvailableDocks: station.AvailableDocks,
+		Requested:      numBikes,
+		Shortfall:      numBikes - station.AvailableDocks,
+	}
+	if station.StatusValue == notInServiceStatus {
+		dockableInfo.Reason = ReasonNotInService
+		dockableInfo.Message = l.Message("station.notInService", MessageArgs{"name": station.StationName, "id": station.ID})
+		dockableInfo.Shortfall = numBikes
+	} else if station.StatusValue == unknownStatus {
+		dockableInfo.Reason = ReasonStatusUnknown
+		dockableInfo.Message = l.Message("station.statusUnknown", MessageArgs{"name": station.StationName, "id": station.ID})
+		dockableInfo.Shortfall = numBikes
+	} else if numBikes <= station.AvailableDocks {
+		dockableInfo.Dockable = true
+		dockableInfo.Reason = ReasonOK
+		dockableInfo.Message = l.Message("dockable.ok", MessageArgs{"bikes": numBikes, "docks": station.AvailableDocks})
+		dockableInfo.Shortfall = 0
+	}
+
+	if station.Stale {
+		dockableInfo.Warning = l.Message("dockable.stale", MessageArgs{"time": formatFeedTime(station.LastCommunicationTime)})
+	}
 
-	var stationInfo []Station = buildStationArry(stations, startResults, endResults)
-	stationMarshal, marshalErr := json.MarshalIndent(stationInfo, "", "    ")
-	if marshalErr != nil {
-		contextLogger.Fatal("Error marshaling struct to JSON", marshalErr)
+	if !dockableInfo.Dockable {
+		dockableInfo.Alternatives, dockableInfo.Split = planAlternatives(stations, station, numBikes, availableDocks)
 	}
-	fmt.Fprint(w, string(stationMarshal))
+	return dockableInfo
+}
+
+/*
+ * 	Builds the answer for a request that could not be checked against any station
+ */
+func dockableError(reason DockableReason, numBikes int, l localizer) DockableInfo {
+	message := l.Message("station.notFound", nil)
+	if reason == ReasonInvalidCount {
+		message = l.Message("dockable.invalidCount", nil)
+	}
+	return DockableInfo{Reason: reason, Message: message, Requested: numBikes}
 }
 
 /*
  *	Endpoint: /dockable/:stationid/:bikestoreturn
  *
  *	Returns boolean field denoting if the client can return his or her bike(s),
- *	and a message that explains why or why not.
+ *	a reason code with the numbers behind it, and a message that explains why or
+ *	why not. When the bikes cannot be returned, nearby in-service stations that
+ *	can take them are suggested. With ?arrival= the answer uses the number of
+ *	docks forecast for that time instead of the current number. A warning is
+ *	added when the station has stopped reporting and its numbers may be stale.
+ *	Like the rent check, a count that is not a positive number answers 400 and
+ *	an unknown station 404, with the reason in the body.
  */
 func returnBikes(w http.ResponseWriter, req *http.Request) {
 	log.SetFormatter(&log.JSONFormatter{})
@@ -278,41 +530,51 @@ func returnBikes(w http.ResponseWriter, req *http.Request) {
 		},
 	)
 
-	stationID := strings.ToLower(mux.Vars(req)["stationid"])
-	numBikesToReturn, numError := strconv.Atoi(mux.Vars(req)["bikestoreturn"])
-	if numError != nil {
-		invalidNumberMessage := "Invalid value for number of bikes to return. Please enter a valid number."
-		fmt.Fprint(w, invalidNumberMessage)
-		contextLogger.Error(invalidNumberMessage, numError)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	arrival, arrivalErr := parseQueryTime(req.URL.Query().Get("arrival"), time.Time{})
+	if arrivalErr != nil || arrival.Sub(time.Now()) > maxForecastHorizon {
+		http.Error(w, l.Message("forecast.invalidTime", nil), http.StatusBadRequest)
+		contextLogger.Warn("Invalid arrival time")
 		return
 	}
-	stations := getStations()
-
-	station := Station{}
-	for _, v := range stations {
-		if strconv.Itoa(v.ID) == stationID {
-			station = v
-			break
-		}
-	}
-	if strconv.Itoa(station.ID) == "" || station.ID == 0 {
-		stationNotFoundMessage := "Station not found. Please enter a valid station id."
-		fmt.Fprint(w, stationNotFoundMessage)
-		contextLogger.Warn(stationNotFoundMessage)
+	if !arrival.IsZero() && !historyEnabled(w, l) {
 		return
 	}
-	dockable := false
-	message := fmt.Sprintf("You cannot return all %d of your bikes. There are %d available docks.", numBikesToReturn, station.AvailableDocks)
-	if station.StatusValue == "Not In Service" {
-		message = fmt.Sprintf("Station %s with ID %d is Not In Service. Please choose an In Service station.", station.StationName, station.ID)
-	} else if numBikesToReturn <= station.AvailableDocks {
-		dockable = true
-		message = fmt.Sprintf("You are able to return all %d of your bikes. There are %d available docks.", numBikesToReturn, station.AvailableDocks)
-	}
 
-	dockableMarshal, err := json.MarshalIndent(DockableInfo{Dockable: dockable, Message: message}, "", "    ")
-	if err != nil {
-		contextLogger.Fatal("Error marshaling struct to JSON", err)
+	stationID := strings.ToLower(mux.Vars(req)["stationid"])
+	numBikesToReturn, numError := strconv.Atoi(mux.Vars(req)["bikestoreturn"])
+	var dockableInfo DockableInfo
+	status := http.StatusOK
+	if numError != nil || numBikesToReturn <= 0 {
+		dockableInfo = dockableError(ReasonInvalidCount, numBikesToReturn, l)
+		status = http.StatusBadRequest
+		contextLogger.Error("Invalid number of bikes to return", numError)
+	} else {
+		stationData, ok := requestStationData(w, req, contextLogger)
+		if !ok {
+			return
+		}
+		stations := stationData.StationBeanList
+		if station, found := findStation(stations, stationID); found {
+			dockableInfo = checkDockable(stations, station, numBikesToReturn, l)
+			if !arrival.IsZero() && dockableInfo.Reason != ReasonNotInService && dockableInfo.Reason != ReasonStatusUnknown {
+				forecast, ok, err := forecastStation(station.ID, arrival)
+				if err != nil {
+					contextLogger.Error("Error reading station history", err)
+					http.Error(w, l.Message("history.unavailable", nil), http.StatusInternalServerError)
+					return
+				}
+				if ok {
+					dockableInfo = checkDockableAt(stations, station, numBikesToReturn, forecast, l)
+				}
+			}
+		} else {
+			dockableInfo = dockableError(ReasonStationUnknown, numBikesToReturn, l)
+			status = http.StatusNotFound
+			contextLogger.Warn("Station not found")
+		}
 	}
-	fmt.Fprint(w, string(dockableMarshal))
+
+	writeJSON(w, req, status, dockableInfo, contextLogger)
 }
@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -26,14 +31,39 @@ func (m *MockClient) Do(req *http.Request) (*http.Response, error) {
 	return GetDoFunc(req)
 }
 
+/*
+ * 	Serves body for every upstream request and resets the shared snapshot
+ */
+func mockStationFeed(body string) {
+	mockStationFeedKeepingStore(body)
+	store = newStationStore(stationCacheTTL)
+}
+
+/*
+ * 	Serves body for every upstream request without touching the shared snapshot
+ */
+func mockStationFeedKeepingStore(body string) {
+	GetDoFunc = func(*http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		}, nil
+	}
+}
+
+/*
+ * 	Decodes a recorded response body as DockableInfo, failing the test if it is not one
+ */
+func decodeDockableInfo(t *testing.T, w *httptest.ResponseRecorder) DockableInfo {
+	dockableInfo := DockableInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &dockableInfo); err != nil {
+		t.Fatalf("handler returned invalid JSON %q: %v", w.Body.String(), err)
+	}
+	return dockableInfo
+}
+
 func Router() *mux.Router {
-	r := mux.NewRouter()
-	r.HandleFunc("/stations", getAllStations)
-	r.HandleFunc("/stations/in-service", getInServiceStations)
-	r.HandleFunc("/stations/not-in-service", getNotInServiceStations)
-	r.HandleFunc("/stations/{searchstring}", searchStations)
-	r.HandleFunc("/stations/{stationid}/{bikestoreturn}", returnBikes)
-	return r
+	return newRouter()
 }
 
 func TestMin(t *testing.T) {
@@ -66,12 +96,109 @@ func TestGetStations(t *testing.T) {
 			Body:       jsonBody,
 		}, nil
 	}
-	stations := getStations()
-	if len(stations) != 6 {
+	stationData, err := fetchStationData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stations := stationData.StationBeanList; len(stations) != 6 {
 		t.Errorf("Expected %d stations, but received %d stations", 6, len(stations))
 	}
 }
 
+func TestStationsUnavailable(t *testing.T) {
+	store = newStationStore(stationCacheTTL)
+	GetDoFunc = func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}
+	defer mockStationFeed(allStationsJSON)
+	for _, path := range []string{"/stations", "/stations/in-service", "/stations/not-in-service", "/stations/atlantic", "/stations/83/5", "/stations/83/rent/5", "/graphql?query={system{stations}}"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+		if status := w.Code; status != http.StatusBadGateway {
+			t.Errorf("%s: handler returned wrong status code: got %v but wanted %v", path, status, http.StatusBadGateway)
+		}
+	}
+}
+
+func TestStationUnmarshalsFullRecord(t *testing.T) {
+	stationData := fixtureStationData(t,
+		`"stAddress2":"","city":"","postalCode":"","location":"","altitude":"","testStation":false,"lastCommunicationTime":"2016-01-22 04:30:15 PM","landMark":""`,
+		`"stAddress2":"Hell's Kitchen","city":"New York","postalCode":"10019","location":"Pier 95","altitude":"12","testStation":true,"lastCommunicationTime":"2016-01-22 04:30:15 PM","landMark":"Clinton Cove"`)
+	expected := Station{
+		ID:                    72,
+		StationName:           "W 52 St & 11 Ave",
+		AvailableDocks:        32,
+		TotalDocks:            39,
+		Latitude:              40.76727216,
+		Longitude:             -73.99392888,
+		StatusValue:           inServiceStatus,
+		StatusKey:             1,
+		AvailableBikes:        7,
+		StAddress1:            "W 52 St & 11 Ave",
+		StAddress2:            "Hell's Kitchen",
+		City:                  "New York",
+		PostalCode:            "10019",
+		Location:              "Pier 95",
+		Altitude:              "12",
+		TestStation:           true,
+		LastCommunicationTime: time.Date(2016, 1, 22, 16, 30, 15, 0, feedLocation),
+		LandMark:              "Clinton Cove",
+	}
+	if station := stationData.StationBeanList[0]; station != expected {
+		t.Errorf("Expected %+v, but received %+v", expected, station)
+	}
+
+	notInService := stationData.StationBeanList[1]
+	if notInService.StatusValue != notInServiceStatus || notInService.StatusKey != 3 || notInService.TestStation {
+		t.Errorf("Expected station 423 to be a real station not in service, but received %+v", notInService)
+	}
+	if communicated := notInService.LastCommunicationTime; !communicated.Equal(time.Date(2015, 12, 14, 16, 4, 17, 0, time.UTC)) {
+		t.Errorf("Expected 2015-12-14 11:04:17 AM in New York, but received %v", communicated)
+	}
+	if response := newStationResponse(notInService); response.LastCommunicationTime != "2015-12-14 11:04:17 AM" || response.StatusValue != "Not In Service" {
+		t.Errorf("Expected the response to keep the feed's format, but received %+v", response)
+	}
+}
+
+func TestStationUnreadableCommunicationTime(t *testing.T) {
+	stationData := fixtureStationData(t, `"lastCommunicationTime":"2016-01-22 04:30:15 PM"`, `"lastCommunicationTime":"yesterday"`)
+	if station := stationData.StationBeanList[0]; !station.LastCommunicationTime.IsZero() || station.ID != 72 {
+		t.Errorf("Expected station 72 with an unknown communication time, but received %+v", station)
+	}
+	if response := newStationResponse(stationData.StationBeanList[0]); response.LastCommunicationTime != "" {
+		t.Errorf("Expected no communication time in the response, but received %q", response.LastCommunicationTime)
+	}
+}
+
+func TestStationUnknownStatus(t *testing.T) {
+	stationData := fixtureStationData(t, `"statusValue":"Not In Service"`, `"statusValue":"Planned"`)
+	if station := stationData.StationBeanList[1]; station.ID != 423 || station.StatusValue != unknownStatus {
+		t.Errorf("Expected station 423 with status %q, but received %+v", unknownStatus, station)
+	}
+	if response := newStationResponse(stationData.StationBeanList[1]); response.StatusValue != "Unknown" {
+		t.Errorf("Expected the response to say Unknown, but received %q", response.StatusValue)
+	}
+}
+
+func TestReturnBikesUnknownStatus(t *testing.T) {
+	mockStationFeed(strings.Replace(allStationsJSON, `"statusValue":"In Service","statusKey":1,"availableBikes":7`, `"statusValue":"Planned","statusKey":1,"availableBikes":7`, 1))
+	defer mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/72/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	dockableInfo := decodeDockableInfo(t, w)
+	if dockableInfo.Dockable || dockableInfo.Reason != ReasonStatusUnknown || dockableInfo.StationStatus != string(unknownStatus) {
+		t.Errorf("Expected station 72 with an unrecognized status not to be dockable, but received %+v", dockableInfo)
+	}
+}
+
 func TestGetAllStations(t *testing.T) {
 	jsonBody := ioutil.NopCloser(bytes.NewReader([]byte(allStationsJSON)))
 	GetDoFunc = func(*http.Request) (*http.Response, error) {
@@ -80,7 +207,7 @@ func TestGetAllStations(t *testing.T) {
 			Body:       jsonBody,
 		}, nil
 	}
-	req, err := http.NewRequest("GET", "/stations", nil)
+	req, err := http.NewRequest("GET", "/stations?pretty=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -97,12 +224,6 @@ func TestGetAllStations(t *testing.T) {
         "availableBikes": 7,
         "stAddress1": "W 52 St \u0026 11 Ave"
     },
-    {
-        "stationName": "W 54 St \u0026 9 Ave",
-        "totalDocks": 3,
-        "availableBikes": 0,
-        "stAddress1": "W 54 St \u0026 9 Ave"
-    },
     {
         "stationName": "Franklin St \u0026 W Broadway",
         "totalDocks": 33,
@@ -128,7 +249,7 @@ func TestGetAllStations(t *testing.T) {
         "stAddress1": "W 17 St \u0026 8 Ave"
     }
 ]`
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
@@ -142,7 +263,7 @@ func TestGetInServiceStations(t *testing.T) {
 			Body:       jsonBody,
 		}, nil
 	}
-	req, err := http.NewRequest("GET", "/stations/in-service", nil)
+	req, err := http.NewRequest("GET", "/stations/in-service?pretty=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -184,7 +305,7 @@ func TestGetInServiceStations(t *testing.T) {
         "stAddress1": "W 17 St \u0026 8 Ave"
     }
 ]`
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
@@ -198,7 +319,7 @@ func TestGetNotInServiceStations(t *testing.T) {
 			Body:       jsonBody,
 		}, nil
 	}
-	req, err := http.NewRequest("GET", "/stations/not-in-service", nil)
+	req, err := http.NewRequest("GET", "/stations/not-in-service?pretty=true&include_stale=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -213,10 +334,11 @@ func TestGetNotInServiceStations(t *testing.T) {
         "stationName": "W 54 St \u0026 9 Ave",
         "totalDocks": 3,
         "availableBikes": 0,
-        "stAddress1": "W 54 St \u0026 9 Ave"
+        "stAddress1": "W 54 St \u0026 9 Ave",
+        "stale": true
     }
 ]`
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
@@ -231,7 +353,7 @@ func TestSearchStations(t *testing.T) {
 		}, nil
 	}
 
-	req, err := http.NewRequest("GET", "/stations/atlantic", nil)
+	req, err := http.NewRequest("GET", "/stations/atlantic?pretty=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -250,7 +372,7 @@ func TestSearchStations(t *testing.T) {
     }
 ]`
 
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
@@ -265,7 +387,7 @@ func TestReturnBikesDockable(t *testing.T) {
 		}, nil
 	}
 
-	req, err := http.NewRequest("GET", "/stations/83/20", nil)
+	req, err := http.NewRequest("GET", "/stations/83/20?pretty=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -277,10 +399,15 @@ func TestReturnBikesDockable(t *testing.T) {
 
 	expected := `{
     "dockable": true,
-    "message": "You are able to return all 20 of your bikes. There are 21 available docks."
+    "message": "You are able to return all 20 of your bikes. There are 21 available docks.",
+    "reason": "OK",
+    "stationStatus": "In Service",
+    "availableDocks": 21,
+    "requested": 20,
+    "shortfall": 0
 }`
 
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
@@ -295,7 +422,7 @@ func TestReturnBikesNotDockable(t *testing.T) {
 		}, nil
 	}
 
-	req, err := http.NewRequest("GET", "/stations/83/22", nil)
+	req, err := http.NewRequest("GET", "/stations/83/22?pretty=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -308,10 +435,24 @@ func TestReturnBikesNotDockable(t *testing.T) {
 
 	expected := `{
     "dockable": false,
-    "message": "You cannot return all 22 of your bikes. There are 21 available docks."
+    "message": "You cannot return all 22 of your bikes. There are 21 available docks.",
+    "reason": "INSUFFICIENT_DOCKS",
+    "stationStatus": "In Service",
+    "availableDocks": 21,
+    "requested": 22,
+    "shortfall": 1,
+    "alternatives": [
+        {
+            "id": 82,
+            "stationName": "St James Pl \u0026 Pearl St",
+            "stAddress1": "St James Pl \u0026 Pearl St",
+            "available": 27,
+            "distanceKm": 3.65
+        }
+    ]
 }`
 
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
@@ -326,7 +467,7 @@ func TestReturnBikesNotInServiceStation(t *testing.T) {
 		}, nil
 	}
 
-	req, err := http.NewRequest("GET", "/stations/423/1", nil)
+	req, err := http.NewRequest("GET", "/stations/423/1?pretty=true", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -339,11 +480,119 @@ func TestReturnBikesNotInServiceStation(t *testing.T) {
 
 	expected := `{
     "dockable": false,
-    "message": "Station W 54 St \u0026 9 Ave with ID 423 is Not In Service. Please choose an In Service station."
+    "message": "Station W 54 St \u0026 9 Ave with ID 423 is Not In Service. Please choose an In Service station.",
+    "reason": "NOT_IN_SERVICE",
+    "stationStatus": "Not In Service",
+    "availableDocks": 3,
+    "requested": 1,
+    "shortfall": 1,
+    "warning": "This station last reported at 2015-12-14 11:04:17 AM, so its numbers may be out of date.",
+    "alternatives": [
+        {
+            "id": 72,
+            "stationName": "W 52 St \u0026 11 Ave",
+            "stAddress1": "W 52 St \u0026 11 Ave",
+            "available": 32,
+            "distanceKm": 0.61
+        },
+        {
+            "id": 116,
+            "stationName": "W 17 St \u0026 8 Ave",
+            "stAddress1": "W 17 St \u0026 8 Ave",
+            "available": 19,
+            "distanceKm": 2.95
+        }
+    ]
 }`
 
-	if w.Body.String() != expected {
+	if w.Body.String() != expected+"\n" {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			w.Body.String(), expected)
 	}
 }
+
+func TestReturnBikesReasons(t *testing.T) {
+	tests := map[string]struct {
+		reason DockableReason
+		status int
+	}{
+		"/stations/1/2":     {ReasonStationUnknown, http.StatusNotFound},
+		"/stations/83/-1":   {ReasonInvalidCount, http.StatusBadRequest},
+		"/stations/83/many": {ReasonInvalidCount, http.StatusBadRequest},
+	}
+	for path, test := range tests {
+		mockStationFeed(allStationsJSON)
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: handler returned wrong status code: got %v but wanted %v", path, w.Code, test.status)
+		}
+		dockableInfo := decodeDockableInfo(t, w)
+		if dockableInfo.Dockable || dockableInfo.Reason != test.reason {
+			t.Errorf("Expected reason %s for %s, but received %s", test.reason, path, dockableInfo.Reason)
+		}
+	}
+}
+
+func TestMarkStale(t *testing.T) {
+	stationData := fixtureStationData(t)
+	stationData.markStale(3 * time.Minute)
+	var stale []int
+	for _, station := range stationData.StationBeanList {
+		if station.Stale {
+			stale = append(stale, station.ID)
+		}
+	}
+	if fmt.Sprint(stale) != "[423 82]" {
+		t.Errorf("Expected stations 423 and 82 to be more than 3 minutes behind the feed, but received %v", stale)
+	}
+
+	stationData = fixtureStationData(t, `"lastCommunicationTime":"2015-12-14 11:04:17 AM"`, `"lastCommunicationTime":""`)
+	stationData.markStale(time.Hour)
+	if stationData.StationBeanList[1].Stale {
+		t.Errorf("Expected a station that never reported a time not to be stale")
+	}
+}
+
+func TestStationFilters(t *testing.T) {
+	mockStationFeed(strings.Replace(allStationsJSON, `"testStation":false,"lastCommunicationTime":"2016-01-22 04:30:15 PM"`, `"testStation":true,"lastCommunicationTime":"2016-01-22 04:30:15 PM"`, 1))
+	tests := map[string]string{
+		"/stations?fields=id":                                      "[79 82 83 116]",
+		"/stations?fields=id&include_test=true":                    "[72 79 82 83 116]",
+		"/stations?fields=id&include_stale=1":                      "[423 79 82 83 116]",
+		"/stations?fields=id&include_test=true&include_stale=true": "[72 423 79 82 83 116]",
+		"/stations/not-in-service?fields=id":                       "[]",
+		"/stations/w?fields=id&include_test=true":                  "[72 79 116]",
+	}
+	for path, expected := range tests {
+		var stations []StationResponse
+		getRESTJSON(t, path, &stations)
+		ids := []int{}
+		for _, station := range stations {
+			ids = append(ids, station.ID)
+		}
+		if fmt.Sprint(ids) != expected {
+			t.Errorf("%s: Expected stations %s, but received %v", path, expected, ids)
+		}
+	}
+
+	var stations []map[string]interface{}
+	getRESTJSON(t, "/stations/not-in-service?include_stale=true", &stations)
+	if len(stations) != 1 || stations[0]["stale"] != true {
+		t.Errorf("Expected the default fields to show stale with include_stale, but received %v", stations)
+	}
+
+	w := serveWithKey(t, "GET", "/stations/423/1", "", "")
+	dockableInfo := decodeDockableInfo(t, w)
+	if dockableInfo.Warning == "" || len(dockableInfo.Alternatives) == 0 || dockableInfo.Alternatives[0].ID != 116 {
+		t.Errorf("Expected a stale warning and test station 72 not to be suggested, but received %+v", dockableInfo)
+	}
+	if dockableInfo := decodeDockableInfo(t, serveWithKey(t, "GET", "/stations/83/1", "", "")); dockableInfo.Warning != "" {
+		t.Errorf("Expected no warning for a station that is reporting, but received %q", dockableInfo.Warning)
+	}
+}
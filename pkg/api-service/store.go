@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stationCacheTTL = 30 * time.Second
+)
+
+// StationStore - keeps the most recent feed snapshot and the indexes derived from it
+type StationStore struct {
+	mu sync.Mutex
+	// refreshing - held for a whole refresh, so only one fetch is in flight and
+	// listeners see snapshots one at a time in the order they were fetched
+	refreshing sync.Mutex
+	ttl        time.Duration
+	fetchedAt  time.Time
+	data       StationData
+	suggester  *suggestIndex
+	listeners  []func(StationData)
+}
+
+var (
+	// store - shared snapshot used by endpoints that must not refetch the feed per request
+	store = newStationStore(stationCacheTTL)
+)
+
+/*
+ * 	Creates an empty store whose snapshot is refetched once it is older than ttl
+ */
+func newStationStore(ttl time.Duration) *StationStore {
+	return &StationStore{ttl: ttl}
+}
+
+/*
+ * 	Registers listener to be called with every new snapshot, in the goroutine
+ * 	that fetched it. A snapshot is new when the feed's executionTime changes.
+ * 	Listeners run one snapshot at a time and must not refresh the store.
+ */
+func (s *StationStore) OnRefresh(listener func(StationData)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+/*
+ * 	Returns the cached snapshot, refetching the feed first if it has expired.
+ * 	Requests that find it expired while a refetch is in flight wait for that
+ * 	one instead of starting their own.
+ */
+func (s *StationStore) Snapshot() (StationData, error) {
+	if data, ok := s.fresh(); ok {
+		return data, nil
+	}
+	s.refreshing.Lock()
+	defer s.refreshing.Unlock()
+	if data, ok := s.fresh(); ok {
+		return data, nil
+	}
+	return s.refresh()
+}
+
+/*
+ * 	Returns the cached snapshot and whether it is younger than the ttl
+ */
+func (s *StationStore) fresh() (StationData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data, !s.fetchedAt.IsZero() && time.Since(s.fetchedAt) < s.ttl
+}
+
+/*
+ * 	Fetches the feed now and replaces the cached snapshot. Derived indexes are
+ * 	rebuilt and listeners notified only when the feed's executionTime changes.
+ */
+func (s *StationStore) Refresh() (StationData, error) {
+	s.refreshing.Lock()
+	defer s.refreshing.Unlock()
+	return s.refresh()
+}
+
+/*
+ * 	Refreshes the snapshot; the caller holds s.refreshing
+ */
+func (s *StationStore) refresh() (StationData, error) {
+	data, err := fetchStationData()
+	if err != nil {
+		return StationData{}, err
+	}
+
+	s.mu.Lock()
+	changed := s.suggester == nil || data.ExecutionTime != s.data.ExecutionTime
+	if changed {
+		s.suggester = newSuggestIndex(stationFilter{}.apply(data.StationBeanList))
+	}
+	s.data = data
+	s.fetchedAt = time.Now()
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	if changed {
+		for _, listener := range listeners {
+			listener(data)
+		}
+	}
+	return data, nil
+}
+
+/*
+ * 	Refreshes the snapshot every interval until stop is closed
+ */
+func (s *StationStore) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Refresh(); err != nil {
+			log.SetFormatter(&log.JSONFormatter{})
+			log.WithFields(
+				log.Fields{
+					"urlEndpoint": stationFeedURL,
+				},
+			).Error("Error refreshing stations", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+/*
+ * 	Returns the prefix index built from the current snapshot. Like the default
+ * 	listing, it leaves out test and stale stations.
+ */
+func (s *StationStore) Suggester() (*suggestIndex, error) {
+	if _, err := s.Snapshot(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.suggester, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStationStoreNotifiesNewSnapshotsOnly(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	var notified []string
+	store.OnRefresh(func(data StationData) {
+		notified = append(notified, data.ExecutionTime)
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := store.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mockStationFeedKeepingStore(strings.Replace(allStationsJSON, "04:32:49 PM", "04:35:00 PM", 1))
+	if _, err := store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notified) != 2 || notified[1] != "2016-01-22 04:35:00 PM" {
+		t.Errorf("Expected one notification per executionTime, but received %v", notified)
+	}
+}
+
+func TestStationStoreFetchesOnceForConcurrentRequests(t *testing.T) {
+	var fetches int32
+	GetDoFunc = func(*http.Request) (*http.Response, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(allStationsJSON))}, nil
+	}
+	store = newStationStore(stationCacheTTL)
+	var notified int32
+	store.OnRefresh(func(StationData) {
+		atomic.AddInt32(&notified, 1)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := store.Snapshot(); err != nil || len(data.StationBeanList) != 6 {
+				t.Errorf("Expected the fixture snapshot, but received %v %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 || notified != 1 {
+		t.Errorf("Expected one fetch and one notification for concurrent requests, but received %d and %d", fetches, notified)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	streamReplayEvents      = 1024
+	streamSubscriberBatches = 16
+)
+
+var (
+	// streamHeartbeat - how often an idle stream sends a comment to keep proxies from closing it
+	streamHeartbeat = 15 * time.Second
+
+	// stream - fans out station changes detected between refreshed snapshots
+	stream = newStationStream()
+)
+
+// StationUpdate - the live values of one station as sent to stream clients
+type StationUpdate struct {
+	ID             int      `json:"id"`
+	StationName    string   `json:"stationName"`
+	AvailableBikes int      `json:"availableBikes"`
+	AvailableDocks int      `json:"availableDocks"`
+	TotalDocks     int      `json:"totalDocks"`
+	StatusValue    string   `json:"statusValue"`
+	Latitude       float64  `json:"latitude"`
+	Longitude      float64  `json:"longitude"`
+	Changed        []string `json:"changed,omitempty"`
+}
+
+// streamEvent - one change with the id clients use to resume after it
+type streamEvent struct {
+	ID     int64
+	Update StationUpdate
+}
+
+// streamFilter - restricts a stream to some station ids and/or a bounding box
+type streamFilter struct {
+	ids  map[int]bool
+	bbox []float64
+}
+
+// streamSubscriber - one connected client, sent the events of each refresh as
+// one batch; events is closed if the client falls too many refreshes behind
+type streamSubscriber struct {
+	events chan []streamEvent
+}
+
+// StationStream - the latest snapshot, a replay buffer of recent changes and the connected clients
+type StationStream struct {
+	mu          sync.Mutex
+	lastID      int64
+	stations    map[int]StationUpdate
+	replay      []streamEvent
+	subscribers map[*streamSubscriber]bool
+}
+
+/*
+ * 	Creates a stream with no snapshot and no subscribers. Event ids start from
+ * 	the current time in milliseconds so ids from before a restart are lower
+ * 	and resuming from one falls back to a snapshot.
+ */
+func newStationStream() *StationStream {
+	return &StationStream{
+		lastID:      time.Now().UnixNano() / int64(time.Millisecond),
+		subscribers: map[*streamSubscriber]bool{},
+	}
+}
+
+/*
+ * 	Converts a feed station into the values streamed to clients
+ */
+func newStationUpdate(station Station) StationUpdate {
+	return StationUpdate{
+		ID:             station.ID,
+		StationName:    station.StationName,
+		AvailableBikes: station.AvailableBikes,
+		AvailableDocks: station.AvailableDocks,
+		TotalDocks:     station.TotalDocks,
+		StatusValue:    string(station.StatusValue),
+		Latitude:       station.Latitude,
+		Longitude:      station.Longitude,
+	}
+}
+
+// streamFields - the StationUpdate field each streamed event type changes
+var streamFields = map[StationEventType]string{
+	StatusChanged: "statusValue",
+	BikesChanged:  "availableBikes",
+	DocksChanged:  "availableDocks",
+}
+
+/*
+ * 	Listens to the diff engine: replaces the stream's snapshot with data and
+ * 	sends subscribers one batch with an event for every station whose bikes,
+ * 	docks or status changed, so a refresh changing every station costs one
+ * 	slot of a subscriber's buffer. Subscribers that cannot keep up are
+ * 	disconnected rather than slowing down the refresh.
+ */
+func (s *StationStream) Publish(data StationData, diff []StationEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := map[int][]string{}
+	if s.stations != nil {
+		for _, event := range diff {
+			if field, ok := streamFields[event.Type]; ok {
+				changed[event.StationID] = append(changed[event.StationID], field)
+			}
+		}
+	}
+	current := map[int]StationUpdate{}
+	var events []streamEvent
+	for _, station := range data.StationBeanList {
+		update := newStationUpdate(station)
+		current[station.ID] = update
+		if update.Changed = changed[station.ID]; update.Changed != nil {
+			s.lastID++
+			events = append(events, streamEvent{ID: s.lastID, Update: update})
+		}
+	}
+	s.stations = current
+
+	s.replay = append(s.replay, events...)
+	if len(s.replay) > streamReplayEvents {
+		s.replay = append([]streamEvent{}, s.replay[len(s.replay)-streamReplayEvents:]...)
+	}
+	if len(events) == 0 {
+		return
+	}
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber.events <- events:
+		default:
+			delete(s.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+/*
+ * 	Registers a subscriber. If lastEventID is still in the replay buffer the
+ * 	missed events are returned and no snapshot is needed; otherwise the
+ * 	snapshot is returned. Both are read under the same lock as registration so
+ * 	no event falls in between.
+ */
+func (s *StationStream) Subscribe(lastEventID string) (subscriber *streamSubscriber, snapshot []StationUpdate, missed []streamEvent, snapshotID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriber = &streamSubscriber{events: make(chan []streamEvent, streamSubscriberBatches)}
+	s.subscribers[subscriber] = true
+
+	if resumeID, err := strconv.ParseInt(lastEventID, 10, 64); err == nil && resumeID <= s.lastID {
+		if resumeID == s.lastID || (len(s.replay) > 0 && s.replay[0].ID <= resumeID+1) {
+			for _, event := range s.replay {
+				if event.ID > resumeID {
+					missed = append(missed, event)
+				}
+			}
+			return subscriber, nil, missed, s.lastID
+		}
+	}
+
+	for _, update := range s.stations {
+		snapshot = append(snapshot, update)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].ID < snapshot[j].ID })
+	return subscriber, snapshot, nil, s.lastID
+}
+
+/*
+ * 	Removes a subscriber, closing its channel unless Publish already has
+ */
+func (s *StationStream) Unsubscribe(subscriber *streamSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[subscriber] {
+		delete(s.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+/*
+ * 	Returns whether the stream has received a snapshot yet
+ */
+func (s *StationStream) HasSnapshot() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stations != nil
+}
+
+/*
+ * 	Parses ?ids=72,83 and ?bbox=minLon,minLat,maxLon,maxLat
+ */
+func parseStreamFilter(req *http.Request) (streamFilter, error) {
+	filter := streamFilter{}
+	if idsInfo := req.URL.Query().Get("ids"); idsInfo != "" {
+		filter.ids = map[int]bool{}
+		for _, idInfo := range strings.Split(idsInfo, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idInfo))
+			if err != nil {
+				return filter, err
+			}
+			filter.ids[id] = true
+		}
+	}
+	if bboxInfo := req.URL.Query().Get("bbox"); bboxInfo != "" {
+		corners := strings.Split(bboxInfo, ",")
+		if len(corners) != 4 {
+			return filter, fmt.Errorf("bbox needs 4 values, got %d", len(corners))
+		}
+		for _, corner := range corners {
+			value, err := strconv.ParseFloat(strings.TrimSpace(corner), 64)
+			if err != nil {
+				return filter, err
+			}
+			filter.bbox = append(filter.bbox, value)
+		}
+	}
+	return filter, nil
+}
+
+/*
+ * 	Returns whether update passes the filter
+ */
+func (filter streamFilter) matches(update StationUpdate) bool {
+	if filter.ids != nil && !filter.ids[update.ID] {
+		return false
+	}
+	if filter.bbox != nil {
+		return update.Longitude >= filter.bbox[0] && update.Latitude >= filter.bbox[1] &&
+			update.Longitude <= filter.bbox[2] && update.Latitude <= filter.bbox[3]
+	}
+	return true
+}
+
+/*
+ * 	Writes one server-sent event and flushes it to the client
+ */
+func writeStreamEvent(w http.ResponseWriter, id int64, event string, data interface{}) error {
+	dataMarshal, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, dataMarshal); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+/*
+ *	Endpoint: /stations/stream?ids=&bbox=
+ *
+ *	Server-sent events: a "snapshot" event with every matching station, then a
+ *	"change" event whenever a refresh changes a station's bikes, docks or status.
+ *	Clients reconnecting with Last-Event-ID receive the changes they missed
+ *	instead of a new snapshot, as long as those are still buffered.
+ */
+func streamStations(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing streamStations entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+
+	filter, filterErr := parseStreamFilter(req)
+	if filterErr != nil {
+		http.Error(w, l.Message("stream.invalidFilter", nil), http.StatusBadRequest)
+		contextLogger.Warn("Invalid stream filter", filterErr)
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, l.Message("stream.unsupported", nil), http.StatusInternalServerError)
+		return
+	}
+	if !stream.HasSnapshot() {
+		if _, err := store.Snapshot(); err != nil {
+			contextLogger.Error("Error retrieving stations", err)
+			http.Error(w, l.Message("stations.unavailable", nil), http.StatusBadGateway)
+			return
+		}
+	}
+
+	subscriber, snapshot, missed, snapshotID := stream.Subscribe(req.Header.Get("Last-Event-ID"))
+	defer stream.Unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	var err error
+	if snapshot != nil {
+		matching := []StationUpdate{}
+		for _, update := range snapshot {
+			if filter.matches(update) {
+				matching = append(matching, update)
+			}
+		}
+		err = writeStreamEvent(w, snapshotID, "snapshot", matching)
+	}
+	for _, event := range missed {
+		if err == nil && filter.matches(event.Update) {
+			err = writeStreamEvent(w, event.ID, "change", event.Update)
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for err == nil {
+		select {
+		case events, open := <-subscriber.events:
+			if !open {
+				contextLogger.Warn("Disconnecting slow stream client")
+				return
+			}
+			for _, event := range events {
+				if err == nil && filter.matches(event.Update) {
+					err = writeStreamEvent(w, event.ID, "change", event.Update)
+				}
+			}
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err == nil {
+				w.(http.Flusher).Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
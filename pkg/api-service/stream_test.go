@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * 	Reads lines from an event stream up to and including the blank line ending the next event
+ */
+func readStreamEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+/*
+ * 	Returns a diff engine feeding s, wired the way startBackgroundJobs wires the stream
+ */
+func streamDiffs(s *StationStream) *DiffEngine {
+	engine := newDiffEngine()
+	engine.OnDiff(s.Publish)
+	return engine
+}
+
+func TestStationStreamPublish(t *testing.T) {
+	testStream := newStationStream()
+	engine := streamDiffs(testStream)
+	engine.Publish(fixtureStationData(t))
+	subscriber, snapshot, _, snapshotID := testStream.Subscribe("")
+	if len(snapshot) != 6 {
+		t.Errorf("Expected a snapshot of %d stations, but received %d", 6, len(snapshot))
+	}
+
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`, `"statusValue":"Not In Service"`, `"statusValue":"In Service"`))
+	if batches := len(subscriber.events); batches != 1 {
+		t.Fatalf("Expected the refresh to send 1 batch, but received %d", batches)
+	}
+	events := <-subscriber.events
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, but received %+v", events)
+	}
+	if events[0].Update.ID != 423 || strings.Join(events[0].Update.Changed, ",") != "statusValue" {
+		t.Errorf("Expected station 423 to change status, but received %+v", events[0].Update)
+	}
+	if events[1].Update.ID != 83 || strings.Join(events[1].Update.Changed, ",") != "availableBikes" || events[1].ID != snapshotID+2 {
+		t.Errorf("Expected station 83 to change bikes with id %d, but received %+v", snapshotID+2, events[1])
+	}
+
+	_, snapshot, missed, _ := testStream.Subscribe(strconv.FormatInt(snapshotID+1, 10))
+	if snapshot != nil || len(missed) != 1 || missed[0].Update.ID != 83 {
+		t.Errorf("Expected to resume with station 83 only, but received %+v and %+v", snapshot, missed)
+	}
+	_, snapshot, _, _ = testStream.Subscribe("1")
+	if len(snapshot) != 6 {
+		t.Errorf("Expected a snapshot when resuming from an unknown id, but received %+v", snapshot)
+	}
+}
+
+func TestStationStreamDropsSlowSubscribers(t *testing.T) {
+	testStream := newStationStream()
+	engine := streamDiffs(testStream)
+	engine.Publish(fixtureStationData(t))
+	subscriber, _, _, _ := testStream.Subscribe("")
+	for i := 0; i < streamSubscriberBatches; i++ {
+		subscriber.events <- nil
+	}
+
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`))
+	for range subscriber.events {
+	}
+	if len(testStream.subscribers) != 0 {
+		t.Errorf("Expected the slow subscriber to be removed")
+	}
+}
+
+func TestStreamStations(t *testing.T) {
+	stream = newStationStream()
+	engine := streamDiffs(stream)
+	engine.Publish(fixtureStationData(t))
+	server := httptest.NewServer(Router())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/stations/stream?ids=83,72&bbox=-74,40.6,-73.9,40.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected Content-Type %s, but received %s", "text/event-stream", contentType)
+	}
+	reader := bufio.NewReader(res.Body)
+	snapshot := readStreamEvent(t, reader)
+	if len(snapshot) != 3 || snapshot[1] != "event: snapshot" || !strings.Contains(snapshot[2], `"id":83`) || strings.Contains(snapshot[2], `"id":72`) {
+		t.Errorf("Expected a snapshot of station 83 only, but received %v", snapshot)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`, `"availableBikes":7`, `"availableBikes":6`))
+	change := readStreamEvent(t, reader)
+	if len(change) != 3 || change[1] != "event: change" || !strings.Contains(change[2], `"id":83`) || !strings.Contains(change[2], `"changed":["availableBikes"]`) {
+		t.Errorf("Expected a change event for station 83, but received %v", change)
+	}
+}
+
+func TestStreamStationsInvalidFilter(t *testing.T) {
+	req, err := http.NewRequest("GET", "/stations/stream?bbox=1,2,3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	subscriptionsBucket = []byte("subscriptions")
+	deadLettersBucket   = []byte("deadLetters")
+
+	// subscriptions - webhook subscriptions; nil when alerts are disabled
+	subscriptions *SubscriptionStore
+)
+
+// AlertConditionType - the station condition a subscription is alerted about
+type AlertConditionType string
+
+const (
+	// ConditionNotInService - the station is Not In Service
+	ConditionNotInService AlertConditionType = "notInService"
+	// ConditionEmpty - the station has no bikes
+	ConditionEmpty AlertConditionType = "empty"
+	// ConditionFull - the station has no free docks
+	ConditionFull AlertConditionType = "full"
+	// ConditionStale - the station has not communicated with the feed
+	ConditionStale AlertConditionType = "stale"
+)
+
+// AlertCondition - alert when a station has been in condition Type for at least Minutes
+type AlertCondition struct {
+	Type    AlertConditionType `json:"type"`
+	Minutes int                `json:"minutes"`
+}
+
+// Subscription - where and about what to deliver alerts. Secret is only shown when it is created.
+type Subscription struct {
+	ID         string           `json:"id"`
+	URL        string           `json:"url"`
+	Secret     string           `json:"secret,omitempty"`
+	Conditions []AlertCondition `json:"conditions"`
+	StationIDs []int            `json:"stationIds,omitempty"`
+	CreatedAt  time.Time        `json:"createdAt"`
+}
+
+// DeadLetter - an alert that could not be delivered after every retry
+type DeadLetter struct {
+	Alert    Alert     `json:"alert"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// SubscriptionStore - subscriptions and dead letters in a BoltDB file, with subscriptions cached in memory
+type SubscriptionStore struct {
+	db            *bolt.DB
+	mu            sync.RWMutex
+	subscriptions map[string]Subscription
+}
+
+/*
+ * 	Opens (creating if needed) the subscription database at path and loads its subscriptions
+ */
+func OpenSubscriptionStore(path string) (*SubscriptionStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &SubscriptionStore{db: db, subscriptions: map[string]Subscription{}}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{subscriptionsBucket, deadLettersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(subscriptionsBucket).ForEach(func(k, v []byte) error {
+			subscription := Subscription{}
+			if err := json.Unmarshal(v, &subscription); err != nil {
+				return err
+			}
+			s.subscriptions[subscription.ID] = subscription
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+/*
+ * 	Closes the underlying database file
+ */
+func (s *SubscriptionStore) Close() error {
+	return s.db.Close()
+}
+
+/*
+ * 	Stores a new subscription
+ */
+func (s *SubscriptionStore) Create(subscription Subscription) error {
+	subscriptionMarshal, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).Put([]byte(subscription.ID), subscriptionMarshal)
+	})
+	if err == nil {
+		s.subscriptions[subscription.ID] = subscription
+	}
+	return err
+}
+
+/*
+ * 	Returns every subscription, oldest first
+ */
+func (s *SubscriptionStore) List() []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := []Subscription{}
+	for _, subscription := range s.subscriptions {
+		list = append(list, subscription)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+/*
+ * 	Returns the subscription with id
+ */
+func (s *SubscriptionStore) Get(id string) (Subscription, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subscription, ok := s.subscriptions[id]
+	return subscription, ok
+}
+
+/*
+ * 	Removes a subscription and its dead letters. Returns false if it did not exist.
+ */
+func (s *SubscriptionStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return false, nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(subscriptionsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(deadLettersBucket)
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		prefix := deadLetterPrefix(id)
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	delete(s.subscriptions, id)
+	return true, nil
+}
+
+/*
+ * 	Returns the key prefix under which a subscription's dead letters are stored
+ */
+func deadLetterPrefix(subscriptionID string) []byte {
+	return []byte(subscriptionID + "/")
+}
+
+/*
+ * 	Appends an undeliverable alert to its subscription's dead-letter log
+ */
+func (s *SubscriptionStore) AddDeadLetter(deadLetter DeadLetter) error {
+	deadLetterMarshal, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deadLettersBucket)
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, sequence)
+		return bucket.Put(append(deadLetterPrefix(deadLetter.Alert.SubscriptionID), key...), deadLetterMarshal)
+	})
+}
+
+/*
+ * 	Returns a subscription's dead letters, oldest first
+ */
+func (s *SubscriptionStore) DeadLetters(subscriptionID string) ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(deadLettersBucket).Cursor()
+		prefix := deadLetterPrefix(subscriptionID)
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			deadLetter := DeadLetter{}
+			if err := json.Unmarshal(v, &deadLetter); err != nil {
+				return err
+			}
+			deadLetters = append(deadLetters, deadLetter)
+		}
+		return nil
+	})
+	return deadLetters, err
+}
+
+/*
+ * 	Returns n random bytes as hex
+ */
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/*
+ * 	Checks a subscription request, returning a description of the first problem
+ */
+func validateSubscription(subscription Subscription) error {
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if len(subscription.Conditions) == 0 {
+		return fmt.Errorf("at least one condition is required")
+	}
+	for _, condition := range subscription.Conditions {
+		switch condition.Type {
+		case ConditionNotInService, ConditionEmpty, ConditionFull:
+			if condition.Minutes < 0 {
+				return fmt.Errorf("minutes must not be negative")
+			}
+		case ConditionStale:
+			if condition.Minutes <= 0 {
+				return fmt.Errorf("stale conditions need minutes greater than 0")
+			}
+		default:
+			return fmt.Errorf("unknown condition %q", condition.Type)
+		}
+	}
+	return nil
+}
+
+/*
+ * 	Writes a 503 and returns false when alerts are disabled
+ */
+func subscriptionsEnabled(w http.ResponseWriter, l localizer) bool {
+	if subscriptions == nil {
+		http.Error(w, l.Message("subscriptions.disabled", nil), http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+ *	Endpoint: POST /subscriptions
+ *
+ *	Body: {"url": "https://...", "secret": "optional", "conditions":
+ *	[{"type": "notInService" | "empty" | "full" | "stale", "minutes": 15}],
+ *	"stationIds": [72]}. Without stationIds every station is watched. A secret
+ *	is generated if none is given; it is only returned by this request.
+ */
+func createSubscription(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing createSubscription entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !subscriptionsEnabled(w, l) {
+		return
+	}
+
+	subscription := Subscription{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&subscription); err != nil {
+		http.Error(w, l.Message("subscriptions.invalid", MessageArgs{"error": err.Error()}), http.StatusBadRequest)
+		return
+	}
+	if err := validateSubscription(subscription); err != nil {
+		http.Error(w, l.Message("subscriptions.invalid", MessageArgs{"error": err.Error()}), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if subscription.ID, err = randomHex(16); err == nil && subscription.Secret == "" {
+		subscription.Secret, err = randomHex(32)
+	}
+	if err == nil {
+		subscription.CreatedAt = time.Now().UTC()
+		err = subscriptions.Create(subscription)
+	}
+	if err != nil {
+		contextLogger.Error("Error storing subscription", err)
+		http.Error(w, l.Message("subscriptions.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/subscriptions/"+subscription.ID)
+	writeJSON(w, req, http.StatusCreated, subscription, contextLogger)
+}
+
+/*
+ *	Endpoint: GET /subscriptions
+ *
+ *	Lists every subscription, without secrets
+ */
+func listSubscriptions(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing listSubscriptions entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !subscriptionsEnabled(w, l) {
+		return
+	}
+
+	list := subscriptions.List()
+	for i := range list {
+		list[i].Secret = ""
+	}
+	writeJSON(w, req, http.StatusOK, list, contextLogger)
+}
+
+/*
+ *	Endpoint: GET /subscriptions/:id
+ *
+ *	Returns one subscription, without its secret
+ */
+func getSubscription(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing getSubscription entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"id":   mux.Vars(req)["id"],
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !subscriptionsEnabled(w, l) {
+		return
+	}
+
+	subscription, ok := subscriptions.Get(mux.Vars(req)["id"])
+	if !ok {
+		http.Error(w, l.Message("subscriptions.notFound", nil), http.StatusNotFound)
+		return
+	}
+	subscription.Secret = ""
+	writeJSON(w, req, http.StatusOK, subscription, contextLogger)
+}
+
+/*
+ *	Endpoint: DELETE /subscriptions/:id
+ *
+ *	Removes a subscription and its dead-letter log
+ */
+func deleteSubscription(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing deleteSubscription entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"id":   mux.Vars(req)["id"],
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !subscriptionsEnabled(w, l) {
+		return
+	}
+
+	deleted, err := subscriptions.Delete(mux.Vars(req)["id"])
+	if err != nil {
+		contextLogger.Error("Error deleting subscription", err)
+		http.Error(w, l.Message("subscriptions.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, l.Message("subscriptions.notFound", nil), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+ *	Endpoint: GET /subscriptions/:id/dead-letters
+ *
+ *	Lists the subscription's alerts that could not be delivered
+ */
+func getDeadLetters(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing getDeadLetters entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"id":   mux.Vars(req)["id"],
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+	l.SetHeader(w)
+	if !subscriptionsEnabled(w, l) {
+		return
+	}
+
+	id := mux.Vars(req)["id"]
+	if _, ok := subscriptions.Get(id); !ok {
+		http.Error(w, l.Message("subscriptions.notFound", nil), http.StatusNotFound)
+		return
+	}
+	deadLetters, err := subscriptions.DeadLetters(id)
+	if err != nil {
+		contextLogger.Error("Error reading dead letters", err)
+		http.Error(w, l.Message("subscriptions.unavailable", nil), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, req, http.StatusOK, deadLetters, contextLogger)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * 	Opens a subscription store in a temporary directory, closed when the test ends
+ */
+func openTestSubscriptionStore(t *testing.T) *SubscriptionStore {
+	store, err := OpenSubscriptionStore(filepath.Join(t.TempDir(), "subscriptions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSubscriptionStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.db")
+	store, err := OpenSubscriptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription := Subscription{ID: "a1", URL: "https://example.com/hook", Secret: "s3cret", Conditions: []AlertCondition{{Type: ConditionFull, Minutes: 5}}, CreatedAt: time.Now().UTC()}
+	if err := store.Create(subscription); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDeadLetter(DeadLetter{Alert: Alert{ID: "x", SubscriptionID: "a1"}, Attempts: 5, Error: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenSubscriptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	reopened, ok := store.Get("a1")
+	if !ok || reopened.Secret != "s3cret" || reopened.Conditions[0] != subscription.Conditions[0] {
+		t.Errorf("Expected the subscription to survive a restart, but received %+v", reopened)
+	}
+	if deadLetters, err := store.DeadLetters("a1"); err != nil || len(deadLetters) != 1 || deadLetters[0].Alert.ID != "x" {
+		t.Errorf("Expected 1 dead letter, but received %+v (%v)", deadLetters, err)
+	}
+
+	if deleted, err := store.Delete("a1"); !deleted || err != nil {
+		t.Fatalf("Expected the subscription to be deleted, but received %v (%v)", deleted, err)
+	}
+	if deadLetters, _ := store.DeadLetters("a1"); len(deadLetters) != 0 || len(store.List()) != 0 {
+		t.Errorf("Expected the subscription and its dead letters to be removed")
+	}
+}
+
+func TestValidateSubscription(t *testing.T) {
+	tests := map[string]Subscription{
+		"relative url":      {URL: "/hook", Conditions: []AlertCondition{{Type: ConditionFull}}},
+		"ftp url":           {URL: "ftp://example.com", Conditions: []AlertCondition{{Type: ConditionFull}}},
+		"no conditions":     {URL: "https://example.com"},
+		"unknown condition": {URL: "https://example.com", Conditions: []AlertCondition{{Type: "flooded"}}},
+		"negative minutes":  {URL: "https://example.com", Conditions: []AlertCondition{{Type: ConditionEmpty, Minutes: -1}}},
+		"stale no minutes":  {URL: "https://example.com", Conditions: []AlertCondition{{Type: ConditionStale}}},
+	}
+	for name, subscription := range tests {
+		if err := validateSubscription(subscription); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := validateSubscription(Subscription{URL: "https://example.com", Conditions: []AlertCondition{{Type: ConditionNotInService}, {Type: ConditionStale, Minutes: 60}}}); err != nil {
+		t.Errorf("Expected a valid subscription, but received %v", err)
+	}
+}
+
+func TestSubscriptionsAPI(t *testing.T) {
+	enableTestAPIKeys(t)
+	subscriptions = openTestSubscriptionStore(t)
+	defer func() { subscriptions = nil }()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		return serveWithKey(t, method, path, "admin-secret", body)
+	}
+
+	if w := serve("POST", "/subscriptions", `{"url":"https://example.com/hook","conditions":[{"type":"flooded"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusBadRequest)
+	}
+	w := serve("POST", "/subscriptions", `{"url":"https://example.com/hook","conditions":[{"type":"empty","minutes":15}],"stationIds":[72]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusCreated)
+	}
+	created := Subscription{}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || len(created.Secret) != 64 || w.Header().Get("Location") != "/subscriptions/"+created.ID {
+		t.Errorf("Expected an id, a generated secret and a Location, but received %+v", created)
+	}
+
+	w = serve("GET", "/subscriptions", "")
+	if !strings.Contains(w.Body.String(), created.ID) || strings.Contains(w.Body.String(), created.Secret) {
+		t.Errorf("Expected the subscription to be listed without its secret, but received %s", w.Body.String())
+	}
+	if w = serve("GET", "/subscriptions/"+created.ID+"/dead-letters", ""); w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Errorf("Expected no dead letters, but received %v %s", w.Code, w.Body.String())
+	}
+	if w = serve("DELETE", "/subscriptions/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusNoContent)
+	}
+	if w = serve("GET", "/subscriptions/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSubscriptionsDisabled(t *testing.T) {
+	enableTestAPIKeys(t)
+	w := serveWithKey(t, "GET", "/subscriptions", "admin-secret", "")
+	if status := w.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+// Suggestion - the minimal station fields needed by a typeahead picker
+type Suggestion struct {
+	ID          int    `json:"id"`
+	StationName string `json:"stationName"`
+	StAddress1  string `json:"stAddress1"`
+}
+
+// suggestEntry - one searchable key pointing back at the station it came from
+type suggestEntry struct {
+	key     string
+	rank    int
+	station int
+}
+
+// suggestIndex - sorted keys for every word start of every station name and address
+type suggestIndex struct {
+	entries     []suggestEntry
+	suggestions []Suggestion
+}
+
+/*
+ * 	Builds the prefix index for a snapshot. Each station contributes one key per
+ * 	word start so that "52 st" finds "W 52 St & 11 Ave". Keys from the start of the
+ * 	name rank first, then other name words, then address words.
+ */
+func newSuggestIndex(stations []Station) *suggestIndex {
+	index := &suggestIndex{}
+	for i, station := range stations {
+		index.suggestions = append(index.suggestions, Suggestion{
+			ID:          station.ID,
+			StationName: station.StationName,
+			StAddress1:  station.StAddress1,
+		})
+		for w, key := range wordSuffixes(station.StationName) {
+			rank := 1
+			if w == 0 {
+				rank = 0
+			}
+			index.entries = append(index.entries, suggestEntry{key: key, rank: rank, station: i})
+		}
+		for _, key := range wordSuffixes(station.StAddress1) {
+			index.entries = append(index.entries, suggestEntry{key: key, rank: 2, station: i})
+		}
+	}
+	sort.Slice(index.entries, func(a, b int) bool {
+		return index.entries[a].key < index.entries[b].key
+	})
+	return index
+}
+
+/*
+ * 	Returns the lowercased text starting at each word of s
+ */
+func wordSuffixes(s string) []string {
+	var suffixes []string
+	lower := strings.ToLower(s)
+	for i := 0; i < len(lower); i++ {
+		if lower[i] != ' ' && (i == 0 || lower[i-1] == ' ') {
+			suffixes = append(suffixes, lower[i:])
+		}
+	}
+	return suffixes
+}
+
+/*
+ * 	Returns up to limit stations with a word starting with prefix, best matches first
+ */
+func (index *suggestIndex) Suggest(prefix string, limit int) []Suggestion {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	results := []Suggestion{}
+	if prefix == "" || limit <= 0 {
+		return results
+	}
+	start := sort.Search(len(index.entries), func(i int) bool {
+		return index.entries[i].key >= prefix
+	})
+
+	// best rank + 1 per station, so 0 means the station has not matched yet
+	bestRank := make([]int, len(index.suggestions))
+	var matches []int
+	for i := start; i < len(index.entries) && strings.HasPrefix(index.entries[i].key, prefix); i++ {
+		entry := index.entries[i]
+		if bestRank[entry.station] == 0 {
+			matches = append(matches, entry.station)
+			bestRank[entry.station] = entry.rank + 1
+		} else if entry.rank+1 < bestRank[entry.station] {
+			bestRank[entry.station] = entry.rank + 1
+		}
+	}
+
+	sort.Slice(matches, func(a, b int) bool {
+		ra, rb := bestRank[matches[a]], bestRank[matches[b]]
+		if ra != rb {
+			return ra < rb
+		}
+		return index.suggestions[matches[a]].StationName < index.suggestions[matches[b]].StationName
+	})
+	for i := 0; i < len(matches) && i < limit; i++ {
+		results = append(results, index.suggestions[matches[i]])
+	}
+	return results
+}
+
+/*
+ *	Endpoint: /stations/suggest?q=prefix&limit=10
+ *
+ * 	Returns the id, name and address of stations whose name or address has a word
+ * 	starting with q. Served from the cached snapshot so it is cheap per keystroke.
+ */
+func suggestStations(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	contextLogger := log.WithFields(
+		log.Fields{
+			"q":    req.URL.Query().Get("q"),
+			"Path": req.URL.Path,
+		},
+	)
+
+	limit := defaultSuggestLimit
+	if limitInfo := req.URL.Query().Get("limit"); limitInfo != "" {
+		parsed, limitErr := strconv.Atoi(limitInfo)
+		if limitErr != nil || parsed <= 0 {
+			contextLogger.Warn("Invalid suggest limit. Using the default instead.")
+		} else {
+			limit = min(parsed, maxSuggestLimit)
+		}
+	}
+
+	index, err := store.Suggester()
+	if err != nil {
+		contextLogger.Error("Error retrieving stations", err)
+		l := newLocalizer(req)
+		l.SetHeader(w)
+		http.Error(w, l.Message("stations.unavailable", nil), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, req, http.StatusOK, index.Suggest(req.URL.Query().Get("q"), limit), contextLogger)
+}
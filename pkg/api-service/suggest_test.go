@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSuggestIndexRanksNameStartFirst(t *testing.T) {
+	index := newSuggestIndex([]Station{
+		{ID: 1, StationName: "Broadway & W 60 St", StAddress1: "Broadway & W 60 St"},
+		{ID: 2, StationName: "W 52 St & Broadway", StAddress1: "W 52 St & Broadway"},
+		{ID: 3, StationName: "Atlantic Ave", StAddress1: "Broad St"},
+	})
+	suggestions := index.Suggest("broad", 10)
+	if len(suggestions) != 3 {
+		t.Fatalf("Expected %d suggestions, but received %d", 3, len(suggestions))
+	}
+	for i, id := range []int{1, 2, 3} {
+		if suggestions[i].ID != id {
+			t.Errorf("Expected station %d at position %d, but received %d", id, i, suggestions[i].ID)
+		}
+	}
+	if limited := index.Suggest("broad", 1); len(limited) != 1 {
+		t.Errorf("Expected %d suggestion, but received %d", 1, len(limited))
+	}
+	if none := index.Suggest("  ", 10); len(none) != 0 {
+		t.Errorf("Expected no suggestions for a blank prefix, but received %d", len(none))
+	}
+}
+
+func TestSuggestStations(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/suggest?q=W%205&limit=1&pretty=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	if status := w.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v but wanted %v", status, http.StatusOK)
+	}
+
+	expected := `[
+    {
+        "id": 72,
+        "stationName": "W 52 St \u0026 11 Ave",
+        "stAddress1": "W 52 St \u0026 11 Ave"
+    }
+]`
+	if w.Body.String() != expected+"\n" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			w.Body.String(), expected)
+	}
+}
+
+func TestSuggestStationsHidesTestAndStaleStations(t *testing.T) {
+	mockStationFeed(strings.Replace(allStationsJSON,
+		`"testStation":false,"lastCommunicationTime":"2016-01-22 04:32:32 PM"`,
+		`"testStation":true,"lastCommunicationTime":"2016-01-22 04:32:32 PM"`, 1))
+	defer mockStationFeed(allStationsJSON)
+	req, err := http.NewRequest("GET", "/stations/suggest?q=W", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, req)
+	var suggestions []Suggestion
+	if err := json.Unmarshal(w.Body.Bytes(), &suggestions); err != nil {
+		t.Fatal(err)
+	}
+	// 423 is stale and 116 is a test station, so neither W 54 St nor W 17 St is suggested
+	var ids []int
+	for _, suggestion := range suggestions {
+		ids = append(ids, suggestion.ID)
+	}
+	if fmt.Sprint(ids) != "[72 79]" {
+		t.Errorf("Expected only stations 72 and 79 to be suggested, but received %s", w.Body.String())
+	}
+}
+
+/*
+ * 	Builds a feed-sized list of stations with realistic street names
+ */
+func benchmarkStations(n int) []Station {
+	streets := []string{"Broadway", "Atlantic Ave", "Fulton St", "Park Ave", "Bedford Ave", "Lexington Ave"}
+	stations := make([]Station, n)
+	for i := range stations {
+		name := fmt.Sprintf("W %d St & %s", i%200, streets[i%len(streets)])
+		stations[i] = Station{ID: i + 1, StationName: name, StAddress1: name}
+	}
+	return stations
+}
+
+func BenchmarkSuggest(b *testing.B) {
+	index := newSuggestIndex(benchmarkStations(1000))
+	prefixes := []string{"w", "w 1", "broad", "lexington a", "w 199 st"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Suggest(prefixes[i%len(prefixes)], defaultSuggestLimit)
+	}
+}
+
+func BenchmarkNewSuggestIndex(b *testing.B) {
+	stations := benchmarkStations(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newSuggestIndex(stations)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// ParameterViolation - one path or query parameter that does not match its schema
+type ParameterViolation struct {
+	Name    string `json:"name"`
+	In      string `json:"in"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// ValidationError - the 400 body listing every invalid parameter of a request
+type ValidationError struct {
+	Message    string               `json:"message"`
+	Violations []ParameterViolation `json:"violations"`
+}
+
+// Validator - middleware that checks path and query parameters against the
+// OpenAPI operation of the matched route before its handler runs. Handlers
+// still reject bike counts below 1 themselves, so that holds without it too.
+type Validator struct {
+	router     *mux.Router
+	operations map[string]*OpenAPIOperation
+}
+
+/*
+ * 	Creates a validator for router's routes, using the parameters document
+ * 	declares for each operation
+ */
+func NewValidator(router *mux.Router, document OpenAPIDocument) *Validator {
+	operations := map[string]*OpenAPIOperation{}
+	for path, methods := range document.Paths {
+		for method, operation := range methods {
+			operations[strings.ToUpper(method)+" "+path] = operation
+		}
+	}
+	return &Validator{router: router, operations: operations}
+}
+
+/*
+ * 	Answers 400 listing every violation when the request's parameters do not
+ * 	match its operation; requests that match no route are passed on as is
+ */
+func (v *Validator) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	var match mux.RouteMatch
+	if !v.router.Match(req, &match) || match.Route == nil {
+		next(w, req)
+		return
+	}
+	template, _ := match.Route.GetPathTemplate()
+	operation, ok := v.operations[req.Method+" "+muxVariablePattern.ReplaceAllString(template, "{$1}")]
+	if !ok {
+		next(w, req)
+		return
+	}
+
+	l := newLocalizer(req)
+	violations := validateParameters(operation.Parameters, match.Vars, req.URL.Query(), l)
+	if len(violations) == 0 {
+		next(w, req)
+		return
+	}
+	l.SetHeader(w)
+	contextLogger := log.WithFields(log.Fields{"Path": req.URL.Path, "violations": len(violations)})
+	contextLogger.Warn("Invalid request parameters")
+	writeJSON(w, req, http.StatusBadRequest, ValidationError{
+		Message:    l.Message("validation.failed", MessageArgs{"count": len(violations)}),
+		Violations: violations,
+	}, contextLogger)
+}
+
+/*
+ * 	Checks every path and query parameter, returning one violation for each
+ * 	that is invalid. Empty query values count as absent, as handlers treat them.
+ */
+func validateParameters(parameters []OpenAPIParameter, vars map[string]string, query url.Values, l localizer) []ParameterViolation {
+	var violations []ParameterViolation
+	for _, parameter := range parameters {
+		var value string
+		switch parameter.In {
+		case "path":
+			value = vars[parameter.Name]
+		case "query":
+			value = query.Get(parameter.Name)
+		default:
+			continue
+		}
+		if value == "" {
+			if parameter.Required {
+				violations = append(violations, ParameterViolation{Name: parameter.Name, In: parameter.In,
+					Message: l.Message("validation.required", MessageArgs{"name": parameter.Name})})
+			}
+			continue
+		}
+		if message := validateValue(parameter.Name, value, parameter.Schema, l); message != "" {
+			violations = append(violations, ParameterViolation{Name: parameter.Name, In: parameter.In, Value: value, Message: message})
+		}
+	}
+	return violations
+}
+
+/*
+ * 	Returns why value does not match schema, or "" when it does. Only the
+ * 	first problem is reported.
+ */
+func validateValue(name string, value string, schema *OpenAPISchema, l localizer) string {
+	var number float64
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return l.Message("validation.integer", MessageArgs{"name": name})
+		}
+		number = float64(n)
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return l.Message("validation.number", MessageArgs{"name": name})
+		}
+		number = n
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return l.Message("validation.boolean", MessageArgs{"name": name})
+		}
+	}
+	if schema.Minimum != nil && number < *schema.Minimum {
+		return l.Message("validation.minimum", MessageArgs{"name": name, "min": strconv.FormatFloat(*schema.Minimum, 'f', -1, 64)})
+	}
+	if schema.Maximum != nil && number > *schema.Maximum {
+		return l.Message("validation.maximum", MessageArgs{"name": name, "max": strconv.FormatFloat(*schema.Maximum, 'f', -1, 64)})
+	}
+	if schema.MaxLength != nil && utf8.RuneCountInString(value) > *schema.MaxLength {
+		return l.Message("validation.maxLength", MessageArgs{"name": name, "max": *schema.MaxLength})
+	}
+	if len(schema.Enum) > 0 {
+		values := make([]string, len(schema.Enum))
+		for i, allowed := range schema.Enum {
+			values[i] = fmt.Sprint(allowed)
+			if values[i] == value {
+				return ""
+			}
+		}
+		return l.Message("validation.enum", MessageArgs{"name": name, "values": strings.Join(values, ", ")})
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/*
+ * 	Serves path through the validator in front of the router
+ */
+func serveValidated(t *testing.T, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := Router()
+	w := httptest.NewRecorder()
+	NewValidator(router, newOpenAPIDocument()).ServeHTTP(w, req, router.ServeHTTP)
+	return w
+}
+
+func TestValidatorRejectsInvalidParameters(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	tests := []struct {
+		path       string
+		violations []string
+	}{
+		{"/stations/83/-1", []string{"bikestoreturn"}},
+		{"/stations/83/0", []string{"bikestoreturn"}},
+		{"/stations/83/rent/0", []string{"bikestorent"}},
+		{"/stations/abc/many", []string{"stationid", "bikestoreturn"}},
+		{"/stations?page=0&format=xml", []string{"page", "format"}},
+		{"/stations/in-service?page=first", []string{"page"}},
+		{"/stations/" + strings.Repeat("a", maxSearchLength+1), []string{"searchstring"}},
+		{"/stations/suggest?q=atl&limit=51", []string{"limit"}},
+	}
+	for _, test := range tests {
+		w := serveValidated(t, test.path)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v but wanted %v", test.path, w.Code, http.StatusBadRequest)
+			continue
+		}
+		response := ValidationError{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: Expected a validation error, but received %s", test.path, w.Body.String())
+		}
+		var names []string
+		for _, violation := range response.Violations {
+			names = append(names, violation.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.violations, ",") {
+			t.Errorf("%s: Expected violations for %v, but received %v", test.path, test.violations, response.Violations)
+		}
+	}
+}
+
+func TestValidatorMessages(t *testing.T) {
+	w := serveValidated(t, "/stations?page=0&format=xml")
+	response := ValidationError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Message != "The request has 2 invalid parameters." {
+		t.Errorf("Expected a count of the violations, but received %q", response.Message)
+	}
+	expected := []ParameterViolation{
+		{Name: "page", In: "query", Value: "0", Message: "The parameter page must be at least 1."},
+		{Name: "format", In: "query", Value: "xml", Message: "The parameter format must be one of json, csv, ndjson."},
+	}
+	for i, violation := range expected {
+		if i >= len(response.Violations) || response.Violations[i] != violation {
+			t.Errorf("Expected %v, but received %v", violation, response.Violations)
+		}
+	}
+}
+
+func TestValidatorPassesValidRequests(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	for _, path := range []string{"/stations/83/22", "/stations?page=1&format=json", "/stations/atlantic", "/stations/suggest?q=atl&limit=50", "/nothing/here"} {
+		w := serveValidated(t, path)
+		if w.Code == http.StatusBadRequest {
+			t.Errorf("%s: Expected the request to reach the router, but received %v %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandlersRejectCountsWithoutValidator(t *testing.T) {
+	mockStationFeed(allStationsJSON)
+	for _, count := range []string{"0", "-1"} {
+		if dockableInfo := decodeDockableInfo(t, serveWithKey(t, "GET", "/stations/83/"+count, "", "")); dockableInfo.Dockable || dockableInfo.Reason != ReasonInvalidCount {
+			t.Errorf("return %s: Expected %s, but received %+v", count, ReasonInvalidCount, dockableInfo)
+		}
+		if w := serveWithKey(t, "GET", "/stations/83/rent/"+count, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("rent %s: handler returned wrong status code: got %v but wanted %v", count, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsSendBuffer   = 64
+	wsMaxMessage   = 4096
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	// wsPongWait - how long a connection may go without a pong before it is closed
+	wsPongWait = 60 * time.Second
+
+	// wsPingPeriod - how often connections are pinged; must be shorter than wsPongWait
+	wsPingPeriod = 54 * time.Second
+
+	// hub - fans station changes out to WebSocket connections by their subscriptions
+	hub = newStationHub()
+
+	wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+)
+
+// WsSubscription - station ids, areas ([minLon, minLat, maxLon, maxLat]) and/or
+// stations going out of service that a connection wants changes for
+type WsSubscription struct {
+	IDs          []int       `json:"ids,omitempty"`
+	Areas        [][]float64 `json:"areas,omitempty"`
+	OutOfService bool        `json:"outOfService,omitempty"`
+}
+
+// WsClientMessage - sent by clients to change their subscriptions
+type WsClientMessage struct {
+	Action string `json:"action"`
+	WsSubscription
+}
+
+// WsServerMessage - sent to clients: a "change" event, a "snapshot" resync, a
+// "subscriptions" acknowledgement or an "error"
+type WsServerMessage struct {
+	Type          string          `json:"type"`
+	ID            int64           `json:"id,omitempty"`
+	Station       *StationUpdate  `json:"station,omitempty"`
+	Stations      []StationUpdate `json:"stations,omitempty"`
+	Subscriptions *WsSubscription `json:"subscriptions,omitempty"`
+	Message       string          `json:"message,omitempty"`
+}
+
+// wsClient - one WebSocket connection and what it is subscribed to; the
+// subscription fields are guarded by the hub's lock
+type wsClient struct {
+	conn         *websocket.Conn
+	send         chan WsServerMessage
+	closeCode    int
+	ids          map[int]bool
+	areas        [][]float64
+	outOfService bool
+}
+
+// StationHub - the connected WebSocket clients
+type StationHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]bool
+}
+
+/*
+ * 	Creates a hub with no clients
+ */
+func newStationHub() *StationHub {
+	return &StationHub{clients: map[*wsClient]bool{}}
+}
+
+/*
+ * 	Creates a client for conn with no subscriptions
+ */
+func newWsClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		conn:      conn,
+		send:      make(chan WsServerMessage, wsSendBuffer),
+		closeCode: websocket.CloseNormalClosure,
+		ids:       map[int]bool{},
+	}
+}
+
+/*
+ * 	Returns whether update is covered by any of the client's subscriptions
+ */
+func (c *wsClient) matches(update StationUpdate) bool {
+	if c.ids[update.ID] {
+		return true
+	}
+	for _, area := range c.areas {
+		if (streamFilter{bbox: area}).matches(update) {
+			return true
+		}
+	}
+	if c.outOfService && update.StatusValue == string(notInServiceStatus) {
+		for _, field := range update.Changed {
+			if field == "statusValue" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+ * 	Returns whether a snapshot's station is covered by any of the client's
+ * 	subscriptions, counting every out-of-service station for outOfService
+ */
+func (c *wsClient) watches(update StationUpdate) bool {
+	if c.outOfService && update.StatusValue == string(notInServiceStatus) {
+		return true
+	}
+	update.Changed = nil
+	return c.matches(update)
+}
+
+/*
+ * 	Returns the client's current subscriptions
+ */
+func (c *wsClient) subscriptions() *WsSubscription {
+	subscription := &WsSubscription{Areas: c.areas, OutOfService: c.outOfService}
+	for id := range c.ids {
+		subscription.IDs = append(subscription.IDs, id)
+	}
+	sort.Ints(subscription.IDs)
+	return subscription
+}
+
+/*
+ * 	Adds a client to the hub
+ */
+func (h *StationHub) Register(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = true
+}
+
+/*
+ * 	Removes a client from the hub and closes its send channel, which makes its
+ * 	writer close the connection with closeCode
+ */
+func (h *StationHub) Unregister(c *wsClient, closeCode int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(c, closeCode)
+}
+
+/*
+ * 	Unregisters c with the hub's lock already held
+ */
+func (h *StationHub) drop(c *wsClient, closeCode int) {
+	if h.clients[c] {
+		delete(h.clients, c)
+		c.closeCode = closeCode
+		close(c.send)
+	}
+}
+
+/*
+ * 	Queues msg for c without blocking. A client whose buffer is full is
+ * 	disconnected so one slow dashboard cannot hold up the others.
+ */
+func (h *StationHub) deliver(c *wsClient, msg WsServerMessage) {
+	if !h.clients[c] {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		h.drop(c, websocket.CloseTryAgainLater)
+	}
+}
+
+/*
+ * 	Sends event to every client subscribed to its station
+ */
+func (h *StationHub) Broadcast(event streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if c.matches(event.Update) {
+			update := event.Update
+			h.deliver(c, WsServerMessage{Type: "change", ID: event.ID, Station: &update})
+		}
+	}
+}
+
+/*
+ * 	Sends every client the stations of snapshot it is subscribed to, replacing
+ * 	whatever changes it may have missed
+ */
+func (h *StationHub) Resync(snapshot []StationUpdate, snapshotID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		var stations []StationUpdate
+		for _, update := range snapshot {
+			if c.watches(update) {
+				stations = append(stations, update)
+			}
+		}
+		h.deliver(c, WsServerMessage{Type: "snapshot", ID: snapshotID, Stations: stations})
+	}
+}
+
+/*
+ * 	Applies a subscribe or unsubscribe message to c and acknowledges it with the
+ * 	client's subscriptions. Returns false if the message is invalid.
+ */
+func (h *StationHub) Apply(c *wsClient, msg WsClientMessage) bool {
+	if msg.Action != "subscribe" && msg.Action != "unsubscribe" {
+		return false
+	}
+	for _, area := range msg.Areas {
+		if len(area) != 4 {
+			return false
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subscribe := msg.Action == "subscribe"
+	for _, id := range msg.IDs {
+		if subscribe {
+			c.ids[id] = true
+		} else {
+			delete(c.ids, id)
+		}
+	}
+	for _, area := range msg.Areas {
+		index := -1
+		for i, existing := range c.areas {
+			if existing[0] == area[0] && existing[1] == area[1] && existing[2] == area[2] && existing[3] == area[3] {
+				index = i
+			}
+		}
+		if subscribe && index < 0 {
+			c.areas = append(c.areas, area)
+		} else if !subscribe && index >= 0 {
+			c.areas = append(c.areas[:index:index], c.areas[index+1:]...)
+		}
+	}
+	if msg.OutOfService {
+		c.outOfService = subscribe
+	}
+	h.deliver(c, WsServerMessage{Type: "subscriptions", Subscriptions: c.subscriptions()})
+	return true
+}
+
+/*
+ * 	Queues an error message for c
+ */
+func (h *StationHub) Reply(c *wsClient, msg WsServerMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deliver(c, msg)
+}
+
+/*
+ * 	Forwards changes from source to the hub's clients until stop is closed. The
+ * 	hub only ever does non-blocking sends, so it keeps up with source and never
+ * 	delays a feed refresh. If it still falls behind, it resubscribes and
+ * 	resyncs every client with the new snapshot.
+ */
+func (h *StationHub) Run(source *StationStream, stop <-chan struct{}) {
+	for resubscribed := false; ; resubscribed = true {
+		subscriber, snapshot, _, snapshotID := source.Subscribe("")
+		if resubscribed {
+			h.Resync(snapshot, snapshotID)
+		}
+		for open := true; open; {
+			var events []streamEvent
+			select {
+			case events, open = <-subscriber.events:
+				for _, event := range events {
+					h.Broadcast(event)
+				}
+			case <-stop:
+				source.Unsubscribe(subscriber)
+				return
+			}
+		}
+		log.Warn("Station hub fell behind the change stream, resubscribing and resyncing clients")
+	}
+}
+
+/*
+ * 	Writes queued messages and pings to the connection until the client is
+ * 	unregistered, then closes the connection
+ */
+func (c *wsClient) writePump() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, open := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !open {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, ""))
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+/*
+ * 	Reads subscription messages until the connection fails or stops answering pings
+ */
+func (c *wsClient) readPump(l localizer) {
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg WsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil || !hub.Apply(c, msg) {
+			hub.Reply(c, WsServerMessage{Type: "error", Message: l.Message("ws.invalidMessage", nil)})
+		}
+	}
+}
+
+/*
+ *	Endpoint: /ws
+ *
+ *	WebSocket carrying the same change events as /stations/stream. Clients send
+ *	{"action": "subscribe" or "unsubscribe", "ids": [72], "areas": [[minLon,
+ *	minLat, maxLon, maxLat]], "outOfService": true} at any time and receive
+ *	{"type": "change", "id": ..., "station": {...}} for matching stations. If
+ *	the server falls behind the stream it sends {"type": "snapshot", "id": ...,
+ *	"stations": [...]} with the current values of every subscribed station.
+ */
+func serveWs(w http.ResponseWriter, req *http.Request) {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.Info("Executing serveWs entrypoint")
+	contextLogger := log.WithFields(
+		log.Fields{
+			"Path": req.URL.Path,
+		},
+	)
+	l := newLocalizer(req)
+
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		contextLogger.Warn("Error upgrading to WebSocket", err)
+		return
+	}
+	c := newWsClient(conn)
+	hub.Register(c)
+	go c.writePump()
+	c.readPump(l)
+	hub.Unregister(c, websocket.CloseNormalClosure)
+}
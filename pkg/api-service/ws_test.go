@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+ * 	Starts hub forwarding from stream, and waits until it is subscribed
+ */
+func runTestHub(t *testing.T) {
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go hub.Run(stream, stop)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		stream.mu.Lock()
+		subscribed := len(stream.subscribers) > 0
+		stream.mu.Unlock()
+		if subscribed {
+			return
+		}
+	}
+	t.Fatal("Expected the hub to subscribe to the stream")
+}
+
+func TestWsClientMatches(t *testing.T) {
+	c := newWsClient(nil)
+	testHub := newStationHub()
+	testHub.Register(c)
+	testHub.Apply(c, WsClientMessage{Action: "subscribe", WsSubscription: WsSubscription{IDs: []int{72}, Areas: [][]float64{{-74, 40.6, -73.9, 40.7}}, OutOfService: true}})
+
+	tests := map[string]struct {
+		update   StationUpdate
+		expected bool
+	}{
+		"id":             {StationUpdate{ID: 72}, true},
+		"area":           {StationUpdate{ID: 83, Longitude: -73.97, Latitude: 40.68}, true},
+		"out of service": {StationUpdate{ID: 423, StatusValue: string(notInServiceStatus), Changed: []string{"statusValue"}}, true},
+		"still out":      {StationUpdate{ID: 423, StatusValue: string(notInServiceStatus), Changed: []string{"availableBikes"}}, false},
+		"elsewhere":      {StationUpdate{ID: 79, Longitude: -74.01, Latitude: 40.72}, false},
+	}
+	for name, test := range tests {
+		if matched := c.matches(test.update); matched != test.expected {
+			t.Errorf("%s: expected match %v, but received %v", name, test.expected, matched)
+		}
+	}
+
+	testHub.Apply(c, WsClientMessage{Action: "unsubscribe", WsSubscription: WsSubscription{Areas: [][]float64{{-74, 40.6, -73.9, 40.7}}, OutOfService: true}})
+	if subscriptions := c.subscriptions(); len(subscriptions.IDs) != 1 || len(subscriptions.Areas) != 0 || subscriptions.OutOfService {
+		t.Errorf("Expected only station 72 to remain subscribed, but received %+v", subscriptions)
+	}
+	if testHub.Apply(c, WsClientMessage{Action: "subscribe", WsSubscription: WsSubscription{Areas: [][]float64{{1, 2, 3}}}}) {
+		t.Errorf("Expected an area with 3 values to be rejected")
+	}
+}
+
+func TestStationHubDropsSlowClients(t *testing.T) {
+	testHub := newStationHub()
+	slow, fast := newWsClient(nil), newWsClient(nil)
+	for _, c := range []*wsClient{slow, fast} {
+		testHub.Register(c)
+		c.ids[72] = true
+	}
+	for i := 0; i < wsSendBuffer; i++ {
+		slow.send <- WsServerMessage{}
+	}
+
+	testHub.Broadcast(streamEvent{ID: 1, Update: StationUpdate{ID: 72}})
+	for range slow.send {
+	}
+	if slow.closeCode != websocket.CloseTryAgainLater || testHub.clients[slow] {
+		t.Errorf("Expected the slow client to be dropped")
+	}
+	if msg := <-fast.send; msg.ID != 1 || !testHub.clients[fast] {
+		t.Errorf("Expected the fast client to receive the change, but received %+v", msg)
+	}
+}
+
+func TestStationHubResyncsAfterFallingBehind(t *testing.T) {
+	stream, hub = newStationStream(), newStationHub()
+	engine := streamDiffs(stream)
+	engine.Publish(fixtureStationData(t))
+	c := newWsClient(nil)
+	hub.Register(c)
+	c.ids[72] = true
+	c.outOfService = true
+	runTestHub(t)
+
+	stream.mu.Lock()
+	for subscriber := range stream.subscribers {
+		delete(stream.subscribers, subscriber)
+		close(subscriber.events)
+	}
+	stream.mu.Unlock()
+	select {
+	case msg := <-c.send:
+		if msg.Type != "snapshot" || len(msg.Stations) != 2 || msg.Stations[0].ID != 72 || msg.Stations[1].ID != 423 {
+			t.Errorf("Expected a snapshot of stations 72 and 423, but received %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the hub to resync its clients after resubscribing")
+	}
+}
+
+func TestServeWs(t *testing.T) {
+	wsPingPeriod = 20 * time.Millisecond
+	defer func() { wsPingPeriod = 54 * time.Second }()
+	stream, hub = newStationStream(), newStationHub()
+	engine := streamDiffs(stream)
+	engine.Publish(fixtureStationData(t))
+	runTestHub(t)
+	server := httptest.NewServer(Router())
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pinged := make(chan bool, 1)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- true:
+		default:
+		}
+		return nil
+	})
+
+	if err := conn.WriteJSON(WsClientMessage{Action: "subscribe", WsSubscription: WsSubscription{IDs: []int{83}}}); err != nil {
+		t.Fatal(err)
+	}
+	var msg WsServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "subscriptions" || msg.Subscriptions == nil || len(msg.Subscriptions.IDs) != 1 {
+		t.Errorf("Expected the subscription to be acknowledged, but received %+v", msg)
+	}
+
+	engine.Publish(fixtureStationData(t, `"availableBikes":40`, `"availableBikes":39`, `"availableBikes":7`, `"availableBikes":6`))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "change" || msg.Station == nil || msg.Station.ID != 83 || msg.Station.AvailableBikes != 39 {
+		t.Errorf("Expected a change for station 83, but received %+v", msg)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"action":"watch"}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg = WsServerMessage{}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" || msg.Message == "" {
+		t.Errorf("Expected an error for an unknown action, but received %+v", msg)
+	}
+
+	go conn.ReadMessage()
+	select {
+	case <-pinged:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the server to ping the connection")
+	}
+}
@@ -0,0 +1,1236 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: stations.proto
+
+package stationpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StatusFilter int32
+
+const (
+	StatusFilter_STATUS_FILTER_UNSPECIFIED    StatusFilter = 0
+	StatusFilter_STATUS_FILTER_IN_SERVICE     StatusFilter = 1
+	StatusFilter_STATUS_FILTER_NOT_IN_SERVICE StatusFilter = 2
+)
+
+// Enum value maps for StatusFilter.
+var (
+	StatusFilter_name = map[int32]string{
+		0: "STATUS_FILTER_UNSPECIFIED",
+		1: "STATUS_FILTER_IN_SERVICE",
+		2: "STATUS_FILTER_NOT_IN_SERVICE",
+	}
+	StatusFilter_value = map[string]int32{
+		"STATUS_FILTER_UNSPECIFIED":    0,
+		"STATUS_FILTER_IN_SERVICE":     1,
+		"STATUS_FILTER_NOT_IN_SERVICE": 2,
+	}
+)
+
+func (x StatusFilter) Enum() *StatusFilter {
+	p := new(StatusFilter)
+	*p = x
+	return p
+}
+
+func (x StatusFilter) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StatusFilter) Descriptor() protoreflect.EnumDescriptor {
+	return file_stations_proto_enumTypes[0].Descriptor()
+}
+
+func (StatusFilter) Type() protoreflect.EnumType {
+	return &file_stations_proto_enumTypes[0]
+}
+
+func (x StatusFilter) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StatusFilter.Descriptor instead.
+func (StatusFilter) EnumDescriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{0}
+}
+
+type StationUpdate_Type int32
+
+const (
+	StationUpdate_TYPE_UNSPECIFIED StationUpdate_Type = 0
+	StationUpdate_TYPE_SNAPSHOT    StationUpdate_Type = 1
+	StationUpdate_TYPE_CHANGE      StationUpdate_Type = 2
+)
+
+// Enum value maps for StationUpdate_Type.
+var (
+	StationUpdate_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_SNAPSHOT",
+		2: "TYPE_CHANGE",
+	}
+	StationUpdate_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"TYPE_SNAPSHOT":    1,
+		"TYPE_CHANGE":      2,
+	}
+)
+
+func (x StationUpdate_Type) Enum() *StationUpdate_Type {
+	p := new(StationUpdate_Type)
+	*p = x
+	return p
+}
+
+func (x StationUpdate_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StationUpdate_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_stations_proto_enumTypes[1].Descriptor()
+}
+
+func (StationUpdate_Type) Type() protoreflect.EnumType {
+	return &file_stations_proto_enumTypes[1]
+}
+
+func (x StationUpdate_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StationUpdate_Type.Descriptor instead.
+func (StationUpdate_Type) EnumDescriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{9, 0}
+}
+
+type Station struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id                    int32   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	StationName           string  `protobuf:"bytes,2,opt,name=station_name,json=stationName,proto3" json:"station_name,omitempty"`
+	StAddress1            string  `protobuf:"bytes,3,opt,name=st_address1,json=stAddress1,proto3" json:"st_address1,omitempty"`
+	StatusValue           string  `protobuf:"bytes,4,opt,name=status_value,json=statusValue,proto3" json:"status_value,omitempty"`
+	AvailableBikes        int32   `protobuf:"varint,5,opt,name=available_bikes,json=availableBikes,proto3" json:"available_bikes,omitempty"`
+	AvailableDocks        int32   `protobuf:"varint,6,opt,name=available_docks,json=availableDocks,proto3" json:"available_docks,omitempty"`
+	TotalDocks            int32   `protobuf:"varint,7,opt,name=total_docks,json=totalDocks,proto3" json:"total_docks,omitempty"`
+	Latitude              float64 `protobuf:"fixed64,8,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude             float64 `protobuf:"fixed64,9,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	LastCommunicationTime string  `protobuf:"bytes,10,opt,name=last_communication_time,json=lastCommunicationTime,proto3" json:"last_communication_time,omitempty"`
+	// last communicated longer ago than the server's stale threshold
+	Stale bool `protobuf:"varint,11,opt,name=stale,proto3" json:"stale,omitempty"`
+}
+
+func (x *Station) Reset() {
+	*x = Station{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Station) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Station) ProtoMessage() {}
+
+func (x *Station) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Station.ProtoReflect.Descriptor instead.
+func (*Station) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Station) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Station) GetStationName() string {
+	if x != nil {
+		return x.StationName
+	}
+	return ""
+}
+
+func (x *Station) GetStAddress1() string {
+	if x != nil {
+		return x.StAddress1
+	}
+	return ""
+}
+
+func (x *Station) GetStatusValue() string {
+	if x != nil {
+		return x.StatusValue
+	}
+	return ""
+}
+
+func (x *Station) GetAvailableBikes() int32 {
+	if x != nil {
+		return x.AvailableBikes
+	}
+	return 0
+}
+
+func (x *Station) GetAvailableDocks() int32 {
+	if x != nil {
+		return x.AvailableDocks
+	}
+	return 0
+}
+
+func (x *Station) GetTotalDocks() int32 {
+	if x != nil {
+		return x.TotalDocks
+	}
+	return 0
+}
+
+func (x *Station) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *Station) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *Station) GetLastCommunicationTime() string {
+	if x != nil {
+		return x.LastCommunicationTime
+	}
+	return ""
+}
+
+func (x *Station) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+type ListStationsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Status StatusFilter `protobuf:"varint,1,opt,name=status,proto3,enum=stations.v1.StatusFilter" json:"status,omitempty"`
+	// 1-based page of 20 stations; 0 returns every station
+	Page int32 `protobuf:"varint,2,opt,name=page,proto3" json:"page,omitempty"`
+	// also list test stations
+	IncludeTest bool `protobuf:"varint,3,opt,name=include_test,json=includeTest,proto3" json:"include_test,omitempty"`
+	// also list stations that have stopped communicating
+	IncludeStale bool `protobuf:"varint,4,opt,name=include_stale,json=includeStale,proto3" json:"include_stale,omitempty"`
+}
+
+func (x *ListStationsRequest) Reset() {
+	*x = ListStationsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListStationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListStationsRequest) ProtoMessage() {}
+
+func (x *ListStationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListStationsRequest.ProtoReflect.Descriptor instead.
+func (*ListStationsRequest) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListStationsRequest) GetStatus() StatusFilter {
+	if x != nil {
+		return x.Status
+	}
+	return StatusFilter_STATUS_FILTER_UNSPECIFIED
+}
+
+func (x *ListStationsRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListStationsRequest) GetIncludeTest() bool {
+	if x != nil {
+		return x.IncludeTest
+	}
+	return false
+}
+
+func (x *ListStationsRequest) GetIncludeStale() bool {
+	if x != nil {
+		return x.IncludeStale
+	}
+	return false
+}
+
+type ListStationsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// the feed's executionTime, New York local time
+	ExecutionTime string     `protobuf:"bytes,1,opt,name=execution_time,json=executionTime,proto3" json:"execution_time,omitempty"`
+	Stations      []*Station `protobuf:"bytes,2,rep,name=stations,proto3" json:"stations,omitempty"`
+}
+
+func (x *ListStationsResponse) Reset() {
+	*x = ListStationsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListStationsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListStationsResponse) ProtoMessage() {}
+
+func (x *ListStationsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListStationsResponse.ProtoReflect.Descriptor instead.
+func (*ListStationsResponse) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListStationsResponse) GetExecutionTime() string {
+	if x != nil {
+		return x.ExecutionTime
+	}
+	return ""
+}
+
+func (x *ListStationsResponse) GetStations() []*Station {
+	if x != nil {
+		return x.Stations
+	}
+	return nil
+}
+
+type GetStationRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id int32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *GetStationRequest) Reset() {
+	*x = GetStationRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetStationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStationRequest) ProtoMessage() {}
+
+func (x *GetStationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStationRequest.ProtoReflect.Descriptor instead.
+func (*GetStationRequest) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetStationRequest) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type SearchStationsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Query string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	// also match test stations
+	IncludeTest bool `protobuf:"varint,2,opt,name=include_test,json=includeTest,proto3" json:"include_test,omitempty"`
+	// also match stations that have stopped communicating
+	IncludeStale bool `protobuf:"varint,3,opt,name=include_stale,json=includeStale,proto3" json:"include_stale,omitempty"`
+}
+
+func (x *SearchStationsRequest) Reset() {
+	*x = SearchStationsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchStationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchStationsRequest) ProtoMessage() {}
+
+func (x *SearchStationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchStationsRequest.ProtoReflect.Descriptor instead.
+func (*SearchStationsRequest) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SearchStationsRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchStationsRequest) GetIncludeTest() bool {
+	if x != nil {
+		return x.IncludeTest
+	}
+	return false
+}
+
+func (x *SearchStationsRequest) GetIncludeStale() bool {
+	if x != nil {
+		return x.IncludeStale
+	}
+	return false
+}
+
+type CheckDockableRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StationId int32 `protobuf:"varint,1,opt,name=station_id,json=stationId,proto3" json:"station_id,omitempty"`
+	Bikes     int32 `protobuf:"varint,2,opt,name=bikes,proto3" json:"bikes,omitempty"`
+}
+
+func (x *CheckDockableRequest) Reset() {
+	*x = CheckDockableRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CheckDockableRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckDockableRequest) ProtoMessage() {}
+
+func (x *CheckDockableRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckDockableRequest.ProtoReflect.Descriptor instead.
+func (*CheckDockableRequest) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CheckDockableRequest) GetStationId() int32 {
+	if x != nil {
+		return x.StationId
+	}
+	return 0
+}
+
+func (x *CheckDockableRequest) GetBikes() int32 {
+	if x != nil {
+		return x.Bikes
+	}
+	return 0
+}
+
+type StationOption struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id          int32   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	StationName string  `protobuf:"bytes,2,opt,name=station_name,json=stationName,proto3" json:"station_name,omitempty"`
+	StAddress1  string  `protobuf:"bytes,3,opt,name=st_address1,json=stAddress1,proto3" json:"st_address1,omitempty"`
+	Available   int32   `protobuf:"varint,4,opt,name=available,proto3" json:"available,omitempty"`
+	Bikes       int32   `protobuf:"varint,5,opt,name=bikes,proto3" json:"bikes,omitempty"`
+	DistanceKm  float64 `protobuf:"fixed64,6,opt,name=distance_km,json=distanceKm,proto3" json:"distance_km,omitempty"`
+}
+
+func (x *StationOption) Reset() {
+	*x = StationOption{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StationOption) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StationOption) ProtoMessage() {}
+
+func (x *StationOption) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StationOption.ProtoReflect.Descriptor instead.
+func (*StationOption) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *StationOption) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *StationOption) GetStationName() string {
+	if x != nil {
+		return x.StationName
+	}
+	return ""
+}
+
+func (x *StationOption) GetStAddress1() string {
+	if x != nil {
+		return x.StAddress1
+	}
+	return ""
+}
+
+func (x *StationOption) GetAvailable() int32 {
+	if x != nil {
+		return x.Available
+	}
+	return 0
+}
+
+func (x *StationOption) GetBikes() int32 {
+	if x != nil {
+		return x.Bikes
+	}
+	return 0
+}
+
+func (x *StationOption) GetDistanceKm() float64 {
+	if x != nil {
+		return x.DistanceKm
+	}
+	return 0
+}
+
+type DockableInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Dockable bool   `protobuf:"varint,1,opt,name=dockable,proto3" json:"dockable,omitempty"`
+	Message  string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	// OK, INSUFFICIENT_DOCKS, NOT_IN_SERVICE, STATION_UNKNOWN or INVALID_COUNT
+	Reason         string           `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	StationStatus  string           `protobuf:"bytes,4,opt,name=station_status,json=stationStatus,proto3" json:"station_status,omitempty"`
+	AvailableDocks int32            `protobuf:"varint,5,opt,name=available_docks,json=availableDocks,proto3" json:"available_docks,omitempty"`
+	Requested      int32            `protobuf:"varint,6,opt,name=requested,proto3" json:"requested,omitempty"`
+	Shortfall      int32            `protobuf:"varint,7,opt,name=shortfall,proto3" json:"shortfall,omitempty"`
+	Alternatives   []*StationOption `protobuf:"bytes,8,rep,name=alternatives,proto3" json:"alternatives,omitempty"`
+	Split          []*StationOption `protobuf:"bytes,9,rep,name=split,proto3" json:"split,omitempty"`
+	// set when the station has stopped communicating and its numbers may be out of date
+	Warning string `protobuf:"bytes,10,opt,name=warning,proto3" json:"warning,omitempty"`
+}
+
+func (x *DockableInfo) Reset() {
+	*x = DockableInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DockableInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DockableInfo) ProtoMessage() {}
+
+func (x *DockableInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DockableInfo.ProtoReflect.Descriptor instead.
+func (*DockableInfo) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DockableInfo) GetDockable() bool {
+	if x != nil {
+		return x.Dockable
+	}
+	return false
+}
+
+func (x *DockableInfo) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *DockableInfo) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *DockableInfo) GetStationStatus() string {
+	if x != nil {
+		return x.StationStatus
+	}
+	return ""
+}
+
+func (x *DockableInfo) GetAvailableDocks() int32 {
+	if x != nil {
+		return x.AvailableDocks
+	}
+	return 0
+}
+
+func (x *DockableInfo) GetRequested() int32 {
+	if x != nil {
+		return x.Requested
+	}
+	return 0
+}
+
+func (x *DockableInfo) GetShortfall() int32 {
+	if x != nil {
+		return x.Shortfall
+	}
+	return 0
+}
+
+func (x *DockableInfo) GetAlternatives() []*StationOption {
+	if x != nil {
+		return x.Alternatives
+	}
+	return nil
+}
+
+func (x *DockableInfo) GetSplit() []*StationOption {
+	if x != nil {
+		return x.Split
+	}
+	return nil
+}
+
+func (x *DockableInfo) GetWarning() string {
+	if x != nil {
+		return x.Warning
+	}
+	return ""
+}
+
+type WatchStationsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// only these stations; empty for all
+	Ids []int32 `protobuf:"varint,1,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+	// only stations inside [minLon, minLat, maxLon, maxLat]; empty for all
+	Bbox []float64 `protobuf:"fixed64,2,rep,packed,name=bbox,proto3" json:"bbox,omitempty"`
+}
+
+func (x *WatchStationsRequest) Reset() {
+	*x = WatchStationsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchStationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStationsRequest) ProtoMessage() {}
+
+func (x *WatchStationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStationsRequest.ProtoReflect.Descriptor instead.
+func (*WatchStationsRequest) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchStationsRequest) GetIds() []int32 {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+func (x *WatchStationsRequest) GetBbox() []float64 {
+	if x != nil {
+		return x.Bbox
+	}
+	return nil
+}
+
+type StationUpdate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type StationUpdate_Type `protobuf:"varint,1,opt,name=type,proto3,enum=stations.v1.StationUpdate_Type" json:"type,omitempty"`
+	// the event id, as sent in the SSE stream's id field
+	EventId int64    `protobuf:"varint,2,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	Station *Station `protobuf:"bytes,3,opt,name=station,proto3" json:"station,omitempty"`
+	// for changes, the Station fields that changed
+	Changed []string `protobuf:"bytes,4,rep,name=changed,proto3" json:"changed,omitempty"`
+}
+
+func (x *StationUpdate) Reset() {
+	*x = StationUpdate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_stations_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StationUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StationUpdate) ProtoMessage() {}
+
+func (x *StationUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_stations_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StationUpdate.ProtoReflect.Descriptor instead.
+func (*StationUpdate) Descriptor() ([]byte, []int) {
+	return file_stations_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StationUpdate) GetType() StationUpdate_Type {
+	if x != nil {
+		return x.Type
+	}
+	return StationUpdate_TYPE_UNSPECIFIED
+}
+
+func (x *StationUpdate) GetEventId() int64 {
+	if x != nil {
+		return x.EventId
+	}
+	return 0
+}
+
+func (x *StationUpdate) GetStation() *Station {
+	if x != nil {
+		return x.Station
+	}
+	return nil
+}
+
+func (x *StationUpdate) GetChanged() []string {
+	if x != nil {
+		return x.Changed
+	}
+	return nil
+}
+
+var File_stations_proto protoreflect.FileDescriptor
+
+var file_stations_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x0b, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x22, 0xfb, 0x02,
+	0x0a, 0x07, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x69, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x74, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0b, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1f, 0x0a, 0x0b,
+	0x73, 0x74, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x31, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x73, 0x74, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x31, 0x12, 0x21, 0x0a,
+	0x0c, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x56, 0x61, 0x6c, 0x75, 0x65,
+	0x12, 0x27, 0x0a, 0x0f, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x62, 0x69,
+	0x6b, 0x65, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0e, 0x61, 0x76, 0x61, 0x69, 0x6c,
+	0x61, 0x62, 0x6c, 0x65, 0x42, 0x69, 0x6b, 0x65, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x61, 0x76, 0x61,
+	0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x64, 0x6f, 0x63, 0x6b, 0x73, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x0e, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x44, 0x6f, 0x63,
+	0x6b, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x64, 0x6f, 0x63, 0x6b,
+	0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x44, 0x6f,
+	0x63, 0x6b, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x61, 0x74, 0x69, 0x74, 0x75, 0x64, 0x65, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x6c, 0x61, 0x74, 0x69, 0x74, 0x75, 0x64, 0x65, 0x12,
+	0x1c, 0x0a, 0x09, 0x6c, 0x6f, 0x6e, 0x67, 0x69, 0x74, 0x75, 0x64, 0x65, 0x18, 0x09, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x09, 0x6c, 0x6f, 0x6e, 0x67, 0x69, 0x74, 0x75, 0x64, 0x65, 0x12, 0x36, 0x0a,
+	0x17, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x63, 0x6f, 0x6d, 0x6d, 0x75, 0x6e, 0x69, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x15,
+	0x6c, 0x61, 0x73, 0x74, 0x43, 0x6f, 0x6d, 0x6d, 0x75, 0x6e, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x18, 0x0b,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x22, 0xa4, 0x01, 0x0a, 0x13,
+	0x4c, 0x69, 0x73, 0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x31, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x19, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76,
+	0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x52, 0x06,
+	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x67, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x70, 0x61, 0x67, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x69, 0x6e,
+	0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0b, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x54, 0x65, 0x73, 0x74, 0x12, 0x23, 0x0a,
+	0x0d, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x53, 0x74, 0x61,
+	0x6c, 0x65, 0x22, 0x6f, 0x0a, 0x14, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x65, 0x78,
+	0x65, 0x63, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0d, 0x65, 0x78, 0x65, 0x63, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x69, 0x6d,
+	0x65, 0x12, 0x30, 0x0a, 0x08, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76,
+	0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x73, 0x74, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x22, 0x23, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x69, 0x64, 0x22, 0x75, 0x0a, 0x15, 0x53, 0x65, 0x61, 0x72,
+	0x63, 0x68, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x14, 0x0a, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x12, 0x21, 0x0a, 0x0c, 0x69, 0x6e, 0x63, 0x6c, 0x75,
+	0x64, 0x65, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x69,
+	0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x54, 0x65, 0x73, 0x74, 0x12, 0x23, 0x0a, 0x0d, 0x69, 0x6e,
+	0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0c, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x53, 0x74, 0x61, 0x6c, 0x65, 0x22,
+	0x4b, 0x0a, 0x14, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44, 0x6f, 0x63, 0x6b, 0x61, 0x62, 0x6c, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x73, 0x74, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x62, 0x69, 0x6b, 0x65, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x62, 0x69, 0x6b, 0x65, 0x73, 0x22, 0xb8, 0x01, 0x0a,
+	0x0d, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x0e,
+	0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x69, 0x64, 0x12, 0x21,
+	0x0a, 0x0c, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d,
+	0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x74, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x31,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x73, 0x74, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73,
+	0x73, 0x31, 0x12, 0x1c, 0x0a, 0x09, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65,
+	0x12, 0x14, 0x0a, 0x05, 0x62, 0x69, 0x6b, 0x65, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x05, 0x62, 0x69, 0x6b, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x64, 0x69, 0x73, 0x74, 0x61, 0x6e,
+	0x63, 0x65, 0x5f, 0x6b, 0x6d, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x64, 0x69, 0x73,
+	0x74, 0x61, 0x6e, 0x63, 0x65, 0x4b, 0x6d, 0x22, 0xf4, 0x02, 0x0a, 0x0c, 0x44, 0x6f, 0x63, 0x6b,
+	0x61, 0x62, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x6f, 0x63, 0x6b,
+	0x61, 0x62, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x64, 0x6f, 0x63, 0x6b,
+	0x61, 0x62, 0x6c, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x16,
+	0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x25, 0x0a, 0x0e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d,
+	0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x27, 0x0a,
+	0x0f, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x64, 0x6f, 0x63, 0x6b, 0x73,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0e, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c,
+	0x65, 0x44, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x72, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x65, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x66, 0x61, 0x6c,
+	0x6c, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x66, 0x61,
+	0x6c, 0x6c, 0x12, 0x3e, 0x0a, 0x0c, 0x61, 0x6c, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x74, 0x69, 0x76,
+	0x65, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4f, 0x70,
+	0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0c, 0x61, 0x6c, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x74, 0x69, 0x76,
+	0x65, 0x73, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x70, 0x6c, 0x69, 0x74, 0x18, 0x09, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x1a, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e,
+	0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x05, 0x73,
+	0x70, 0x6c, 0x69, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x77, 0x61, 0x72, 0x6e, 0x69, 0x6e, 0x67, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x77, 0x61, 0x72, 0x6e, 0x69, 0x6e, 0x67, 0x22, 0x3c,
+	0x0a, 0x14, 0x57, 0x61, 0x74, 0x63, 0x68, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x05, 0x52, 0x03, 0x69, 0x64, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x62, 0x62, 0x6f, 0x78,
+	0x18, 0x02, 0x20, 0x03, 0x28, 0x01, 0x52, 0x04, 0x62, 0x62, 0x6f, 0x78, 0x22, 0xeb, 0x01, 0x0a,
+	0x0d, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x12, 0x33,
+	0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1f, 0x2e, 0x73,
+	0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74,
+	0x79, 0x70, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x2e,
+	0x0a, 0x07, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x18,
+	0x0a, 0x07, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x07, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x22, 0x40, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65,
+	0x12, 0x14, 0x0a, 0x10, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49,
+	0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x11, 0x0a, 0x0d, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x53,
+	0x4e, 0x41, 0x50, 0x53, 0x48, 0x4f, 0x54, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x54, 0x59, 0x50,
+	0x45, 0x5f, 0x43, 0x48, 0x41, 0x4e, 0x47, 0x45, 0x10, 0x02, 0x2a, 0x6d, 0x0a, 0x0c, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x19, 0x53, 0x54,
+	0x41, 0x54, 0x55, 0x53, 0x5f, 0x46, 0x49, 0x4c, 0x54, 0x45, 0x52, 0x5f, 0x55, 0x4e, 0x53, 0x50,
+	0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x1c, 0x0a, 0x18, 0x53, 0x54, 0x41,
+	0x54, 0x55, 0x53, 0x5f, 0x46, 0x49, 0x4c, 0x54, 0x45, 0x52, 0x5f, 0x49, 0x4e, 0x5f, 0x53, 0x45,
+	0x52, 0x56, 0x49, 0x43, 0x45, 0x10, 0x01, 0x12, 0x20, 0x0a, 0x1c, 0x53, 0x54, 0x41, 0x54, 0x55,
+	0x53, 0x5f, 0x46, 0x49, 0x4c, 0x54, 0x45, 0x52, 0x5f, 0x4e, 0x4f, 0x54, 0x5f, 0x49, 0x4e, 0x5f,
+	0x53, 0x45, 0x52, 0x56, 0x49, 0x43, 0x45, 0x10, 0x02, 0x32, 0xa3, 0x03, 0x0a, 0x0e, 0x53, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x53, 0x0a, 0x0c,
+	0x4c, 0x69, 0x73, 0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x20, 0x2e, 0x73,
+	0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53,
+	0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21,
+	0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73,
+	0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x42, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x1e, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65,
+	0x74, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x14, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x57, 0x0a, 0x0e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x53,
+	0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x22, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x53, 0x74, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x73, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4d,
+	0x0a, 0x0d, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44, 0x6f, 0x63, 0x6b, 0x61, 0x62, 0x6c, 0x65, 0x12,
+	0x21, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x68,
+	0x65, 0x63, 0x6b, 0x44, 0x6f, 0x63, 0x6b, 0x61, 0x62, 0x6c, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x19, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31,
+	0x2e, 0x44, 0x6f, 0x63, 0x6b, 0x61, 0x62, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x50, 0x0a,
+	0x0d, 0x57, 0x61, 0x74, 0x63, 0x68, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x21,
+	0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x57, 0x61, 0x74,
+	0x63, 0x68, 0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1a, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e,
+	0x53, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x30, 0x01, 0x42,
+	0x2d, 0x5a, 0x2b, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x72, 0x6f,
+	0x70, 0x65, 0x6e, 0x74, 0x61, 0x2f, 0x67, 0x6f, 0x6c, 0x61, 0x6e, 0x67, 0x2d, 0x61, 0x70, 0x69,
+	0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x70, 0x62, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_stations_proto_rawDescOnce sync.Once
+	file_stations_proto_rawDescData = file_stations_proto_rawDesc
+)
+
+func file_stations_proto_rawDescGZIP() []byte {
+	file_stations_proto_rawDescOnce.Do(func() {
+		file_stations_proto_rawDescData = protoimpl.X.CompressGZIP(file_stations_proto_rawDescData)
+	})
+	return file_stations_proto_rawDescData
+}
+
+var file_stations_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_stations_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_stations_proto_goTypes = []interface{}{
+	(StatusFilter)(0),             // 0: stations.v1.StatusFilter
+	(StationUpdate_Type)(0),       // 1: stations.v1.StationUpdate.Type
+	(*Station)(nil),               // 2: stations.v1.Station
+	(*ListStationsRequest)(nil),   // 3: stations.v1.ListStationsRequest
+	(*ListStationsResponse)(nil),  // 4: stations.v1.ListStationsResponse
+	(*GetStationRequest)(nil),     // 5: stations.v1.GetStationRequest
+	(*SearchStationsRequest)(nil), // 6: stations.v1.SearchStationsRequest
+	(*CheckDockableRequest)(nil),  // 7: stations.v1.CheckDockableRequest
+	(*StationOption)(nil),         // 8: stations.v1.StationOption
+	(*DockableInfo)(nil),          // 9: stations.v1.DockableInfo
+	(*WatchStationsRequest)(nil),  // 10: stations.v1.WatchStationsRequest
+	(*StationUpdate)(nil),         // 11: stations.v1.StationUpdate
+}
+var file_stations_proto_depIdxs = []int32{
+	0,  // 0: stations.v1.ListStationsRequest.status:type_name -> stations.v1.StatusFilter
+	2,  // 1: stations.v1.ListStationsResponse.stations:type_name -> stations.v1.Station
+	8,  // 2: stations.v1.DockableInfo.alternatives:type_name -> stations.v1.StationOption
+	8,  // 3: stations.v1.DockableInfo.split:type_name -> stations.v1.StationOption
+	1,  // 4: stations.v1.StationUpdate.type:type_name -> stations.v1.StationUpdate.Type
+	2,  // 5: stations.v1.StationUpdate.station:type_name -> stations.v1.Station
+	3,  // 6: stations.v1.StationService.ListStations:input_type -> stations.v1.ListStationsRequest
+	5,  // 7: stations.v1.StationService.GetStation:input_type -> stations.v1.GetStationRequest
+	6,  // 8: stations.v1.StationService.SearchStations:input_type -> stations.v1.SearchStationsRequest
+	7,  // 9: stations.v1.StationService.CheckDockable:input_type -> stations.v1.CheckDockableRequest
+	10, // 10: stations.v1.StationService.WatchStations:input_type -> stations.v1.WatchStationsRequest
+	4,  // 11: stations.v1.StationService.ListStations:output_type -> stations.v1.ListStationsResponse
+	2,  // 12: stations.v1.StationService.GetStation:output_type -> stations.v1.Station
+	4,  // 13: stations.v1.StationService.SearchStations:output_type -> stations.v1.ListStationsResponse
+	9,  // 14: stations.v1.StationService.CheckDockable:output_type -> stations.v1.DockableInfo
+	11, // 15: stations.v1.StationService.WatchStations:output_type -> stations.v1.StationUpdate
+	11, // [11:16] is the sub-list for method output_type
+	6,  // [6:11] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_stations_proto_init() }
+func file_stations_proto_init() {
+	if File_stations_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_stations_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Station); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListStationsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListStationsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetStationRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SearchStationsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CheckDockableRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StationOption); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DockableInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchStationsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_stations_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StationUpdate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_stations_proto_rawDesc,
+			NumEnums:      2,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_stations_proto_goTypes,
+		DependencyIndexes: file_stations_proto_depIdxs,
+		EnumInfos:         file_stations_proto_enumTypes,
+		MessageInfos:      file_stations_proto_msgTypes,
+	}.Build()
+	File_stations_proto = out.File
+	file_stations_proto_rawDesc = nil
+	file_stations_proto_goTypes = nil
+	file_stations_proto_depIdxs = nil
+}
@@ -0,0 +1,283 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package stationpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// StationServiceClient is the client API for StationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StationServiceClient interface {
+	// Lists stations from the current snapshot, optionally by status and page
+	ListStations(ctx context.Context, in *ListStationsRequest, opts ...grpc.CallOption) (*ListStationsResponse, error)
+	// Returns one station by id
+	GetStation(ctx context.Context, in *GetStationRequest, opts ...grpc.CallOption) (*Station, error)
+	// Searches station names and street addresses, ignoring case
+	SearchStations(ctx context.Context, in *SearchStationsRequest, opts ...grpc.CallOption) (*ListStationsResponse, error)
+	// Decides whether bikes can be returned to a station, as /stations/{id}/{bikes} does
+	CheckDockable(ctx context.Context, in *CheckDockableRequest, opts ...grpc.CallOption) (*DockableInfo, error)
+	// Sends a snapshot of the matching stations, then every change to them
+	WatchStations(ctx context.Context, in *WatchStationsRequest, opts ...grpc.CallOption) (StationService_WatchStationsClient, error)
+}
+
+type stationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStationServiceClient(cc grpc.ClientConnInterface) StationServiceClient {
+	return &stationServiceClient{cc}
+}
+
+func (c *stationServiceClient) ListStations(ctx context.Context, in *ListStationsRequest, opts ...grpc.CallOption) (*ListStationsResponse, error) {
+	out := new(ListStationsResponse)
+	err := c.cc.Invoke(ctx, "/stations.v1.StationService/ListStations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stationServiceClient) GetStation(ctx context.Context, in *GetStationRequest, opts ...grpc.CallOption) (*Station, error) {
+	out := new(Station)
+	err := c.cc.Invoke(ctx, "/stations.v1.StationService/GetStation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stationServiceClient) SearchStations(ctx context.Context, in *SearchStationsRequest, opts ...grpc.CallOption) (*ListStationsResponse, error) {
+	out := new(ListStationsResponse)
+	err := c.cc.Invoke(ctx, "/stations.v1.StationService/SearchStations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stationServiceClient) CheckDockable(ctx context.Context, in *CheckDockableRequest, opts ...grpc.CallOption) (*DockableInfo, error) {
+	out := new(DockableInfo)
+	err := c.cc.Invoke(ctx, "/stations.v1.StationService/CheckDockable", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stationServiceClient) WatchStations(ctx context.Context, in *WatchStationsRequest, opts ...grpc.CallOption) (StationService_WatchStationsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StationService_ServiceDesc.Streams[0], "/stations.v1.StationService/WatchStations", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &stationServiceWatchStationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StationService_WatchStationsClient interface {
+	Recv() (*StationUpdate, error)
+	grpc.ClientStream
+}
+
+type stationServiceWatchStationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *stationServiceWatchStationsClient) Recv() (*StationUpdate, error) {
+	m := new(StationUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StationServiceServer is the server API for StationService service.
+// All implementations must embed UnimplementedStationServiceServer
+// for forward compatibility
+type StationServiceServer interface {
+	// Lists stations from the current snapshot, optionally by status and page
+	ListStations(context.Context, *ListStationsRequest) (*ListStationsResponse, error)
+	// Returns one station by id
+	GetStation(context.Context, *GetStationRequest) (*Station, error)
+	// Searches station names and street addresses, ignoring case
+	SearchStations(context.Context, *SearchStationsRequest) (*ListStationsResponse, error)
+	// Decides whether bikes can be returned to a station, as /stations/{id}/{bikes} does
+	CheckDockable(context.Context, *CheckDockableRequest) (*DockableInfo, error)
+	// Sends a snapshot of the matching stations, then every change to them
+	WatchStations(*WatchStationsRequest, StationService_WatchStationsServer) error
+	mustEmbedUnimplementedStationServiceServer()
+}
+
+// UnimplementedStationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedStationServiceServer struct {
+}
+
+func (UnimplementedStationServiceServer) ListStations(context.Context, *ListStationsRequest) (*ListStationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStations not implemented")
+}
+func (UnimplementedStationServiceServer) GetStation(context.Context, *GetStationRequest) (*Station, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStation not implemented")
+}
+func (UnimplementedStationServiceServer) SearchStations(context.Context, *SearchStationsRequest) (*ListStationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchStations not implemented")
+}
+func (UnimplementedStationServiceServer) CheckDockable(context.Context, *CheckDockableRequest) (*DockableInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckDockable not implemented")
+}
+func (UnimplementedStationServiceServer) WatchStations(*WatchStationsRequest, StationService_WatchStationsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStations not implemented")
+}
+func (UnimplementedStationServiceServer) mustEmbedUnimplementedStationServiceServer() {}
+
+// UnsafeStationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StationServiceServer will
+// result in compilation errors.
+type UnsafeStationServiceServer interface {
+	mustEmbedUnimplementedStationServiceServer()
+}
+
+func RegisterStationServiceServer(s grpc.ServiceRegistrar, srv StationServiceServer) {
+	s.RegisterService(&StationService_ServiceDesc, srv)
+}
+
+func _StationService_ListStations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StationServiceServer).ListStations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/stations.v1.StationService/ListStations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StationServiceServer).ListStations(ctx, req.(*ListStationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StationService_GetStation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StationServiceServer).GetStation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/stations.v1.StationService/GetStation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StationServiceServer).GetStation(ctx, req.(*GetStationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StationService_SearchStations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchStationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StationServiceServer).SearchStations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/stations.v1.StationService/SearchStations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StationServiceServer).SearchStations(ctx, req.(*SearchStationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StationService_CheckDockable_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckDockableRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StationServiceServer).CheckDockable(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/stations.v1.StationService/CheckDockable",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StationServiceServer).CheckDockable(ctx, req.(*CheckDockableRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StationService_WatchStations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStationsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StationServiceServer).WatchStations(m, &stationServiceWatchStationsServer{stream})
+}
+
+type StationService_WatchStationsServer interface {
+	Send(*StationUpdate) error
+	grpc.ServerStream
+}
+
+type stationServiceWatchStationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *stationServiceWatchStationsServer) Send(m *StationUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// StationService_ServiceDesc is the grpc.ServiceDesc for StationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "stations.v1.StationService",
+	HandlerType: (*StationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListStations",
+			Handler:    _StationService_ListStations_Handler,
+		},
+		{
+			MethodName: "GetStation",
+			Handler:    _StationService_GetStation_Handler,
+		},
+		{
+			MethodName: "SearchStations",
+			Handler:    _StationService_SearchStations_Handler,
+		},
+		{
+			MethodName: "CheckDockable",
+			Handler:    _StationService_CheckDockable_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStations",
+			Handler:       _StationService_WatchStations_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "stations.proto",
+}